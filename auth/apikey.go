@@ -0,0 +1,60 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ApiKeyHeader is the header the server-to-server clients send their api key with
+const ApiKeyHeader = "X-API-Key"
+
+// apiKeyPrefix mark the string as an openmerce api key, so it can be found by secret scanners
+const apiKeyPrefix = "om_"
+
+// GenerateApiKey return a new api key formatted as om_<lookup>.<secret>, the lookup is stored in plain to find the key
+// and only the sha256 hash of the whole key is stored
+func GenerateApiKey() (key string, lookup string, hash []byte, err error) {
+	b := make([]byte, 6)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", nil, err
+	}
+	lookup = hex.EncodeToString(b)
+	key = apiKeyPrefix + lookup + "." + GenerateRandomString(32)
+	return key, lookup, HashApiKey(key), nil
+}
+
+// ParseApiKey return the lookup part of the api key
+func ParseApiKey(key string) (string, bool) {
+	lookup, _, found := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), ".")
+	if !found || !strings.HasPrefix(key, apiKeyPrefix) || len(lookup) != 12 {
+		return "", false
+	}
+	return lookup, true
+}
+
+func HashApiKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
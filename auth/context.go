@@ -0,0 +1,65 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keys of the verified claims stored in the gin context by the TokenExpired middlewares
+const (
+	customerClaimsKey = "claims_customer"
+	staffClaimsKey    = "claims_staff"
+)
+
+// RequestToken return the token of the "Authorization: Bearer" header, or the cookie when the header is not sent
+func RequestToken(c *gin.Context, cookieName string) (string, bool) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		token, found := strings.CutPrefix(header, "Bearer ")
+		return strings.TrimSpace(token), found && token != ""
+	}
+	token, err := c.Cookie(cookieName)
+	return token, err == nil && token != ""
+}
+
+func SetCustomerClaims(c *gin.Context, claims *JWTClaimAccessTokenCustomer) {
+	c.Set(customerClaimsKey, claims)
+}
+
+// CustomerClaims return the claims verified by the TokenExpiredCustomer middleware, it is nil on unprotected routes
+func CustomerClaims(c *gin.Context) *JWTClaimAccessTokenCustomer {
+	claims, _ := c.Get(customerClaimsKey)
+	customerClaims, _ := claims.(*JWTClaimAccessTokenCustomer)
+	return customerClaims
+}
+
+func SetStaffClaims(c *gin.Context, claims *JWTClaimAccessTokenStaff) {
+	c.Set(staffClaimsKey, claims)
+}
+
+// StaffClaims return the claims verified by the TokenExpiredStaff middleware, it is nil on unprotected routes
+func StaffClaims(c *gin.Context) *JWTClaimAccessTokenStaff {
+	claims, _ := c.Get(staffClaimsKey)
+	staffClaims, _ := claims.(*JWTClaimAccessTokenStaff)
+	return staffClaims
+}
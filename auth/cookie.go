@@ -0,0 +1,113 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CookieConfig hold the attributes of every cookie set by the backend
+type CookieConfig struct {
+	Secure   bool
+	Domain   string
+	SameSite http.SameSite
+	// AccessMaxAge is also how long an access token is accepted by the TokenExpired middlewares
+	AccessMaxAge  time.Duration
+	RefreshMaxAge time.Duration
+}
+
+// Cookie is the configuration read by ReadCookieEnv, the default is meant for local development over http
+var Cookie = CookieConfig{
+	SameSite:      http.SameSiteStrictMode,
+	AccessMaxAge:  3 * time.Minute,
+	RefreshMaxAge: 14 * 24 * time.Hour,
+}
+
+// ReadCookieEnv read COOKIE_SECURE, COOKIE_DOMAIN, COOKIE_SAMESITE (strict, lax or none),
+// COOKIE_ACCESS_MAX_AGE and COOKIE_REFRESH_MAX_AGE (e.g. 3m, 336h)
+func ReadCookieEnv() error {
+	if value := os.Getenv("COOKIE_SECURE"); value != "" {
+		secure, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid COOKIE_SECURE: %w", err)
+		}
+		Cookie.Secure = secure
+	}
+	Cookie.Domain = os.Getenv("COOKIE_DOMAIN")
+	switch strings.ToLower(os.Getenv("COOKIE_SAMESITE")) {
+	case "", "strict":
+		Cookie.SameSite = http.SameSiteStrictMode
+	case "lax":
+		Cookie.SameSite = http.SameSiteLaxMode
+	case "none":
+		// browsers reject SameSite=None cookie without the Secure attribute
+		if !Cookie.Secure {
+			return fmt.Errorf("COOKIE_SAMESITE=none requires COOKIE_SECURE=true")
+		}
+		Cookie.SameSite = http.SameSiteNoneMode
+	default:
+		return fmt.Errorf("invalid COOKIE_SAMESITE: %s", os.Getenv("COOKIE_SAMESITE"))
+	}
+	if value := os.Getenv("COOKIE_ACCESS_MAX_AGE"); value != "" {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil || maxAge < time.Minute || maxAge%time.Minute != 0 {
+			return fmt.Errorf("COOKIE_ACCESS_MAX_AGE must be a whole number of minutes")
+		}
+		Cookie.AccessMaxAge = maxAge
+	}
+	if value := os.Getenv("COOKIE_REFRESH_MAX_AGE"); value != "" {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil || maxAge < Cookie.AccessMaxAge {
+			return fmt.Errorf("COOKIE_REFRESH_MAX_AGE must be a duration longer than the access token")
+		}
+		Cookie.RefreshMaxAge = maxAge
+	}
+	return nil
+}
+
+// AccessMaxAgeMinutes is the access token lifetime in the unit used by the TokenExpired middlewares
+func (s CookieConfig) AccessMaxAgeMinutes() int {
+	return int(s.AccessMaxAge / time.Minute)
+}
+
+// SetCookie set a httpOnly cookie on "/" with the configured attributes, maxAge 0 is a session cookie and a negative
+// maxAge delete the cookie
+func SetCookie(c *gin.Context, name string, value string, maxAge int) {
+	SetCookieWithPath(c, name, value, "/", maxAge, Cookie.SameSite)
+}
+
+// SetCookieWithPath is SetCookie for cookie which need a narrower path or a different SameSite mode
+func SetCookieWithPath(c *gin.Context, name string, value string, path string, maxAge int, sameSite http.SameSite) {
+	c.SetSameSite(sameSite)
+	c.SetCookie(name, value, maxAge, path, Cookie.Domain, Cookie.Secure, true)
+}
+
+// ClearCookie delete the cookie set by SetCookie
+func ClearCookie(c *gin.Context, name string) {
+	SetCookie(c, name, "", -1)
+}
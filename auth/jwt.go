@@ -27,9 +27,6 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var JwtKeyCustomer []byte
-var JwtKeyStaff []byte
-
 type JWTClaimAccessTokenCustomer struct {
 	Uid string // user id
 	jwt.RegisteredClaims
@@ -42,11 +39,12 @@ type JWTClaimEmailVerification struct {
 }
 
 type JWTClaimAccessTokenStaff struct {
-	Id       uint
-	Username string
-	FinUser  bool
-	InvUser  bool
-	SysAdmin bool
+	Id          uint
+	Username    string
+	Permissions []string // union of the permissions of every role the staff has
+	// ApiKey is the id of the api key which authenticated the request, the claims are never signed in this case and
+	// Id is the staff who issued the key
+	ApiKey uint `json:"-"`
 	jwt.RegisteredClaims
 }
 
@@ -58,12 +56,7 @@ func GenerateJWTAccessTokenCustomer(uid string, id string) (string, error) {
 			ID:       id,
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(JwtKeyCustomer)
-	if err != nil {
-		return "", err
-	}
-	return tokenString, nil
+	return CustomerKeys.Sign(claims)
 }
 
 func GenerateJWTEmailVerification(email string, status bool) (string, error) {
@@ -74,43 +67,27 @@ func GenerateJWTEmailVerification(email string, status bool) (string, error) {
 			IssuedAt: jwt.NewNumericDate(time.Now()),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(JwtKeyCustomer)
-	if err != nil {
-		return "", err
-	}
-	return tokenString, nil
+	return EmailKeys.Sign(claims)
 }
 
-func GenerateJWTAccessTokenStaff(
-	id uint, username string, finUser bool, invUser bool, sysAdmin bool, jti string,
-) (string, error) {
+func GenerateJWTAccessTokenStaff(id uint, username string, permissions []string, jti string) (string, error) {
 	claims := &JWTClaimAccessTokenStaff{
-		Id:       id,
-		Username: username,
-		FinUser:  finUser,
-		InvUser:  invUser,
-		SysAdmin: sysAdmin,
+		Id:          id,
+		Username:    username,
+		Permissions: permissions,
 		RegisteredClaims: jwt.RegisteredClaims{
 			IssuedAt: jwt.NewNumericDate(time.Now()),
 			ID:       jti,
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(JwtKeyStaff)
-	if err != nil {
-		return "", err
-	}
-	return tokenString, nil
+	return StaffKeys.Sign(claims)
 }
 
 func ExtractClaimAccessTokenCustomer(signedToken string) (*JWTClaimAccessTokenCustomer, error) {
 	token, err := jwt.ParseWithClaims(
 		signedToken,
 		&JWTClaimAccessTokenCustomer{},
-		func(token *jwt.Token) (interface{}, error) {
-			return JwtKeyCustomer, nil
-		},
+		CustomerKeys.Keyfunc,
 	)
 	if err != nil {
 		return nil, err
@@ -126,9 +103,7 @@ func ExtractClaimEmailVerification(signedToken string) (*JWTClaimEmailVerificati
 	token, err := jwt.ParseWithClaims(
 		signedToken,
 		&JWTClaimEmailVerification{},
-		func(token *jwt.Token) (interface{}, error) {
-			return JwtKeyCustomer, nil
-		},
+		EmailKeys.Keyfunc,
 	)
 	if err != nil {
 		return nil, err
@@ -144,9 +119,7 @@ func ExtractClaimAccessTokenStaff(signedToken string) (*JWTClaimAccessTokenStaff
 	token, err := jwt.ParseWithClaims(
 		signedToken,
 		&JWTClaimAccessTokenStaff{},
-		func(token *jwt.Token) (interface{}, error) {
-			return JwtKeyStaff, nil
-		},
+		StaffKeys.Keyfunc,
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,366 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// every key purpose has its own key set so that a token of one purpose can never be accepted as another
+const (
+	PurposeCustomer = "customer"
+	PurposeStaff    = "staff"
+	PurposeEmail    = "email"
+)
+
+// Key is a single key of a KeySet, Private is nil for verification only keys
+type Key struct {
+	Id      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// KeySet holds the key used to sign new tokens and every key which is still accepted for verification
+type KeySet struct {
+	mu           sync.RWMutex
+	signing      *Key
+	verification map[string]*Key
+}
+
+var CustomerKeys = &KeySet{}
+var StaffKeys = &KeySet{}
+var EmailKeys = &KeySet{}
+
+// KeysDir contains one directory per purpose with PKCS8 PEM private keys named <kid>.pem and an "active" file
+// containing the kid used for signing, when it is empty the HS256 secrets from env are used instead
+var KeysDir string
+
+func keySets() map[string]*KeySet {
+	return map[string]*KeySet{
+		PurposeCustomer: CustomerKeys,
+		PurposeStaff:    StaffKeys,
+		PurposeEmail:    EmailKeys,
+	}
+}
+
+// LoadKeys read the keys of every purpose from KeysDir, or from the HS256 secrets when there is no key directory
+func LoadKeys() error {
+	KeysDir = os.Getenv("JWT_KEYS_DIR")
+	return reloadKeys()
+}
+
+// WatchKeys reload the keys periodically so that a rotation is picked up without restarting every instance
+func WatchKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		if err := reloadKeys(); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+func reloadKeys() error {
+	for purpose, set := range keySets() {
+		var signing *Key
+		var verification map[string]*Key
+		if KeysDir != "" {
+			var err error
+			signing, verification, err = loadKeyDir(filepath.Join(KeysDir, purpose))
+			if err != nil {
+				return err
+			}
+		}
+		if signing == nil {
+			var err error
+			signing, verification, err = loadSecret(purpose)
+			if err != nil {
+				return err
+			}
+		}
+		set.mu.Lock()
+		set.signing = signing
+		set.verification = verification
+		set.mu.Unlock()
+	}
+	return nil
+}
+
+// loadSecret build a HS256 key set from JWT_KEY_<PURPOSE>, JWT_KEY_<PURPOSE>_PREVIOUS is accepted for verification
+// only so that the secret can be rotated without logging everyone out
+func loadSecret(purpose string) (*Key, map[string]*Key, error) {
+	env := "JWT_KEY_" + strings.ToUpper(purpose)
+	secret := os.Getenv(env)
+	for _, other := range []string{PurposeCustomer, PurposeStaff, PurposeEmail} {
+		if other != purpose && secret != "" && secret == os.Getenv("JWT_KEY_"+strings.ToUpper(other)) {
+			return nil, nil, fmt.Errorf("%s must differ from JWT_KEY_%s", env, strings.ToUpper(other))
+		}
+	}
+	previous := os.Getenv(env + "_PREVIOUS")
+	if secret == "" && purpose == PurposeEmail {
+		// email verification used to share the customer secret, a secret of its own is derived from it instead
+		secret = deriveSecret(os.Getenv("JWT_KEY_CUSTOMER"), purpose)
+		previous = deriveSecret(os.Getenv("JWT_KEY_CUSTOMER_PREVIOUS"), purpose)
+	}
+	if secret == "" {
+		return nil, nil, fmt.Errorf("%s is empty and there is no key in JWT_KEYS_DIR", env)
+	}
+	signing := newSecretKey(purpose, secret)
+	verification := map[string]*Key{signing.Id: signing}
+	if previous != "" {
+		key := newSecretKey(purpose, previous)
+		key.Private = nil
+		verification[key.Id] = key
+	}
+	return signing, verification, nil
+}
+
+// deriveSecret return HMAC-SHA256(secret, purpose) so the derived secret can't verify the tokens of the original one
+func deriveSecret(secret string, purpose string) string {
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newSecretKey name the key by its purpose too, so the kid of a purpose never matches a key of another purpose
+func newSecretKey(purpose string, secret string) *Key {
+	sum := sha256.Sum256([]byte(secret))
+	return &Key{
+		Id:      "hs-" + purpose + "-" + hex.EncodeToString(sum[:4]),
+		Method:  jwt.SigningMethodHS256,
+		Private: []byte(secret),
+		Public:  []byte(secret),
+	}
+}
+
+func loadKeyDir(dir string) (*Key, map[string]*Key, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil, nil
+	}
+	sort.Strings(files)
+	verification := map[string]*Key{}
+	var newest string
+	for _, file := range files {
+		key, err := readPrivateKey(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		verification[key.Id] = key
+		newest = key.Id
+	}
+	active := newest
+	if content, err := os.ReadFile(filepath.Join(dir, "active")); err == nil {
+		active = strings.TrimSpace(string(content))
+	}
+	signing, ok := verification[active]
+	if !ok {
+		return nil, nil, fmt.Errorf("active key %s is not found in %s", active, dir)
+	}
+	return signing, verification, nil
+}
+
+func readPrivateKey(file string) (*Key, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a pem file", file)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{Id: strings.TrimSuffix(filepath.Base(file), ".pem"), Private: parsed}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.Public = &private.PublicKey
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.Public = private.Public()
+	default:
+		return nil, fmt.Errorf("%s has an unsupported key type", file)
+	}
+	return key, nil
+}
+
+// RotateKey generate a new key for the purpose, make it the active signing key and remove the oldest keys so that
+// only the newest keep keys are still accepted for verification
+func RotateKey(purpose string, alg string, keep int) (string, error) {
+	if KeysDir == "" {
+		return "", fmt.Errorf("JWT_KEYS_DIR is empty")
+	}
+	if _, ok := keySets()[purpose]; !ok {
+		return "", fmt.Errorf("unknown key purpose %s", purpose)
+	}
+	if keep < 1 {
+		keep = 1
+	}
+	var private interface{}
+	var err error
+	switch alg {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(KeysDir, purpose)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	// the timestamp prefix keeps the key files sorted from the oldest to the newest
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	kid := time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+	content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), content, 0600); err != nil {
+		return "", err
+	}
+	// write the active file atomically as running instances might be reloading at the same time
+	tmp := filepath.Join(dir, "active.tmp")
+	if err := os.WriteFile(tmp, []byte(kid+"\n"), 0600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "active")); err != nil {
+		return "", err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	for i := 0; i < len(files)-keep; i++ {
+		if err := os.Remove(files[i]); err != nil {
+			return "", err
+		}
+	}
+	return kid, nil
+}
+
+// Sign sign the claims with the active key and put its id in the kid header
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	key := k.signing
+	k.mu.RUnlock()
+	if key == nil {
+		return "", fmt.Errorf("no signing key loaded")
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Id
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc return the verification key of the token based on its kid header
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.verification[kid]
+	if !ok && kid == "" && k.signing != nil && k.signing.Method == jwt.SigningMethodHS256 {
+		// tokens which are issued before the kid header was introduced
+		key, ok = k.signing, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// PublicKeys return every asymmetric verification key, HS256 secrets are never returned
+func (k *KeySet) PublicKeys() []*Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	var keys []*Key
+	for _, key := range k.verification {
+		if key.Method != jwt.SigningMethodHS256 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Id < keys[j].Id })
+	return keys
+}
+
+// Jwk return the public key as a JSON Web Key
+func (k *Key) Jwk() map[string]string {
+	switch public := k.Public.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"kid": k.Id,
+			"use": "sig",
+			"alg": k.Method.Alg(),
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": k.Id,
+			"use": "sig",
+			"alg": k.Method.Alg(),
+			"x":   base64.RawURLEncoding.EncodeToString(public),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+// staff permissions, a role is a named set of these permissions and a staff can have multiple roles
+const (
+	PermissionProductRead     = "product.read"
+	PermissionProductWrite    = "product.write"
+	PermissionOrderRead       = "order.read"
+	PermissionOrderShip       = "order.ship"
+	PermissionRefundIssue     = "refund.issue"
+	PermissionBannerManage    = "banner.manage"
+	PermissionBlacklistManage = "blacklist.manage"
+	PermissionStaffManage     = "staff.manage"
+	PermissionAuditRead       = "audit.read"
+)
+
+// Permissions is every permission which can be granted to a role
+var Permissions = []string{
+	PermissionProductRead,
+	PermissionProductWrite,
+	PermissionOrderRead,
+	PermissionOrderShip,
+	PermissionRefundIssue,
+	PermissionBannerManage,
+	PermissionBlacklistManage,
+	PermissionStaffManage,
+	PermissionAuditRead,
+}
+
+// IsValidPermission check if the permission is one of Permissions
+func IsValidPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission check if the staff claims contain the permission
+func (s *JWTClaimAccessTokenStaff) HasPermission(permission string) bool {
+	for _, p := range s.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
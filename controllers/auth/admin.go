@@ -22,13 +22,24 @@ package auth
 
 import (
 	"database/sql"
+	"errors"
 	"os"
+	"strconv"
 
 	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
 	"github.com/Tus1688/openmerce-backend/models"
 	"github.com/gin-gonic/gin"
 )
 
+var errRoleNotFound = errors.New("role not found")
+
+// staffAuditQuery is the state of a staff which is recorded on the audit trail
+const staffAuditQuery = `
+	SELECT s.username, s.name, s.deleted_at, COALESCE(GROUP_CONCAT(r.name ORDER BY r.name), '') AS roles
+	FROM staffs s LEFT JOIN staff_roles sr ON sr.staff_refer = s.id LEFT JOIN roles r ON r.id = sr.role_refer
+	WHERE s.id = ? GROUP BY s.id`
+
 func AddNewStaff(c *gin.Context) {
 	var request models.NewStaff
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -53,15 +64,42 @@ func AddNewStaff(c *gin.Context) {
 		c.Status(500)
 		return
 	}
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
 	// insert the new staff
-	_, err = database.MysqlInstance.Exec(
-		"insert into staffs (username, hashed_password, name, fin_user, inv_user, sys_admin) values (?, ?, ?, ?, ?, ?)",
-		request.Username, request.Password, request.Name, request.FinUser, request.InvUser, request.SysAdmin,
+	res, err := tx.Exec(
+		"insert into staffs (username, hashed_password, name) values (?, ?, ?)",
+		request.Username, request.Password, request.Name,
 	)
 	if err != nil {
 		c.Status(500)
 		return
 	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if err := setStaffRoles(tx, uint(id), request.Roles); err != nil {
+		if err == errRoleNotFound {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionCreate, logging.EntityStaff, strconv.FormatInt(id, 10), nil,
+		logging.Snapshot(staffAuditQuery, id),
+	)
 	c.Status(201)
 }
 
@@ -69,7 +107,7 @@ func GetStaff(c *gin.Context) {
 	var request models.APICommonQueryID
 	if err := c.ShouldBindQuery(&request); err != nil {
 		//	send all staffs if there is no id in the request parameters
-		rows, err := database.MysqlInstance.Query("select id, username, name, fin_user, inv_user, sys_admin from staffs")
+		rows, err := database.MysqlInstance.Query("select id, username, name from staffs")
 		if err != nil {
 			c.Status(500)
 			return
@@ -78,21 +116,27 @@ func GetStaff(c *gin.Context) {
 		var staffs []models.ListStaff
 		for rows.Next() {
 			var staff models.ListStaff
-			if err := rows.Scan(
-				&staff.ID, &staff.Username, &staff.Name, &staff.FinUser, &staff.InvUser, &staff.SysAdmin,
-			); err != nil {
+			if err := rows.Scan(&staff.ID, &staff.Username, &staff.Name); err != nil {
 				c.Status(500)
 				return
 			}
 			staffs = append(staffs, staff)
 		}
+		roles, err := getStaffRoles(0)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+		for i := range staffs {
+			staffs[i].Roles = roles[staffs[i].ID]
+		}
 		c.JSON(200, staffs)
 		return
 	}
 	var staff models.ListStaff
 	err := database.MysqlInstance.
-		QueryRow("select id, username, name, fin_user, inv_user, sys_admin from staffs where id = ?", request.ID).
-		Scan(&staff.ID, &staff.Username, &staff.Name, &staff.FinUser, &staff.InvUser, &staff.SysAdmin)
+		QueryRow("select id, username, name from staffs where id = ?", request.ID).
+		Scan(&staff.ID, &staff.Username, &staff.Name)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.Status(404)
@@ -101,6 +145,12 @@ func GetStaff(c *gin.Context) {
 		c.Status(500)
 		return
 	}
+	roles, err := getStaffRoles(staff.ID)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	staff.Roles = roles[staff.ID]
 	c.JSON(200, staff)
 }
 
@@ -147,10 +197,17 @@ func UpdateStaff(c *gin.Context) {
 		query += ", hashed_password = ?"
 		args = append(args, request.Password)
 	}
-	query += ", fin_user = ?, inv_user = ?, sys_admin = ? WHERE id = ?"
-	args = append(args, request.FinUser, request.InvUser, request.SysAdmin, request.ID)
+	query += " WHERE id = ?"
+	args = append(args, request.ID)
 
-	res, err := database.MysqlInstance.Exec(query, args...)
+	before := logging.Snapshot(staffAuditQuery, request.ID)
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(query, args...)
 	if err != nil {
 		c.Status(500)
 		return
@@ -164,6 +221,28 @@ func UpdateStaff(c *gin.Context) {
 		c.Status(404)
 		return
 	}
+	if request.Roles != nil {
+		if err := setStaffRoles(tx, request.ID, *request.Roles); err != nil {
+			if err == errRoleNotFound {
+				c.JSON(409, gin.H{"error": err.Error()})
+				return
+			}
+			c.Status(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	after := logging.Snapshot(staffAuditQuery, request.ID)
+	if after != nil && request.Password != "" {
+		// only the fact that the password changed is recorded
+		after["password"] = ""
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityStaff, strconv.FormatUint(uint64(request.ID), 10), before, after,
+	)
 	c.Status(200)
 }
 
@@ -188,6 +267,7 @@ func DeleteStaff(c *gin.Context) {
 		c.Status(403)
 		return
 	}
+	before := logging.Snapshot(staffAuditQuery, request.ID)
 	//	update the deleted_at column to the current timestamp
 	res, err := database.MysqlInstance.Exec(
 		"UPDATE staffs SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at is null", request.ID,
@@ -205,5 +285,57 @@ func DeleteStaff(c *gin.Context) {
 		c.Status(404)
 		return
 	}
+	logging.Audit(c, logging.ActionDelete, logging.EntityStaff, strconv.Itoa(request.ID), before, nil)
 	c.Status(200)
 }
+
+// setStaffRoles replace every role of the staff with roles
+func setStaffRoles(tx *sql.Tx, staffId uint, roles []uint) error {
+	if _, err := tx.Exec("DELETE FROM staff_roles WHERE staff_refer = ?", staffId); err != nil {
+		return err
+	}
+	seen := map[uint]bool{}
+	for _, role := range roles {
+		if seen[role] {
+			continue
+		}
+		seen[role] = true
+		var exist int8
+		err := tx.QueryRow("SELECT 1 FROM roles WHERE id = ?", role).Scan(&exist)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return errRoleNotFound
+			}
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO staff_roles (staff_refer, role_refer) VALUES (?, ?)", staffId, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getStaffRoles return the roles grouped by staff id, every staff is returned when staffId is 0
+func getStaffRoles(staffId uint) (map[uint][]models.RoleCompact, error) {
+	query := "SELECT sr.staff_refer, r.id, r.name FROM staff_roles sr, roles r WHERE sr.role_refer = r.id"
+	var args []interface{}
+	if staffId != 0 {
+		query += " AND sr.staff_refer = ?"
+		args = append(args, staffId)
+	}
+	rows, err := database.MysqlInstance.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := map[uint][]models.RoleCompact{}
+	for rows.Next() {
+		var id uint
+		var role models.RoleCompact
+		if err := rows.Scan(&id, &role.ID, &role.Name); err != nil {
+			return nil, err
+		}
+		roles[id] = append(roles[id], role)
+	}
+	return roles, nil
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/auth"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyAuditQuery is the state of an api key which is recorded on the audit trail, the key itself is never recorded
+const apiKeyAuditQuery = `
+	SELECT k.name, k.lookup, k.rate_limit, k.expires_at, k.revoked_at,
+	       COALESCE(GROUP_CONCAT(kp.permission ORDER BY kp.permission), '') AS permissions
+	FROM api_keys k LEFT JOIN api_key_permissions kp ON kp.api_key_refer = k.id WHERE k.id = ? GROUP BY k.id`
+
+func GetApiKeys(c *gin.Context) {
+	rows, err := database.MysqlInstance.Query(
+		`SELECT k.id, k.name, k.lookup, k.rate_limit, s.username, k.expires_at, k.last_used_at,
+		       COALESCE(k.last_used_ip, ''), k.created_at, k.revoked_at,
+		       COALESCE(GROUP_CONCAT(kp.permission ORDER BY kp.permission), '')
+		FROM api_keys k
+		         INNER JOIN staffs s ON k.created_by = s.id
+		         LEFT JOIN api_key_permissions kp ON kp.api_key_refer = k.id
+		GROUP BY k.id ORDER BY k.id DESC`,
+	)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer rows.Close()
+	var response []models.ApiKey
+	for rows.Next() {
+		var key models.ApiKey
+		var lastUsedAt, revokedAt sql.NullTime
+		var permissions string
+		if err := rows.Scan(
+			&key.ID, &key.Name, &key.Lookup, &key.RateLimit, &key.CreatedBy, &key.ExpiresAt, &lastUsedAt,
+			&key.LastUsedIp, &key.CreatedAt, &revokedAt, &permissions,
+		); err != nil {
+			c.Status(500)
+			return
+		}
+		if lastUsedAt.Valid {
+			key.LastUsedAt = &lastUsedAt.Time
+		}
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+		key.Permissions = []string{}
+		if permissions != "" {
+			key.Permissions = strings.Split(permissions, ",")
+		}
+		response = append(response, key)
+	}
+	c.JSON(200, response)
+}
+
+// AddNewApiKey issue a new api key, the key is only returned once. The key can't be granted a permission the issuing
+// staff doesn't have
+func AddNewApiKey(c *gin.Context) {
+	var request models.ApiKeyCreate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	if !request.ExpiresAt.After(time.Now()) {
+		c.JSON(400, gin.H{"error": "Expiry date must be in the future"})
+		return
+	}
+	claims := auth.StaffClaims(c)
+	for _, permission := range request.Permissions {
+		if !auth.IsValidPermission(permission) {
+			c.JSON(400, gin.H{"error": "Unknown permission " + permission})
+			return
+		}
+		if !claims.HasPermission(permission) {
+			c.JSON(403, gin.H{"error": "You don't have the permission " + permission})
+			return
+		}
+	}
+	key, lookup, hash, err := auth.GenerateApiKey()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(
+		"INSERT INTO api_keys (name, lookup, hashed_key, rate_limit, created_by, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		request.Name, lookup, hash, request.RateLimit, claims.Id, request.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	for _, permission := range request.Permissions {
+		_, err := tx.Exec(
+			"INSERT IGNORE INTO api_key_permissions (api_key_refer, permission) VALUES (?, ?)", id, permission,
+		)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionCreate, logging.EntityApiKey, strconv.FormatInt(id, 10), nil,
+		logging.Snapshot(apiKeyAuditQuery, id),
+	)
+	c.JSON(201, gin.H{"id": id, "key": key})
+}
+
+// RevokeApiKey revoke the api key immediately, the key is kept for the audit trail
+func RevokeApiKey(c *gin.Context) {
+	var request models.APICommonQueryID
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	before := logging.Snapshot(apiKeyAuditQuery, request.ID)
+	res, err := database.MysqlInstance.Exec(
+		"UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", request.ID,
+	)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if affected == 0 {
+		c.Status(404)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionDelete, logging.EntityApiKey, strconv.FormatUint(uint64(request.ID), 10), before,
+		logging.Snapshot(apiKeyAuditQuery, request.ID),
+	)
+	c.Status(200)
+}
@@ -22,14 +22,13 @@ package auth
 
 import (
 	"context"
-	"net/http"
-	"strconv"
-	"time"
+	"encoding/json"
 
 	"github.com/Tus1688/openmerce-backend/auth"
 	"github.com/Tus1688/openmerce-backend/database"
 	"github.com/Tus1688/openmerce-backend/models"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 )
 
 func LoginCustomer(c *gin.Context) {
@@ -40,7 +39,7 @@ func LoginCustomer(c *gin.Context) {
 	}
 	var customer models.CustomerAuth
 	err := database.MysqlInstance.
-		QueryRow("select id, hashed_password, first_name, last_name from customers where email = ?", request.Email).
+		QueryRow("select id, COALESCE(hashed_password, ''), first_name, last_name from customers where email = ? and deleted_at is null", request.Email).
 		Scan(&customer.ID, &customer.HashedPassword, &customer.FirstName, &customer.LastName)
 	if err != nil {
 		c.Status(401)
@@ -50,36 +49,25 @@ func LoginCustomer(c *gin.Context) {
 		c.Status(401)
 		return
 	}
-	userAgent := c.GetHeader("User-Agent")
-	jti := auth.GenerateRandomString(16)
-	refreshToken := auth.GenerateRandomString(32)
-	// make a json string that contains "user-agent": userAgent, "id": customer.ID, "jti": jti, "remember_me": request.RememberMe
-	var jsonString string
-	if request.RememberMe {
-		jsonString = `{"user-agent":"` + userAgent + `","id":"` + customer.ID.String() + `","jti":"` + jti + `","remember_me":` + "true" + `}`
-	} else {
-		jsonString = `{"user-agent":"` + userAgent + `","id":"` + customer.ID.String() + `","jti":"` + jti + `","remember_me":` + "false " + `}`
-	}
-	// insert into redis
-	err = database.RedisInstance[1].Set(context.Background(), refreshToken, jsonString, 14*24*time.Hour).Err()
-	if err != nil {
-		c.Status(500)
+	if request.Bearer {
+		token, refreshToken, err := createCustomerSession(c, customer.ID.String(), true)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+		c.JSON(
+			200, gin.H{
+				"first_name": customer.FirstName,
+				"last_name":  customer.LastName,
+				"token":      bearerTokenResponse(token, refreshToken),
+			},
+		)
 		return
 	}
-	token, err := auth.GenerateJWTAccessTokenCustomer(customer.ID.String(), jti)
-	if err != nil {
+	if err := setCustomerSession(c, customer.ID.String(), request.RememberMe); err != nil {
 		c.Status(500)
 		return
 	}
-	c.SetSameSite(http.SameSiteStrictMode)
-	// 3 minutes expiration access token and 14 days expiration refresh token
-	if request.RememberMe {
-		c.SetCookie("ac_cus", token, 60*3, "/", "", false, true)
-		c.SetCookie("ref_cus", refreshToken, 60*60*24*14, "/", "", false, true)
-	} else {
-		c.SetCookie("ac_cus", token, 0, "/", "", false, true)
-		c.SetCookie("ref_cus", refreshToken, 0, "/", "", false, true)
-	}
 	c.JSON(
 		200, gin.H{
 			"first_name": customer.FirstName,
@@ -88,6 +76,67 @@ func LoginCustomer(c *gin.Context) {
 	)
 }
 
+// setCustomerSession create a new session and set the ac_cus and ref_cus cookies
+func setCustomerSession(c *gin.Context, customerId string, rememberMe bool) error {
+	token, refreshToken, err := createCustomerSession(c, customerId, rememberMe)
+	if err != nil {
+		return err
+	}
+	// the access and refresh token lifetime are configured by auth.Cookie
+	if rememberMe {
+		auth.SetCookie(c, "ac_cus", token, int(auth.Cookie.AccessMaxAge.Seconds()))
+		auth.SetCookie(c, "ref_cus", refreshToken, int(auth.Cookie.RefreshMaxAge.Seconds()))
+	} else {
+		auth.SetCookie(c, "ac_cus", token, 0)
+		auth.SetCookie(c, "ref_cus", refreshToken, 0)
+	}
+	return nil
+}
+
+// createCustomerSession store a new refresh token on redisInstance[1] and return it with a new access token
+func createCustomerSession(c *gin.Context, customerId string, rememberMe bool) (string, string, error) {
+	jti := auth.GenerateRandomString(16)
+	refreshToken := auth.GenerateRandomString(32)
+	jsonString, err := json.Marshal(
+		redisValueCustomer{
+			UserAgent: c.GetHeader("User-Agent"),
+			Id:        customerId,
+			Jti:       jti,
+			Remember:  rememberMe,
+		},
+	)
+	if err != nil {
+		return "", "", err
+	}
+	// insert into redis, the set of sessions outlive every refresh token in it as its ttl is reset on each login
+	sessionsKey := customerSessionsKey(customerId)
+	_, err = database.RedisInstance[1].TxPipelined(
+		context.Background(), func(pipe redis.Pipeliner) error {
+			pipe.Set(context.Background(), refreshToken, jsonString, auth.Cookie.RefreshMaxAge)
+			pipe.SAdd(context.Background(), sessionsKey, refreshToken)
+			pipe.Expire(context.Background(), sessionsKey, auth.Cookie.RefreshMaxAge)
+			return nil
+		},
+	)
+	if err != nil {
+		return "", "", err
+	}
+	token, err := auth.GenerateJWTAccessTokenCustomer(customerId, jti)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
+
+func bearerTokenResponse(token string, refreshToken string) models.BearerTokenResponse {
+	return models.BearerTokenResponse{
+		AccessToken:  token,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(auth.Cookie.AccessMaxAge.Seconds()),
+	}
+}
+
 func LoginStaff(c *gin.Context) {
 	var request models.ReqLoginStaff
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -97,10 +146,10 @@ func LoginStaff(c *gin.Context) {
 	var staff models.StaffAuth
 	err := database.MysqlInstance.
 		QueryRow(
-			"SELECT id, username, hashed_password, fin_user, inv_user, sys_admin FROM staffs WHERE username = ? and deleted_at is null",
+			"SELECT id, username, hashed_password FROM staffs WHERE username = ? and deleted_at is null",
 			request.Username,
 		).
-		Scan(&staff.ID, &staff.Username, &staff.HashedPassword, &staff.FinUser, &staff.InvUser, &staff.SysAdmin)
+		Scan(&staff.ID, &staff.Username, &staff.HashedPassword)
 	if err != nil {
 		c.Status(401)
 		return
@@ -109,62 +158,70 @@ func LoginStaff(c *gin.Context) {
 		c.Status(401)
 		return
 	}
-	userAgent := c.GetHeader("User-Agent")
+	permissions, err := getStaffPermissions(staff.ID)
+	if err != nil {
+		c.Status(500)
+		return
+	}
 	jti := auth.GenerateRandomString(16)
 	refreshToken := auth.GenerateRandomString(32)
-	// make a json string that contains "user-agent": userAgent, "id": staff.ID, "username": staff.Username, "FinUser": staff.FinUser, "InvUser": staff.InvUser, "SysAdmin": staff.SysAdmin, "jti": jti, "remember_me": request.RememberMe
-	var jsonString string
-	if request.RememberMe {
-		jsonString = `{"user-agent":"` + userAgent + `","id":` + strconv.FormatUint(uint64(staff.ID), 10) +
-			`,"username":"` + staff.Username + `","FinUser":` + strconv.FormatBool(staff.FinUser) + `,"InvUser":` +
-			strconv.FormatBool(staff.InvUser) + `,"SysAdmin":` + strconv.FormatBool(staff.SysAdmin) + `,"jti":"` + jti + `","remember_me":` + "true" + `}`
-	} else {
-		jsonString = `{"user-agent":"` + userAgent + `","id":` + strconv.FormatUint(uint64(staff.ID), 10) +
-			`,"username":"` + staff.Username + `","FinUser":` + strconv.FormatBool(staff.FinUser) + `,"InvUser":` +
-			strconv.FormatBool(staff.InvUser) + `,"SysAdmin":` + strconv.FormatBool(staff.SysAdmin) + `,"jti":"` + jti + `","remember_me":` + "false " + `}`
+	// the permissions are not stored as they are reloaded from the roles on every refresh
+	jsonString, err := json.Marshal(
+		redisValueStaff{
+			UserAgent: c.GetHeader("User-Agent"),
+			Id:        staff.ID,
+			Username:  staff.Username,
+			Jti:       jti,
+			Remember:  request.RememberMe,
+		},
+	)
+	if err != nil {
+		c.Status(500)
+		return
 	}
-	err = database.RedisInstance[2].Set(context.Background(), refreshToken, jsonString, 14*24*time.Hour).Err()
+	err = database.RedisInstance[2].Set(context.Background(), refreshToken, jsonString, auth.Cookie.RefreshMaxAge).Err()
 	if err != nil {
 		c.Status(500)
 		return
 	}
-	token, err := auth.GenerateJWTAccessTokenStaff(
-		staff.ID, staff.Username, staff.FinUser, staff.InvUser, staff.SysAdmin, jti,
-	)
+	token, err := auth.GenerateJWTAccessTokenStaff(staff.ID, staff.Username, permissions, jti)
 	if err != nil {
 		c.Status(500)
 		return
 	}
-	c.SetSameSite(http.SameSiteStrictMode)
-	// 3 minutes expiration access token and 14 days expiration refresh token
+	// the access and refresh token lifetime are configured by auth.Cookie
 	if request.RememberMe {
-		c.SetCookie("ac_stf", token, 60*3, "/", "", false, true)
-		c.SetCookie("ref_stf", refreshToken, 60*60*24*14, "/", "", false, true)
+		auth.SetCookie(c, "ac_stf", token, int(auth.Cookie.AccessMaxAge.Seconds()))
+		auth.SetCookie(c, "ref_stf", refreshToken, int(auth.Cookie.RefreshMaxAge.Seconds()))
 	} else {
-		c.SetCookie("ac_stf", token, 0, "/", "", false, true)
-		c.SetCookie("ref_stf", refreshToken, 0, "/", "", false, true)
+		auth.SetCookie(c, "ac_stf", token, 0)
+		auth.SetCookie(c, "ref_stf", refreshToken, 0)
 	}
 	c.JSON(
 		200, gin.H{
-			"username":  staff.Username,
-			"fin_user":  staff.FinUser,
-			"inv_user":  staff.InvUser,
-			"sys_admin": staff.SysAdmin,
+			"username":    staff.Username,
+			"permissions": permissions,
 		},
 	)
 }
 
 func LogoutCustomer(c *gin.Context) {
-	// delete redis
-	refreshToken, err := c.Cookie("ref_cus")
-	if err != nil {
+	// delete redis, a bearer client send its refresh token in the Authorization header
+	refreshToken, ok := auth.RequestToken(c, "ref_cus")
+	if !ok {
 		c.Status(400)
 		return
 	}
 	// we don't handle error here because if the refresh token is not found in redis, it means that the user has already logged out
+	if res, err := database.RedisInstance[1].Get(context.Background(), refreshToken).Result(); err == nil {
+		var redisValue redisValueCustomer
+		if json.Unmarshal([]byte(res), &redisValue) == nil {
+			_ = database.RedisInstance[1].SRem(context.Background(), customerSessionsKey(redisValue.Id), refreshToken).Err()
+		}
+	}
 	_ = database.RedisInstance[1].Del(context.Background(), refreshToken).Err()
-	c.SetCookie("ac_cus", "", -1, "/", "", false, true)
-	c.SetCookie("ref_cus", "", -1, "/", "", false, true)
+	auth.ClearCookie(c, "ac_cus")
+	auth.ClearCookie(c, "ref_cus")
 	c.Status(200)
 }
 
@@ -177,7 +234,7 @@ func LogoutStaff(c *gin.Context) {
 	}
 	// we don't handle error here because if the refresh token is not found in redis, it means that the user has already logged out
 	_ = database.RedisInstance[2].Del(context.Background(), refreshToken).Err()
-	c.SetCookie("ac_stf", "", -1, "/", "", false, true)
-	c.SetCookie("ref_stf", "", -1, "/", "", false, true)
+	auth.ClearCookie(c, "ac_stf")
+	auth.ClearCookie(c, "ref_stf")
 	c.Status(200)
 }
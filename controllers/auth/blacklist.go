@@ -0,0 +1,64 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/database"
+)
+
+var errDomainBlacklisted = errors.New("email domain is blacklisted")
+
+// IsDomainBlacklisted check whether the domain or one of its parent domains (e.g. mail.example.com -> example.com)
+// is listed in blacklist_domains, the result is cached on redisInstance[7] for a day
+func IsDomainBlacklisted(domain string) (bool, error) {
+	domain = strings.ToLower(domain)
+	ctx := context.Background()
+	cached, err := database.RedisInstance[7].Get(ctx, domain).Result()
+	if err == nil {
+		return cached == "1", nil
+	}
+	// build the list of the domain and its parent domains, the top level domain alone is never checked
+	var args []interface{}
+	labels := strings.Split(domain, ".")
+	for i := 0; i < len(labels)-1; i++ {
+		args = append(args, strings.Join(labels[i:], "."))
+	}
+	if len(args) == 0 {
+		return false, nil
+	}
+	query := "SELECT COUNT(1) FROM blacklist_domains WHERE domain_name IN (?" + strings.Repeat(", ?", len(args)-1) + ")"
+	var count int
+	if err := database.MysqlInstance.QueryRow(query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	value := "0"
+	if count > 0 {
+		value = "1"
+	}
+	// we don't care if the cache failed to be set as the next request will query mysql again
+	_ = database.RedisInstance[7].Set(ctx, domain, value, 24*time.Hour).Err()
+	return count > 0, nil
+}
@@ -18,7 +18,24 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-package staff
+package auth
 
-var NginxFSBaseUrl string
-var NginxFSAuthorization string
+import (
+	"github.com/Tus1688/openmerce-backend/service/captcha"
+	"github.com/gin-gonic/gin"
+)
+
+// GetCaptcha tell the frontend which challenge widget to render when a request is rejected with captcha required
+func GetCaptcha(c *gin.Context) {
+	if captcha.DefaultVerifier == nil {
+		c.JSON(200, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(
+		200, gin.H{
+			"enabled":  true,
+			"provider": captcha.DefaultVerifier.Provider(),
+			"site_key": captcha.SiteKey,
+		},
+	)
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/service/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// GetOidcProviders list the name of every configured provider so the frontend can render the login buttons
+func GetOidcProviders(c *gin.Context) {
+	var response []string
+	for name := range oidc.Providers {
+		response = append(response, name)
+	}
+	if len(response) == 0 {
+		c.Status(404)
+		return
+	}
+	c.JSON(200, response)
+}
+
+// OidcLogin start the authorization code flow and return the provider url the customer should be redirected to
+func OidcLogin(c *gin.Context) {
+	provider, ok := oidc.Providers[c.Param("provider")]
+	if !ok {
+		c.Status(404)
+		return
+	}
+	state, authUrl, err := provider.CreateState("", c.Query("remember_me") == "true")
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-oidclogin:"+err.Error())
+		c.Status(500)
+		return
+	}
+	oidc.SetStateCookie(c, state)
+	c.JSON(200, gin.H{"url": authUrl})
+}
+
+// OidcCallback handle the redirect from the provider, it either links the identity to the customer who started the
+// flow from their profile, or logs the customer in (creating the customer, or linking it by verified email when the
+// provider is trusted)
+func OidcCallback(c *gin.Context) {
+	provider, ok := oidc.Providers[c.Param("provider")]
+	if !ok {
+		c.Status(404)
+		return
+	}
+	cookie, err := c.Cookie(oidc.StateCookie)
+	oidc.ClearStateCookie(c)
+	state := c.Query("state")
+	if err != nil || state == "" || cookie != state {
+		oidcRedirect(c, provider.Name, "invalid_state")
+		return
+	}
+	value, err := oidc.ConsumeState(state)
+	if err != nil || value.Provider != provider.Name {
+		oidcRedirect(c, provider.Name, "invalid_state")
+		return
+	}
+	if c.Query("error") != "" || c.Query("code") == "" {
+		// the customer denied the consent on the provider
+		oidcRedirect(c, provider.Name, "denied")
+		return
+	}
+	claims, err := provider.Exchange(c.Query("code"), value.Nonce)
+	if err != nil {
+		go logging.InsertLog(logging.WARN, "1-oidccallback:"+err.Error())
+		oidcRedirect(c, provider.Name, "invalid_token")
+		return
+	}
+
+	if value.CustomerId != "" {
+		_, err := database.MysqlInstance.Exec(
+			"INSERT INTO customer_identities (customer_refer, provider, subject, email) VALUES (UUID_TO_BIN(?), ?, ?, ?)",
+			value.CustomerId, provider.Name, claims.Subject, claims.Email,
+		)
+		if err != nil {
+			if strings.Contains(err.Error(), "Duplicate entry") {
+				// either this identity belongs to another customer, or the customer already linked this provider
+				oidcRedirect(c, provider.Name, "already_linked")
+				return
+			}
+			go logging.InsertLog(logging.ERROR, "2-oidccallback:"+err.Error())
+			oidcRedirect(c, provider.Name, "error")
+			return
+		}
+		oidcRedirect(c, provider.Name, "linked")
+		return
+	}
+
+	var customerId string
+	err = database.MysqlInstance.QueryRow(
+		`SELECT BIN_TO_UUID(ci.customer_refer) FROM customer_identities ci, customers c
+		WHERE ci.customer_refer = c.id AND c.deleted_at IS NULL AND ci.provider = ? AND ci.subject = ?`,
+		provider.Name, claims.Subject,
+	).Scan(&customerId)
+	if err != nil && err != sql.ErrNoRows {
+		go logging.InsertLog(logging.ERROR, "3-oidccallback:"+err.Error())
+		oidcRedirect(c, provider.Name, "error")
+		return
+	}
+	if customerId == "" {
+		// we only trust the email when the provider has verified it, otherwise anyone could take over an account
+		if claims.Email == "" || !claims.EmailVerified {
+			oidcRedirect(c, provider.Name, "email_unverified")
+			return
+		}
+		customerId, err = linkOrCreateCustomer(provider, claims)
+		if err != nil {
+			if err == errDomainBlacklisted {
+				oidcRedirect(c, provider.Name, "email_not_allowed")
+				return
+			}
+			if err == errLinkRequired {
+				oidcRedirect(c, provider.Name, "link_required")
+				return
+			}
+			go logging.InsertLog(logging.ERROR, "4-oidccallback:"+err.Error())
+			oidcRedirect(c, provider.Name, "error")
+			return
+		}
+	}
+	if err := setCustomerSession(c, customerId, value.RememberMe); err != nil {
+		oidcRedirect(c, provider.Name, "error")
+		return
+	}
+	oidcRedirect(c, provider.Name, "login")
+}
+
+// errLinkRequired is returned when the email belongs to a customer but the provider isn't trusted to link it, the
+// customer has to log in and link the provider from their profile
+var errLinkRequired = errors.New("the identity has to be linked by the customer")
+
+// linkOrCreateCustomer link the identity to the customer with the same email when the provider is trusted, or create
+// a new customer without password if there is none
+func linkOrCreateCustomer(provider *oidc.Provider, claims *oidc.IdTokenClaims) (string, error) {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	var customerId string
+	err = tx.QueryRow(
+		"SELECT BIN_TO_UUID(id) FROM customers WHERE email = ? AND deleted_at IS NULL", claims.Email,
+	).Scan(&customerId)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	if customerId != "" && !provider.TrustEmail {
+		return "", errLinkRequired
+	}
+	if customerId == "" {
+		blacklisted, err := IsDomainBlacklisted(claims.Email[strings.LastIndex(claims.Email, "@")+1:])
+		if err != nil {
+			return "", err
+		}
+		if blacklisted {
+			return "", errDomainBlacklisted
+		}
+		firstName, lastName := claims.GivenName, claims.FamilyName
+		if firstName == "" {
+			firstName = claims.Name
+		}
+		if firstName == "" {
+			firstName = claims.Email[:strings.LastIndex(claims.Email, "@")]
+		}
+		customerId = uuid.New().String()
+		_, err = tx.Exec(
+			"INSERT INTO customers (id, email, first_name, last_name) VALUES (UUID_TO_BIN(?), ?, LEFT(?, 50), LEFT(?, 50))",
+			customerId, claims.Email, firstName, lastName,
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+	_, err = tx.Exec(
+		"INSERT INTO customer_identities (customer_refer, provider, subject, email) VALUES (UUID_TO_BIN(?), ?, ?, ?)",
+		customerId, provider.Name, claims.Subject, claims.Email,
+	)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return customerId, nil
+}
+
+// oidcRedirect send the customer back to the frontend with the result of the flow
+func oidcRedirect(c *gin.Context, provider string, result string) {
+	query := url.Values{"provider": {provider}, "result": {result}}
+	c.Redirect(302, oidc.FrontendRedirectUrl+"?"+query.Encode())
+}
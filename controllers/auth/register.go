@@ -25,7 +25,6 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"math/big"
-	"net/http"
 	"net/mail"
 	"strconv"
 	"strings"
@@ -53,10 +52,21 @@ func RegisterEmail(c *gin.Context) {
 		return
 	}
 	// validate the email address
-	if _, err := mail.ParseAddress(request.Email); err != nil {
+	address, err := mail.ParseAddress(request.Email)
+	if err != nil {
 		c.Status(400)
 		return
 	}
+	// reject disposable email providers which are listed in blacklist_domains
+	blacklisted, err := IsDomainBlacklisted(address.Address[strings.LastIndex(address.Address, "@")+1:])
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if blacklisted {
+		c.JSON(400, gin.H{"error": "Email domain is not allowed"})
+		return
+	}
 
 	if email != "" {
 		c.JSON(409, gin.H{"error": "Email already registered"})
@@ -106,8 +116,7 @@ func RegisterEmail(c *gin.Context) {
 		c.Status(500)
 		return
 	}
-	c.SetSameSite(http.SameSiteStrictMode)
-	c.SetCookie("email", tokenString, 300, "/", "", false, true)
+	auth.SetCookie(c, "email", tokenString, 300)
 	c.Status(200)
 }
 
@@ -162,9 +171,8 @@ func RegisterEmailConfirm(c *gin.Context) {
 		c.Status(500)
 		return
 	}
-	c.SetSameSite(http.SameSiteStrictMode)
 	// set the cookie to expire in 10 minutes so that the user can register an account
-	c.SetCookie("email", tokenString, 600, "/", "", false, true)
+	auth.SetCookie(c, "email", tokenString, 600)
 	c.Status(200)
 }
 
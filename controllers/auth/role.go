@@ -0,0 +1,280 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/Tus1688/openmerce-backend/auth"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// roleAuditQuery is the state of a role which is recorded on the audit trail
+const roleAuditQuery = `
+	SELECT r.name, r.description, COALESCE(GROUP_CONCAT(rp.permission ORDER BY rp.permission), '') AS permissions
+	FROM roles r LEFT JOIN role_permissions rp ON rp.role_refer = r.id WHERE r.id = ? GROUP BY r.id`
+
+// GetPermissions list every permission which can be granted to a role
+func GetPermissions(c *gin.Context) {
+	c.JSON(200, auth.Permissions)
+}
+
+func GetRoles(c *gin.Context) {
+	rows, err := database.MysqlInstance.Query(
+		`SELECT r.id, r.name, r.description, COALESCE(GROUP_CONCAT(rp.permission ORDER BY rp.permission), '')
+		FROM roles r LEFT JOIN role_permissions rp ON rp.role_refer = r.id GROUP BY r.id`,
+	)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer rows.Close()
+	var response []models.Role
+	for rows.Next() {
+		var role models.Role
+		var permissions string
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &permissions); err != nil {
+			c.Status(500)
+			return
+		}
+		role.Permissions = []string{}
+		if permissions != "" {
+			role.Permissions = strings.Split(permissions, ",")
+		}
+		response = append(response, role)
+	}
+	c.JSON(200, response)
+}
+
+func AddNewRole(c *gin.Context) {
+	var request models.RoleCreate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	for _, permission := range request.Permissions {
+		if !auth.IsValidPermission(permission) {
+			c.JSON(400, gin.H{"error": "Unknown permission " + permission})
+			return
+		}
+	}
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO roles (name, description) VALUES (?, ?)", request.Name, request.Description)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			c.JSON(409, gin.H{"error": "Role name already exists"})
+			return
+		}
+		c.Status(500)
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if err := setRolePermissions(tx, uint(id), request.Permissions); err != nil {
+		c.Status(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionCreate, logging.EntityRole, strconv.FormatInt(id, 10), nil,
+		logging.Snapshot(roleAuditQuery, id),
+	)
+	c.JSON(201, gin.H{"id": id})
+}
+
+func UpdateRole(c *gin.Context) {
+	var request models.RoleUpdate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	if request.Permissions != nil {
+		for _, permission := range *request.Permissions {
+			if !auth.IsValidPermission(permission) {
+				c.JSON(400, gin.H{"error": "Unknown permission " + permission})
+				return
+			}
+		}
+	}
+	before := logging.Snapshot(roleAuditQuery, request.ID)
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	query := "UPDATE roles SET updated_at = CURRENT_TIMESTAMP"
+	var args []interface{}
+	if request.Name != "" {
+		query += ", name = ?"
+		args = append(args, request.Name)
+	}
+	if request.Description != "" {
+		query += ", description = ?"
+		args = append(args, request.Description)
+	}
+	// the super admin role is managed by InitAdminAccount and can't be changed
+	query += " WHERE id = ? AND name != ?"
+	args = append(args, request.ID, database.SuperAdminRole)
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			c.JSON(409, gin.H{"error": "Role name already exists"})
+			return
+		}
+		c.Status(500)
+		return
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if affected == 0 {
+		c.Status(404)
+		return
+	}
+	if request.Permissions != nil {
+		if err := setRolePermissions(tx, request.ID, *request.Permissions); err != nil {
+			c.Status(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityRole, strconv.FormatUint(uint64(request.ID), 10), before,
+		logging.Snapshot(roleAuditQuery, request.ID),
+	)
+	c.Status(200)
+}
+
+func DeleteRole(c *gin.Context) {
+	var request models.APICommonQueryID
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	var exist int8
+	err := database.MysqlInstance.QueryRow(
+		"SELECT 1 FROM staff_roles sr, staffs s WHERE sr.staff_refer = s.id AND s.deleted_at IS NULL AND sr.role_refer = ? LIMIT 1",
+		request.ID,
+	).Scan(&exist)
+	if err != nil && err != sql.ErrNoRows {
+		c.Status(500)
+		return
+	}
+	if exist == 1 {
+		c.JSON(409, gin.H{"error": "role is in use"})
+		return
+	}
+	before := logging.Snapshot(roleAuditQuery, request.ID)
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	// remove the role from deleted staffs as well
+	if _, err := tx.Exec("DELETE FROM staff_roles WHERE role_refer = ?", request.ID); err != nil {
+		c.Status(500)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role_refer = ?", request.ID); err != nil {
+		c.Status(500)
+		return
+	}
+	res, err := tx.Exec("DELETE FROM roles WHERE id = ? AND name != ?", request.ID, database.SuperAdminRole)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if affected == 0 {
+		c.Status(404)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	logging.Audit(c, logging.ActionDelete, logging.EntityRole, strconv.Itoa(request.ID), before, nil)
+	c.Status(200)
+}
+
+// setRolePermissions replace every permission of the role with permissions
+func setRolePermissions(tx *sql.Tx, roleId uint, permissions []string) error {
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role_refer = ?", roleId); err != nil {
+		return err
+	}
+	for _, permission := range permissions {
+		_, err := tx.Exec(
+			"INSERT IGNORE INTO role_permissions (role_refer, permission) VALUES (?, ?)", roleId, permission,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getStaffPermissions return the union of the permissions of every role of the staff
+func getStaffPermissions(staffId uint) ([]string, error) {
+	rows, err := database.MysqlInstance.Query(
+		"SELECT DISTINCT rp.permission FROM staff_roles sr, role_permissions rp WHERE sr.role_refer = rp.role_refer AND sr.staff_refer = ?",
+		staffId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	permissions := []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, nil
+}
@@ -24,11 +24,11 @@ import (
 	"context"
 	"encoding/json"
 	"log"
-	"net/http"
 
 	"github.com/Tus1688/openmerce-backend/auth"
 	"github.com/Tus1688/openmerce-backend/database"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 )
 
 type redisValueCustomer struct {
@@ -42,20 +42,16 @@ type redisValueStaff struct {
 	UserAgent string `json:"user-agent"`
 	Id        uint   `json:"id"`
 	Username  string `json:"username"`
-	FinUser   bool   `json:"FinUser"`
-	InvUser   bool   `json:"InvUser"`
-	SysAdmin  bool   `json:"SysAdmin"`
 	Jti       string `json:"jti"`
 	Remember  bool   `json:"remember_me"`
 }
 
+// RefreshTokenCustomer rotate the refresh token from the ref_cus cookie, a bearer client send its refresh token in the
+// Authorization header and get the new tokens in the response body
 func RefreshTokenCustomer(c *gin.Context) {
-	refreshToken, err := c.Cookie("ref_cus")
-	if err != nil {
-		c.Status(401)
-		return
-	}
-	if refreshToken == "" {
+	refreshToken, ok := auth.RequestToken(c, "ref_cus")
+	bearer := c.GetHeader("Authorization") != ""
+	if !ok {
 		c.Status(401)
 		return
 	}
@@ -79,6 +75,16 @@ func RefreshTokenCustomer(c *gin.Context) {
 		c.Status(401)
 		return
 	}
+	// a deleted customer can't mint new access tokens with a leftover refresh token
+	var exist int8
+	err = database.MysqlInstance.
+		QueryRow("SELECT 1 FROM customers WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL", redisValue.Id).
+		Scan(&exist)
+	if err != nil {
+		_ = database.RedisInstance[1].Del(context.Background(), refreshToken).Err()
+		c.Status(401)
+		return
+	}
 	// generate new access token and new refresh token
 	newAccessToken, err := auth.GenerateJWTAccessTokenCustomer(redisValue.Id, redisValue.Jti)
 	if err != nil {
@@ -86,8 +92,16 @@ func RefreshTokenCustomer(c *gin.Context) {
 		return
 	}
 	newRefreshToken := auth.GenerateRandomString(32)
-	// rename the old refresh token key to the new refresh token
-	err = database.RedisInstance[1].Rename(context.Background(), refreshToken, newRefreshToken).Err()
+	// rename the old refresh token key to the new refresh token and swap it in the set of sessions of the customer
+	sessionsKey := customerSessionsKey(redisValue.Id)
+	_, err = database.RedisInstance[1].TxPipelined(
+		context.Background(), func(pipe redis.Pipeliner) error {
+			pipe.Rename(context.Background(), refreshToken, newRefreshToken)
+			pipe.SRem(context.Background(), sessionsKey, refreshToken)
+			pipe.SAdd(context.Background(), sessionsKey, newRefreshToken)
+			return nil
+		},
+	)
 	if err != nil {
 		c.Status(500)
 		return
@@ -97,14 +111,17 @@ func RefreshTokenCustomer(c *gin.Context) {
 		c.Status(500)
 		return
 	}
-	c.SetSameSite(http.SameSiteStrictMode)
+	if bearer {
+		c.JSON(200, bearerTokenResponse(newAccessToken, newRefreshToken))
+		return
+	}
 	// set the new access token and new refresh token to the cookie
 	if redisValue.Remember {
-		c.SetCookie("ac_cus", newAccessToken, 60*3, "/", "", false, true)
-		c.SetCookie("ref_cus", newRefreshToken, int(ttl.Seconds()), "/", "", false, true)
+		auth.SetCookie(c, "ac_cus", newAccessToken, int(auth.Cookie.AccessMaxAge.Seconds()))
+		auth.SetCookie(c, "ref_cus", newRefreshToken, int(ttl.Seconds()))
 	} else {
-		c.SetCookie("ac_cus", newAccessToken, 0, "/", "", false, true)
-		c.SetCookie("ref_cus", newRefreshToken, 0, "/", "", false, true)
+		auth.SetCookie(c, "ac_cus", newAccessToken, 0)
+		auth.SetCookie(c, "ref_cus", newRefreshToken, 0)
 	}
 	c.Status(200)
 }
@@ -140,9 +157,24 @@ func RefreshTokenStaff(c *gin.Context) {
 		c.Status(401)
 		return
 	}
+	// reload the permissions so that a role change is applied on the next refresh, deleted staff has no permission
+	var exist int8
+	err = database.MysqlInstance.
+		QueryRow("SELECT 1 FROM staffs WHERE id = ? AND deleted_at IS NULL", redisValue.Id).
+		Scan(&exist)
+	if err != nil {
+		_ = database.RedisInstance[2].Del(context.Background(), refreshToken).Err()
+		c.Status(401)
+		return
+	}
+	permissions, err := getStaffPermissions(redisValue.Id)
+	if err != nil {
+		c.Status(500)
+		return
+	}
 	// generate new access token and new refresh token
 	newAccessToken, err := auth.GenerateJWTAccessTokenStaff(
-		redisValue.Id, redisValue.Username, redisValue.FinUser, redisValue.InvUser, redisValue.SysAdmin, redisValue.Jti,
+		redisValue.Id, redisValue.Username, permissions, redisValue.Jti,
 	)
 	if err != nil {
 		c.Status(500)
@@ -160,14 +192,41 @@ func RefreshTokenStaff(c *gin.Context) {
 		c.Status(500)
 		return
 	}
-	c.SetSameSite(http.SameSiteStrictMode)
 	// set the new access token and new refresh token to the cookie
 	if redisValue.Remember {
-		c.SetCookie("ac_stf", newAccessToken, 60*3, "/", "", false, true)
-		c.SetCookie("ref_stf", newRefreshToken, int(ttl.Seconds()), "/", "", false, true)
+		auth.SetCookie(c, "ac_stf", newAccessToken, int(auth.Cookie.AccessMaxAge.Seconds()))
+		auth.SetCookie(c, "ref_stf", newRefreshToken, int(ttl.Seconds()))
 	} else {
-		c.SetCookie("ac_stf", newAccessToken, 0, "/", "", false, true)
-		c.SetCookie("ref_stf", newRefreshToken, 0, "/", "", false, true)
+		auth.SetCookie(c, "ac_stf", newAccessToken, 0)
+		auth.SetCookie(c, "ref_stf", newRefreshToken, 0)
 	}
 	c.Status(200)
 }
+
+// GetJwks expose the public customer keys so that other services can verify customer access tokens without sharing
+// any secret, it is empty when the customer tokens are still signed with HS256
+func GetJwks(c *gin.Context) {
+	keys := []map[string]string{}
+	for _, key := range auth.CustomerKeys.PublicKeys() {
+		keys = append(keys, key.Jwk())
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(200, gin.H{"keys": keys})
+}
+
+// customerSessionsKey is the set of the refresh tokens of the customer on redisInstance[1]
+func customerSessionsKey(customerId string) string {
+	return "sessions:" + customerId
+}
+
+// RevokeCustomerSessions delete every refresh token of the customer, the access token is still valid until it expires
+func RevokeCustomerSessions(customerId string) error {
+	ctx := context.Background()
+	sessionsKey := customerSessionsKey(customerId)
+	refreshTokens, err := database.RedisInstance[1].SMembers(ctx, sessionsKey).Result()
+	if err != nil {
+		return err
+	}
+	// an expired refresh token is still in the set, deleting it again is harmless
+	return database.RedisInstance[1].Del(ctx, append(refreshTokens, sessionsKey)...).Err()
+}
@@ -36,15 +36,10 @@ func AddAddress(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
-	_, err = database.MysqlInstance.
+	_, err := database.MysqlInstance.
 		Exec(
 			"INSERT INTO customer_addresses (customer_refer, label, full_address, note, recipient_name, phone_number, shipping_area_refer, postal_code) VALUES (UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?, ?)",
 			customerId, request.Label, request.FullAddress, request.Note, request.RecipientName, request.PhoneNumber,
@@ -66,13 +61,8 @@ func AddAddress(c *gin.Context) {
 }
 
 func GetAddress(c *gin.Context) {
-	// token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	var request models.APICommonQueryUUID
 	if err := c.ShouldBindQuery(&request); err == nil {
@@ -137,13 +127,8 @@ func DeleteAddress(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	res, err := database.MysqlInstance.
 		Exec(
@@ -172,13 +157,8 @@ func UpdateAddress(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	query := "UPDATE customer_addresses SET updated_at = CURRENT_TIMESTAMP"
 	var args []interface{}
@@ -23,6 +23,7 @@ package customer
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -35,18 +36,73 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func GetCartCount(c *gin.Context) {
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
+// skuVariantQuery select the option values of the sku aliased as s into a label e.g. "Size: M, Color: Red"
+const skuVariantQuery = `
+	COALESCE((
+	    SELECT GROUP_CONCAT(CONCAT(o.name, ': ', v.value) ORDER BY o.position, o.id SEPARATOR ', ')
+	    FROM product_sku_values sv
+	        JOIN product_option_values v ON v.id = sv.option_value_refer
+	        JOIN product_options o ON o.id = v.option_refer
+	    WHERE sv.sku_refer = s.id
+	), '')`
+
+var errVariantRequired = errors.New("please choose a variant of the product")
+
+// resolveSku return the sku id of the product to be put in the cart, when skuId is empty the product must only have
+// a single sku
+func resolveSku(productId string, skuId string) (string, error) {
+	if skuId != "" {
+		var exist int8
+		err := database.MysqlInstance.
+			QueryRow(
+				`SELECT 1 FROM product_skus s, products p WHERE s.id = UUID_TO_BIN(?) AND s.product_refer = UUID_TO_BIN(?)
+				AND p.id = s.product_refer AND s.deleted_at IS NULL AND p.deleted_at IS NULL AND p.status = 'published'`,
+				skuId, productId,
+			).
+			Scan(&exist)
+		if err != nil {
+			return "", err
+		}
+		return skuId, nil
+	}
+	rows, err := database.MysqlInstance.
+		Query(
+			`SELECT BIN_TO_UUID(s.id) FROM product_skus s, products p WHERE s.product_refer = UUID_TO_BIN(?)
+			AND p.id = s.product_refer AND s.deleted_at IS NULL AND p.deleted_at IS NULL AND p.status = 'published' LIMIT 2`,
+			productId,
+		)
 	if err != nil {
-		c.Status(401)
-		return
+		return "", err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return "", err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
 	}
+	switch len(ids) {
+	case 0:
+		return "", sql.ErrNoRows
+	case 1:
+		return ids[0], nil
+	default:
+		return "", errVariantRequired
+	}
+}
+
+func GetCartCount(c *gin.Context) {
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	//	check from redis[3] if the cart count is cached
 	customerId := claims.Uid
 	var count uint8
-	err = database.RedisInstance[3].Get(context.Background(), customerId).Scan(&count)
+	err := database.RedisInstance[3].Get(context.Background(), customerId).Scan(&count)
 	//	if there is no cache, get the count from database
 	if err != nil {
 		err := database.MysqlInstance.QueryRow(
@@ -71,25 +127,22 @@ func CheckCartItem(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
-	res, err := database.MysqlInstance.
-		Exec(
-			`
-			UPDATE cart_items c
-			LEFT JOIN inventories i on c.product_refer = i.product_refer
-			LEFT JOIN products p on c.product_refer = p.id
-			SET c.checked = ? WHERE c.customer_refer = UUID_TO_BIN(?) AND c.product_refer = UUID_TO_BIN(?) 
-			AND i.quantity >= c.quantity AND p.deleted_at IS NULL
-			`,
-			request.State, customerId, request.ProductID,
-		)
+	query := `
+		UPDATE cart_items c
+		LEFT JOIN inventories i on c.sku_refer = i.sku_refer
+		LEFT JOIN product_skus s on c.sku_refer = s.id
+		LEFT JOIN products p on c.product_refer = p.id
+		SET c.checked = ? WHERE c.customer_refer = UUID_TO_BIN(?) AND c.product_refer = UUID_TO_BIN(?)
+		AND i.quantity >= c.quantity AND p.deleted_at IS NULL AND p.status = 'published' AND s.deleted_at IS NULL`
+	args := []interface{}{request.State, customerId, request.ProductID}
+	if request.SkuId != "" {
+		query += " AND c.sku_refer = UUID_TO_BIN(?)"
+		args = append(args, request.SkuId)
+	}
+	res, err := database.MysqlInstance.Exec(query, args...)
 	if err != nil {
 		c.Status(500)
 		return
@@ -114,21 +167,18 @@ func CheckAllCartItem(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	res, err := database.MysqlInstance.
 		Exec(
 			`
 		UPDATE cart_items 
-		LEFT JOIN inventories i on cart_items.product_refer = i.product_refer
+		LEFT JOIN inventories i on cart_items.sku_refer = i.sku_refer
+		LEFT JOIN product_skus s on cart_items.sku_refer = s.id
 		LEFT JOIN products p on cart_items.product_refer = p.id
 		SET checked = ? WHERE customer_refer = UUID_TO_BIN(?) AND i.quantity >= cart_items.quantity AND p.deleted_at IS NULL
+		AND p.status = 'published' AND s.deleted_at IS NULL
 		`, request.State, customerId,
 		)
 	if err != nil {
@@ -148,13 +198,8 @@ func CheckAllCartItem(c *gin.Context) {
 }
 
 func GetCart(c *gin.Context) {
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 
 	customerId := claims.Uid
 	var response []models.CartItemResponse
@@ -163,25 +208,21 @@ func GetCart(c *gin.Context) {
 			`
 			SELECT
 			    BIN_TO_UUID(p.id) AS id,
+			    BIN_TO_UUID(s.id) AS sku_id,
 			    p.name,
-			    p.price,
+			    `+skuVariantQuery+` AS variant,
+			    COALESCE(s.price, p.price),
 			    COALESCE(CONCAT(BIN_TO_UUID(pi.id), '.webp'), '') AS image,
 			    c.quantity,
 			    i.quantity,
 			    c.checked
 			FROM cart_items c
 			        left join products p on p.id = c.product_refer
-			        left join inventories i on i.product_refer = c.product_refer
-			        LEFT JOIN (
-			        SELECT
-			            id,
-			            product_refer,
-			            ROW_NUMBER() OVER (PARTITION BY product_refer ORDER BY created_at) AS rn
-			        FROM
-			            product_images
-			    ) pi ON p.id = pi.product_refer AND pi.rn = 1
+			        left join product_skus s on s.id = c.sku_refer
+			        left join inventories i on i.sku_refer = c.sku_refer
+			        LEFT JOIN product_images pi ON p.id = pi.product_refer AND pi.is_primary = 1
 			WHERE
-			    p.deleted_at IS NULL
+			    p.deleted_at IS NULL AND p.status = 'published' AND s.deleted_at IS NULL
 			  AND c.customer_refer = UUID_TO_BIN(?);
 			`, customerId,
 		)
@@ -193,8 +234,8 @@ func GetCart(c *gin.Context) {
 	for rows.Next() {
 		var item models.CartItemResponse
 		err := rows.Scan(
-			&item.ProductId, &item.ProductName, &item.ProductPrice, &item.ProductImage, &item.Quantity,
-			&item.CurrentStock, &item.Checked,
+			&item.ProductId, &item.SkuId, &item.ProductName, &item.Variant, &item.ProductPrice, &item.ProductImage,
+			&item.Quantity, &item.CurrentStock, &item.Checked,
 		)
 		if err != nil {
 			c.Status(500)
@@ -216,19 +257,29 @@ func AddToCart(c *gin.Context) {
 		c.Status(400)
 		return
 	}
+	skuId, err := resolveSku(request.ProductId, request.SkuId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(404, gin.H{"error": "product not found"})
+			return
+		}
+		if err == errVariantRequired {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(500)
+		return
+	}
 	wg := sync.WaitGroup{}
 	errChan := make(chan error, 1)
 	stockChan := make(chan uint16, 1)
 	wg.Add(1)
-	// this goroutine check if the product exists and the quantity is enough
-	go func(productId string) {
+	// this goroutine check if the quantity of the sku is enough
+	go func(skuId string) {
 		defer wg.Done()
 		var quantity uint16
 		err := database.MysqlInstance.
-			QueryRow(
-				"SELECT i.quantity FROM inventories i, products p WHERE p.id = UUID_TO_BIN(?) AND i.product_refer = p.id AND p.deleted_at IS NULL",
-				productId,
-			).
+			QueryRow("SELECT quantity FROM inventories WHERE sku_refer = UUID_TO_BIN(?)", skuId).
 			Scan(&quantity)
 		if err != nil {
 			errChan <- err
@@ -239,15 +290,10 @@ func AddToCart(c *gin.Context) {
 			stockChan <- quantity
 			return
 		}
-	}(request.ProductId)
+	}(skuId)
 
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 
 	wg.Wait()
@@ -274,10 +320,10 @@ func AddToCart(c *gin.Context) {
 
 	_, err = database.MysqlInstance.Exec(
 		`
-		INSERT INTO cart_items (product_refer, customer_refer, quantity) VALUES
-		(UUID_TO_BIN(?), UUID_TO_BIN(?), ?)
+		INSERT INTO cart_items (product_refer, sku_refer, customer_refer, quantity) VALUES
+		(UUID_TO_BIN(?), UUID_TO_BIN(?), UUID_TO_BIN(?), ?)
 		ON DUPLICATE KEY UPDATE quantity = ?
-	`, request.ProductId, customerId, request.Quantity, request.Quantity,
+	`, request.ProductId, skuId, customerId, request.Quantity, request.Quantity,
 	)
 	if err != nil {
 		c.Status(500)
@@ -288,25 +334,22 @@ func AddToCart(c *gin.Context) {
 }
 
 func DeleteCart(c *gin.Context) {
-	var request models.APICommonQueryUUID
+	var request models.CartDelete
 	if err := c.ShouldBindQuery(&request); err != nil {
 		c.Status(400)
 		return
 	}
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 
-	res, err := database.MysqlInstance.Exec(
-		`
-		DELETE FROM cart_items WHERE customer_refer = UUID_TO_BIN(?) AND product_refer = UUID_TO_BIN(?)
-	`, customerId, request.ID,
-	)
+	query := "DELETE FROM cart_items WHERE customer_refer = UUID_TO_BIN(?) AND product_refer = UUID_TO_BIN(?)"
+	args := []interface{}{customerId, request.ID}
+	if request.SkuId != "" {
+		query += " AND sku_refer = UUID_TO_BIN(?)"
+		args = append(args, request.SkuId)
+	}
+	res, err := database.MysqlInstance.Exec(query, args...)
 	if err != nil {
 		c.Status(500)
 		return
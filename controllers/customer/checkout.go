@@ -43,13 +43,8 @@ func Checkout(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	freightReq := freight.PrecalculateFreightRequest{}
 	var itemGrossAmount int
@@ -64,7 +59,7 @@ func Checkout(c *gin.Context) {
 	go func() {
 		defer wg.Done()
 		var id uint32
-		err = database.MysqlInstance.
+		err := database.MysqlInstance.
 			QueryRow(
 				"SELECT shipping_area_refer FROM customer_addresses WHERE id = UUID_TO_BIN(?) AND customer_refer = UUID_TO_BIN(?)",
 				request.AddressCode, customerId,
@@ -87,14 +82,17 @@ func Checkout(c *gin.Context) {
 	go func() {
 		defer wg.Done()
 		var weight, volume float64
-		err = database.MysqlInstance.
+		err := database.MysqlInstance.
 			QueryRow(
 				`
-				select sum(p.weight * c.quantity) as weight, sum((p.length * p.height * p.width) * c.quantity) as volume, sum(c.quantity * p.price) as gross_amount
+				select sum(coalesce(s.weight, p.weight) * c.quantity) as weight,
+				       sum((coalesce(s.length, p.length) * coalesce(s.height, p.height) * coalesce(s.width, p.width)) * c.quantity) as volume,
+				       sum(c.quantity * coalesce(s.price, p.price)) as gross_amount
 				from products p, cart_items c
-				left join inventories i on c.product_refer = i.product_refer
+				left join product_skus s on c.sku_refer = s.id
+				left join inventories i on c.sku_refer = i.sku_refer
 				where p.id = c.product_refer and c.checked = 1 and c.customer_refer = uuid_to_bin(?) and 
-					c.quantity <= i.quantity and p.deleted_at is null
+					c.quantity <= i.quantity and p.deleted_at is null and p.status = 'published' and s.deleted_at is null
 				group by c.customer_refer;
 				`, customerId,
 			).Scan(&weight, &volume, &itemGrossAmount)
@@ -118,11 +116,13 @@ func Checkout(c *gin.Context) {
 		rows, err := database.MysqlInstance.
 			Query(
 				`
-				select BIN_TO_UUID(p.id), p.name, p.price, c.quantity, p.description, p.weight
+				select BIN_TO_UUID(s.id), BIN_TO_UUID(p.id), p.name, `+skuVariantQuery+`, coalesce(s.price, p.price),
+				       c.quantity, p.description, coalesce(s.weight, p.weight)
 				from products p, cart_items c
-				left join inventories i on c.product_refer = i.product_refer
+				left join product_skus s on c.sku_refer = s.id
+				left join inventories i on c.sku_refer = i.sku_refer
 				where p.id = c.product_refer and c.customer_refer = UUID_TO_BIN(?)
-				and c.checked = 1 and c.quantity <= i.quantity and p.deleted_at is null`, customerId,
+				and c.checked = 1 and c.quantity <= i.quantity and p.deleted_at is null and p.status = 'published' and s.deleted_at is null`, customerId,
 			)
 		if err != nil {
 			errChan <- err
@@ -131,7 +131,10 @@ func Checkout(c *gin.Context) {
 		defer rows.Close()
 		for rows.Next() {
 			var item models.CheckoutItemInternal
-			err = rows.Scan(&item.Id, &item.Name, &item.Price, &item.Quantity, &item.Description, &item.Weight)
+			err = rows.Scan(
+				&item.Id, &item.ProductId, &item.Name, &item.Variant, &item.Price, &item.Quantity, &item.Description,
+				&item.Weight,
+			)
 			if err != nil {
 				errChan <- err
 				return
@@ -219,7 +222,7 @@ func Checkout(c *gin.Context) {
 		var firstName, lastName, email, phone string
 		err = database.MysqlInstance.
 			QueryRow(
-				"SELECT first_name, last_name, email, coalesce(phone_number, '') FROM customers WHERE id = UUID_TO_BIN(?)",
+				"SELECT first_name, last_name, email, IF(phone_verified_at IS NULL, '', phone_number) FROM customers WHERE id = UUID_TO_BIN(?)",
 				customerId,
 			).
 			Scan(&firstName, &lastName, &email, &phone)
@@ -267,8 +270,8 @@ func Checkout(c *gin.Context) {
 	stmt, err := tx.
 		Prepare(
 			`
-			INSERT INTO order_items(order_refer, product_refer, on_buy_name, on_buy_description, on_buy_price, on_buy_weight, quantity)
-			VALUES (?, UUID_TO_BIN(?), ?, ?, ?, ?, ?)
+			INSERT INTO order_items(order_refer, product_refer, sku_refer, on_buy_name, on_buy_variant, on_buy_description, on_buy_price, on_buy_weight, quantity)
+			VALUES (?, UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?)
 		`,
 		)
 	if err != nil {
@@ -278,7 +281,10 @@ func Checkout(c *gin.Context) {
 	}
 	defer stmt.Close()
 	for _, item := range items {
-		_, err := stmt.Exec(orderId, item.Id, item.Name, item.Description, item.Price, item.Weight, item.Quantity)
+		_, err := stmt.Exec(
+			orderId, item.ProductId, item.Id, item.Name, item.Variant, item.Description, item.Price, item.Weight,
+			item.Quantity,
+		)
 		if err != nil {
 			c.Status(500)
 			go logging.InsertLog(logging.ERROR, "checkout7-"+err.Error())
@@ -306,11 +312,12 @@ func Checkout(c *gin.Context) {
 	}
 	// fill the paymentReq.ItemDetails
 	for _, item := range items {
-		var name string
-		if len(item.Name) > 50 {
-			name = item.Name[:50]
-		} else {
-			name = item.Name
+		name := item.Name
+		if item.Variant != "" {
+			name += " (" + item.Variant + ")"
+		}
+		if len(name) > 50 {
+			name = name[:50]
 		}
 		paymentReq.ItemDetails = append(
 			paymentReq.ItemDetails, models.CheckoutItem{
@@ -386,16 +393,11 @@ func CancelCheckout(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	var state string
-	err = database.MysqlInstance.
+	err := database.MysqlInstance.
 		QueryRow(
 			"SELECT COALESCE(transaction_status, '') FROM orders WHERE id = ? AND customer_refer = UUID_TO_BIN(?) AND is_paid = 0 AND is_cancelled = 0",
 			request.ID, customerId,
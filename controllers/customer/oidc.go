@@ -0,0 +1,162 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package customer
+
+import (
+	"database/sql"
+
+	"github.com/Tus1688/openmerce-backend/auth"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/oidc"
+	"github.com/gin-gonic/gin"
+)
+
+// GetLinkedProviders list the OpenID Connect providers linked to the customer
+func GetLinkedProviders(c *gin.Context) {
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
+	rows, err := database.MysqlInstance.Query(
+		"SELECT provider, COALESCE(email, ''), created_at FROM customer_identities WHERE customer_refer = UUID_TO_BIN(?)",
+		claims.Uid,
+	)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer rows.Close()
+	var response []models.CustomerIdentity
+	for rows.Next() {
+		var identity models.CustomerIdentity
+		if err := rows.Scan(&identity.Provider, &identity.Email, &identity.CreatedAt); err != nil {
+			c.Status(500)
+			return
+		}
+		response = append(response, identity)
+	}
+	if len(response) == 0 {
+		c.Status(404)
+		return
+	}
+	c.JSON(200, response)
+}
+
+// LinkProvider start the authorization code flow on behalf of the logged-in customer, the identity is linked on
+// the callback
+func LinkProvider(c *gin.Context) {
+	var request models.ReqOidcLink
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	provider, ok := oidc.Providers[request.Provider]
+	if !ok {
+		c.Status(404)
+		return
+	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
+	var exist int8
+	err := database.MysqlInstance.QueryRow(
+		"SELECT 1 FROM customer_identities WHERE customer_refer = UUID_TO_BIN(?) AND provider = ?",
+		claims.Uid, provider.Name,
+	).Scan(&exist)
+	if err != nil && err != sql.ErrNoRows {
+		c.Status(500)
+		return
+	}
+	if exist == 1 {
+		c.JSON(409, gin.H{"error": "Provider already linked"})
+		return
+	}
+	state, authUrl, err := provider.CreateState(claims.Uid, false)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-linkprovider:"+err.Error())
+		c.Status(500)
+		return
+	}
+	oidc.SetStateCookie(c, state)
+	c.JSON(200, gin.H{"url": authUrl})
+}
+
+// UnlinkProvider remove the provider from the customer, it is refused when the customer would be left without any
+// way to log in
+func UnlinkProvider(c *gin.Context) {
+	var request models.OidcProviderQuery
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	// the customer row is locked so that concurrent unlinks can't both see another login method left
+	var hasPassword bool
+	err = tx.QueryRow(
+		"SELECT hashed_password IS NOT NULL FROM customers WHERE id = UUID_TO_BIN(?) FOR UPDATE", claims.Uid,
+	).Scan(&hasPassword)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// this shouldn't happen as the customer who has the token should exist
+			c.Status(403)
+			return
+		}
+		c.Status(500)
+		return
+	}
+	var identities, linked int
+	err = tx.QueryRow(
+		`SELECT COUNT(*), COALESCE(SUM(provider = ?), 0) FROM customer_identities
+		WHERE customer_refer = UUID_TO_BIN(?)`,
+		request.Provider, claims.Uid,
+	).Scan(&identities, &linked)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if linked == 0 {
+		c.Status(404)
+		return
+	}
+	if !hasPassword && identities <= 1 {
+		c.JSON(409, gin.H{"error": "Set a password before unlinking your last login provider"})
+		return
+	}
+	_, err = tx.Exec(
+		"DELETE FROM customer_identities WHERE customer_refer = UUID_TO_BIN(?) AND provider = ?",
+		claims.Uid, request.Provider,
+	)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	c.Status(200)
+}
@@ -32,13 +32,8 @@ import (
 )
 
 func GetOrder(c *gin.Context) {
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	var request models.APICommonQueryID
 	if err := c.ShouldBindQuery(&request); err == nil {
@@ -97,12 +92,10 @@ func GetOrder(c *gin.Context) {
 			rows, err := database.MysqlInstance.
 				Query(
 					`
-					select oi.id, BIN_TO_UUID(oi.product_refer), oi.on_buy_name, oi.on_buy_price, coalesce(pi.image, ''), oi.quantity, (if (r.id is null, false, true)) as reviewed
+					select oi.id, BIN_TO_UUID(oi.product_refer), BIN_TO_UUID(oi.sku_refer), oi.on_buy_name, oi.on_buy_variant, oi.on_buy_price, coalesce(pi.image, ''), oi.quantity, (if (r.id is null, false, true)) as reviewed
 					from order_items oi
-					         left join (select pi.product_refer, CONCAT(BIN_TO_UUID(pi.id), '.webp') as image
-					                    from (select product_refer, min(id) as id
-					                          from product_images
-					                          group by product_refer) pi) pi on pi.product_refer = oi.product_refer
+					         left join (select product_refer, CONCAT(BIN_TO_UUID(id), '.webp') as image
+					                    from product_images where is_primary = 1) pi on pi.product_refer = oi.product_refer
 					         inner join orders o on oi.order_refer = o.id
 					left join reviews r on oi.id = r.order_item_refer
 					where oi.order_refer = ?
@@ -117,8 +110,8 @@ func GetOrder(c *gin.Context) {
 			for rows.Next() {
 				var item models.ItemListOrderDetail
 				err := rows.Scan(
-					&item.OrderID, &item.ProductId, &item.ProductName, &item.ProductPrice, &item.ProductImage,
-					&item.Quantity, &item.Reviewed,
+					&item.OrderID, &item.ProductId, &item.SkuId, &item.ProductName, &item.Variant, &item.ProductPrice,
+					&item.ProductImage, &item.Quantity, &item.Reviewed,
 				)
 				if err != nil {
 					errChan <- err
@@ -200,12 +193,9 @@ func GetOrder(c *gin.Context) {
 			    GROUP BY order_refer
 			) oi ON oi.order_refer = o.id
 			         LEFT JOIN (
-			    SELECT pi.product_refer, CONCAT(BIN_TO_UUID(pi.id), '.webp') AS image
-			    FROM (
-			             SELECT product_refer, MIN(id) AS id
-			             FROM product_images
-			             GROUP BY product_refer
-			         ) pi
+			    SELECT product_refer, CONCAT(BIN_TO_UUID(id), '.webp') AS image
+			    FROM product_images
+			    WHERE is_primary = 1
 			) pi ON pi.product_refer = (SELECT product_refer FROM order_items WHERE order_refer = o.id LIMIT 1)
 			         LEFT JOIN (
 			    SELECT id, name
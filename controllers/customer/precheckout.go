@@ -40,13 +40,8 @@ func PreCheckoutFreight(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	req := freight.PrecalculateFreightRequest{}
 	wg := &sync.WaitGroup{}
@@ -58,7 +53,7 @@ func PreCheckoutFreight(c *gin.Context) {
 	go func() {
 		defer wg.Done()
 		var id uint32
-		err = database.MysqlInstance.
+		err := database.MysqlInstance.
 			QueryRow(
 				"SELECT shipping_area_refer FROM customer_addresses WHERE id = UUID_TO_BIN(?) AND customer_refer = UUID_TO_BIN(?)",
 				request.ID, customerId,
@@ -81,14 +76,16 @@ func PreCheckoutFreight(c *gin.Context) {
 	go func() {
 		defer wg.Done()
 		var weight, volume float64
-		err = database.MysqlInstance.
+		err := database.MysqlInstance.
 			QueryRow(
 				`
-				select sum(p.weight * c.quantity) as weight, sum((p.length * p.height * p.width) * c.quantity) as volume
+				select sum(coalesce(s.weight, p.weight) * c.quantity) as weight,
+				       sum((coalesce(s.length, p.length) * coalesce(s.height, p.height) * coalesce(s.width, p.width)) * c.quantity) as volume
 				from products p, cart_items c
-				left join inventories i on i.product_refer = c.product_refer
+				left join product_skus s on s.id = c.sku_refer
+				left join inventories i on i.sku_refer = c.sku_refer
 				where p.id = c.product_refer and c.checked = 1 and c.customer_refer = uuid_to_bin(?) and
-				      c.quantity <= i.quantity and p.deleted_at is null
+				      c.quantity <= i.quantity and p.deleted_at is null and p.status = 'published' and s.deleted_at is null
 				group by c.customer_refer;
 				`, customerId,
 			).Scan(&weight, &volume)
@@ -163,13 +160,8 @@ func PreCheckoutFreight(c *gin.Context) {
 
 // PreCheckoutItems is the handler for getting all ticked items in the cart before the checkout process
 func PreCheckoutItems(c *gin.Context) {
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	var response []models.PreCheckoutItem
 	rows, err := database.MysqlInstance.
@@ -177,25 +169,21 @@ func PreCheckoutItems(c *gin.Context) {
 			`
 			SELECT
 			    BIN_TO_UUID(p.id) AS id,
+			    BIN_TO_UUID(s.id) AS sku_id,
 			    p.name,
-			    p.price,
+			    `+skuVariantQuery+` AS variant,
+			    COALESCE(s.price, p.price),
 			    COALESCE(CONCAT(BIN_TO_UUID(pi.id), '.webp'), '') AS image,
 			    c.quantity
 			FROM cart_items c
 			        left join products p on p.id = c.product_refer
-			        LEFT JOIN (
-			        SELECT
-			            id,
-			            product_refer,
-			            ROW_NUMBER() OVER (PARTITION BY product_refer ORDER BY created_at) AS rn
-			        FROM
-			            product_images
-			    ) pi ON p.id = pi.product_refer AND pi.rn = 1
-				LEFT JOIN inventories i ON i.product_refer = c.product_refer
+			        left join product_skus s on s.id = c.sku_refer
+			        LEFT JOIN product_images pi ON p.id = pi.product_refer AND pi.is_primary = 1
+				LEFT JOIN inventories i ON i.sku_refer = c.sku_refer
 			WHERE
-			    p.deleted_at IS NULL
+			    p.deleted_at IS NULL AND p.status = 'published' AND s.deleted_at IS NULL
 			  AND c.customer_refer = UUID_TO_BIN(?) AND c.checked = 1
-						AND p.deleted_at IS NULL AND c.quantity <= i.quantity
+						AND p.deleted_at IS NULL AND p.status = 'published' AND c.quantity <= i.quantity
 		`, customerId,
 		)
 	if err != nil {
@@ -206,7 +194,8 @@ func PreCheckoutItems(c *gin.Context) {
 	for rows.Next() {
 		var item models.PreCheckoutItem
 		if err := rows.Scan(
-			&item.ProductId, &item.ProductName, &item.ProductPrice, &item.ProductImage, &item.Quantity,
+			&item.ProductId, &item.SkuId, &item.ProductName, &item.Variant, &item.ProductPrice, &item.ProductImage,
+			&item.Quantity,
 		); err != nil {
 			c.Status(500)
 			return
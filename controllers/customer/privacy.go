@@ -0,0 +1,323 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package customer
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/auth"
+	authControllers "github.com/Tus1688/openmerce-backend/controllers/auth"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ExportData return every personal data stored about the customer, the response is a zip archive containing
+// data.json when format=zip is requested
+func ExportData(c *gin.Context) {
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
+	response, err := collectCustomerData(claims.Uid)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "ExportData: "+err.Error())
+		c.Status(500)
+		return
+	}
+	if c.Query("format") != "zip" {
+		c.Header("Content-Disposition", `attachment; filename="openmerce-data.json"`)
+		c.JSON(200, response)
+		return
+	}
+	data, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	file, err := archive.Create("data.json")
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if _, err := file.Write(data); err != nil {
+		c.Status(500)
+		return
+	}
+	if err := archive.Close(); err != nil {
+		c.Status(500)
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="openmerce-data.zip"`)
+	c.Data(200, "application/zip", buf.Bytes())
+}
+
+func collectCustomerData(customerId string) (models.CustomerDataExport, error) {
+	response := models.CustomerDataExport{
+		ExportedAt: time.Now().UTC(),
+		Addresses:  []models.AddressResponseDetail{},
+		Orders:     []models.OrderExport{},
+		Reviews:    []models.ReviewExport{},
+		Wishlist:   []models.WishlistExport{},
+	}
+	var birthDate sql.NullTime
+	var gender sql.NullString
+	err := database.MysqlInstance.
+		QueryRow(
+			`SELECT email, COALESCE(phone_number, ''), phone_verified_at IS NOT NULL, first_name, last_name, birth_date,
+			       gender FROM customers
+			WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL`, customerId,
+		).
+		Scan(
+			&response.Profile.Email, &response.Profile.PhoneNumber, &response.Profile.PhoneVerified,
+			&response.Profile.FirstName, &response.Profile.LastName, &birthDate, &gender,
+		)
+	if err != nil {
+		return response, err
+	}
+	response.Profile.BirthDate = birthDate.Time
+	response.Profile.Gender = gender.String
+
+	// addresses
+	rows, err := database.MysqlInstance.
+		Query(
+			`SELECT BIN_TO_UUID(ca.id), ca.label, ca.full_address, COALESCE(ca.note, ''), ca.recipient_name,
+			       ca.phone_number, sa.full_name, ca.shipping_area_refer, ca.postal_code
+			FROM customer_addresses ca
+			         LEFT JOIN shipping_areas sa ON ca.shipping_area_refer = sa.id
+			WHERE ca.customer_refer = UUID_TO_BIN(?)`, customerId,
+		)
+	if err != nil {
+		return response, err
+	}
+	for rows.Next() {
+		var address models.AddressResponseDetail
+		if err := rows.Scan(
+			&address.ID, &address.Label, &address.FullAddress, &address.Note, &address.RecipientName,
+			&address.PhoneNumber, &address.ShippingArea, &address.AreaID, &address.PostalCode,
+		); err != nil {
+			rows.Close()
+			return response, err
+		}
+		response.Addresses = append(response.Addresses, address)
+	}
+	rows.Close()
+
+	// orders and their items
+	rows, err = database.MysqlInstance.
+		Query(
+			`SELECT id, BIN_TO_UUID(customer_address_refer), courier_code, COALESCE(courier_tracking_code, ''),
+			       COALESCE(transaction_status, ''), COALESCE(status_description, ''), COALESCE(payment_type, ''),
+			       item_cost, freight_cost, gross_amount, created_at
+			FROM orders WHERE customer_refer = UUID_TO_BIN(?) ORDER BY id`, customerId,
+		)
+	if err != nil {
+		return response, err
+	}
+	orderIndex := make(map[uint64]int)
+	for rows.Next() {
+		order := models.OrderExport{Items: []models.OrderItemExport{}}
+		if err := rows.Scan(
+			&order.ID, &order.AddressID, &order.Courier, &order.TrackingCode, &order.Status,
+			&order.StatusDescription, &order.PaymentType, &order.ItemCost, &order.ShippingCost, &order.TotalCost,
+			&order.CreatedAt,
+		); err != nil {
+			rows.Close()
+			return response, err
+		}
+		orderIndex[order.ID] = len(response.Orders)
+		response.Orders = append(response.Orders, order)
+	}
+	rows.Close()
+	rows, err = database.MysqlInstance.
+		Query(
+			`SELECT oi.order_refer, BIN_TO_UUID(oi.product_refer), oi.on_buy_name, oi.on_buy_variant, oi.on_buy_price, oi.quantity
+			FROM order_items oi
+			         INNER JOIN orders o ON oi.order_refer = o.id
+			WHERE o.customer_refer = UUID_TO_BIN(?)`, customerId,
+		)
+	if err != nil {
+		return response, err
+	}
+	for rows.Next() {
+		var orderId uint64
+		var item models.OrderItemExport
+		if err := rows.Scan(
+			&orderId, &item.ProductID, &item.ProductName, &item.Variant, &item.Price, &item.Quantity,
+		); err != nil {
+			rows.Close()
+			return response, err
+		}
+		if i, ok := orderIndex[orderId]; ok {
+			response.Orders[i].Items = append(response.Orders[i].Items, item)
+		}
+	}
+	rows.Close()
+
+	// reviews
+	rows, err = database.MysqlInstance.
+		Query(
+			`SELECT oi.order_refer, BIN_TO_UUID(r.product_refer), oi.on_buy_name, r.rating, COALESCE(r.review, '')
+			FROM reviews r
+			         INNER JOIN order_items oi ON r.order_item_refer = oi.id
+			         INNER JOIN orders o ON oi.order_refer = o.id
+			WHERE o.customer_refer = UUID_TO_BIN(?)`, customerId,
+		)
+	if err != nil {
+		return response, err
+	}
+	for rows.Next() {
+		var review models.ReviewExport
+		if err := rows.Scan(
+			&review.OrderID, &review.ProductID, &review.ProductName, &review.Rating, &review.Review,
+		); err != nil {
+			rows.Close()
+			return response, err
+		}
+		response.Reviews = append(response.Reviews, review)
+	}
+	rows.Close()
+
+	// wishlist
+	rows, err = database.MysqlInstance.
+		Query(
+			`SELECT BIN_TO_UUID(w.product_refer), COALESCE(p.name, '')
+			FROM wishlists w
+			         LEFT JOIN products p ON w.product_refer = p.id
+			WHERE w.customer_refer = UUID_TO_BIN(?)`, customerId,
+		)
+	if err != nil {
+		return response, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var wishlist models.WishlistExport
+		if err := rows.Scan(&wishlist.ProductID, &wishlist.ProductName); err != nil {
+			return response, err
+		}
+		response.Wishlist = append(response.Wishlist, wishlist)
+	}
+	return response, rows.Err()
+}
+
+// DeleteAccount anonymize the personal data of the customer, the orders are kept for accounting with the
+// anonymized customer and address
+func DeleteAccount(c *gin.Context) {
+	var request models.ReqDeleteAccount
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
+	customerId := claims.Uid
+	var hashedPassword sql.NullString
+	err := database.MysqlInstance.
+		QueryRow(
+			"SELECT hashed_password FROM customers WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL", customerId,
+		).
+		Scan(&hashedPassword)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Status(401)
+			return
+		}
+		c.Status(500)
+		return
+	}
+	if hashedPassword.Valid &&
+		bcrypt.CompareHashAndPassword([]byte(hashedPassword.String), []byte(request.Password)) != nil {
+		c.JSON(401, gin.H{"error": "Invalid password"})
+		return
+	}
+	// the address of an order which is paid but not shipped yet is still needed by the courier
+	var pending int
+	err = database.MysqlInstance.
+		QueryRow(
+			`SELECT COUNT(*) FROM orders
+			WHERE customer_refer = UUID_TO_BIN(?) AND is_paid = TRUE AND is_shipped = FALSE AND is_cancelled = FALSE`,
+			customerId,
+		).
+		Scan(&pending)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if pending > 0 {
+		c.JSON(409, gin.H{"error": "Account can't be deleted while there is an order waiting to be shipped"})
+		return
+	}
+
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := []string{
+		"DELETE FROM cart_items WHERE customer_refer = UUID_TO_BIN(?)",
+		"DELETE FROM wishlists WHERE customer_refer = UUID_TO_BIN(?)",
+		"DELETE FROM customer_identities WHERE customer_refer = UUID_TO_BIN(?)",
+		"DELETE FROM auth_logs WHERE customer_refer = UUID_TO_BIN(?)",
+		// the rating is kept for the product cumulative review
+		`UPDATE reviews SET review = NULL WHERE order_item_refer IN (
+			SELECT oi.id FROM order_items oi INNER JOIN orders o ON oi.order_refer = o.id
+			WHERE o.customer_refer = UUID_TO_BIN(?))`,
+		`DELETE FROM customer_addresses WHERE customer_refer = UUID_TO_BIN(?) AND id NOT IN (
+			SELECT customer_address_refer FROM orders WHERE customer_refer = customer_addresses.customer_refer)`,
+		// the shipping area and postal code are kept for the tax report
+		`UPDATE customer_addresses
+		SET full_address = '', note = NULL, recipient_name = 'Deleted Customer', phone_number = '',
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE customer_refer = UUID_TO_BIN(?)`,
+		`UPDATE customers
+		SET email = CONCAT('deleted-', LOWER(HEX(id)), '@deleted.invalid'), hashed_password = NULL,
+		    phone_number = NULL, phone_verified_at = NULL, first_name = 'Deleted', last_name = 'Customer',
+		    birth_date = NULL, gender = NULL, updated_at = CURRENT_TIMESTAMP, deleted_at = CURRENT_TIMESTAMP
+		WHERE id = UUID_TO_BIN(?)`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, customerId); err != nil {
+			go logging.InsertLog(logging.ERROR, "DeleteAccount: "+err.Error())
+			c.Status(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+
+	if err := authControllers.RevokeCustomerSessions(customerId); err != nil {
+		go logging.InsertLog(logging.ERROR, "DeleteAccount: failed to revoke sessions: "+err.Error())
+	}
+	discardVerification("email:" + customerId)
+	discardVerification("phone:" + customerId)
+	auth.ClearCookie(c, "ac_cus")
+	auth.ClearCookie(c, "ref_cus")
+	c.Status(200)
+}
@@ -22,7 +22,6 @@ package customer
 
 import (
 	"database/sql"
-	"net/mail"
 
 	"github.com/Tus1688/openmerce-backend/auth"
 	"github.com/Tus1688/openmerce-backend/database"
@@ -32,25 +31,21 @@ import (
 )
 
 func GetProfile(c *gin.Context) {
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	var response models.CustomerProfile
-	err = database.MysqlInstance.
+	err := database.MysqlInstance.
 		QueryRow(
 			`
-			SELECT email, COALESCE(phone_number, ''), first_name, last_name, birth_date, gender FROM customers
+			SELECT email, COALESCE(phone_number, ''), phone_verified_at IS NOT NULL, first_name, last_name, birth_date,
+			       gender FROM customers
 			WHERE id = UUID_TO_BIN(?)
 		`, customerId,
 		).
 		Scan(
-			&response.Email, &response.PhoneNumber, &response.FirstName, &response.LastName, &response.BirthDate,
-			&response.Gender,
+			&response.Email, &response.PhoneNumber, &response.PhoneVerified, &response.FirstName, &response.LastName,
+			&response.BirthDate, &response.Gender,
 		)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -70,14 +65,27 @@ func UpdateProfile(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
+	customerId := claims.Uid
+	// the email and phone number can only be changed through their verification flow, the current value is still
+	// accepted as the frontend sends the whole profile back
+	var currentEmail, currentPhoneNumber string
+	err := database.MysqlInstance.
+		QueryRow("SELECT email, COALESCE(phone_number, '') FROM customers WHERE id = UUID_TO_BIN(?)", customerId).
+		Scan(&currentEmail, &currentPhoneNumber)
 	if err != nil {
-		c.Status(401)
+		c.Status(500)
+		return
+	}
+	if request.Email != "" && request.Email != currentEmail {
+		c.JSON(409, gin.H{"error": "Email must be changed through the email change verification"})
+		return
+	}
+	if request.PhoneNumber != "" && request.PhoneNumber != currentPhoneNumber {
+		c.JSON(409, gin.H{"error": "Phone number must be changed through the phone verification"})
 		return
 	}
-	customerId := claims.Uid
 	query := "UPDATE customers SET updated_at = CURRENT_TIMESTAMP"
 	var args []interface{}
 	if request.FirstName != "" {
@@ -88,19 +96,6 @@ func UpdateProfile(c *gin.Context) {
 		query += ", last_name = ?"
 		args = append(args, request.LastName)
 	}
-	if request.Email != "" {
-		// check if the email is valid or not
-		if _, err := mail.ParseAddress(request.Email); err != nil {
-			c.Status(400)
-			return
-		}
-		query += ", email = ?"
-		args = append(args, request.Email)
-	}
-	if request.PhoneNumber != "" {
-		query += ", phone_number = ?"
-		args = append(args, request.PhoneNumber)
-	}
 	if !request.BirthDate.IsZero() {
 		query += ", birth_date = ?"
 		args = append(args, request.BirthDate)
@@ -129,17 +124,12 @@ func UpdatePassword(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	var oldPassword string
-	err = database.MysqlInstance.
-		QueryRow("SELECT hashed_password FROM customers WHERE id = UUID_TO_BIN(?)", customerId).
+	err := database.MysqlInstance.
+		QueryRow("SELECT COALESCE(hashed_password, '') FROM customers WHERE id = UUID_TO_BIN(?)", customerId).
 		Scan(&oldPassword)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -150,7 +140,8 @@ func UpdatePassword(c *gin.Context) {
 		c.Status(500)
 		return
 	}
-	if !request.CheckPassword(oldPassword) {
+	// customer who signed up through an oidc provider has no password yet, so they can set one without the old one
+	if oldPassword != "" && !request.CheckPassword(oldPassword) {
 		c.Status(401)
 		return
 	}
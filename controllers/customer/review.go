@@ -41,17 +41,12 @@ func CreateReview(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	// check if the order id belongs to the customer
 	var exist int8
-	err = database.MysqlInstance.
+	err := database.MysqlInstance.
 		QueryRow(
 			"SELECT 1 FROM order_items oi LEFT JOIN orders o on oi.order_refer = o.id WHERE o.customer_refer = UUID_TO_BIN(?) AND oi.id = ?",
 			customerId, request.OrderID,
@@ -85,13 +80,8 @@ func CreateReview(c *gin.Context) {
 
 // GetReview here is meant to get the review of every review of the customer
 func GetReview(c *gin.Context) {
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	var response []models.ReviewResponseCustomer
 	rows, err := database.MysqlInstance.
@@ -108,10 +98,8 @@ func GetReview(c *gin.Context) {
 			         LEFT JOIN order_items oi on r.order_item_refer = oi.id
 			         LEFT JOIN products p on r.product_refer = p.id
 			         LEFT JOIN orders o on oi.order_refer = o.id
-			         left join (select pi.product_refer, CONCAT(BIN_TO_UUID(pi.id), '.webp') as image
-			                    from (select product_refer, min(id) as id
-			                          from product_images
-			                          group by product_refer) pi) pi on pi.product_refer = oi.product_refer
+			         left join (select product_refer, CONCAT(BIN_TO_UUID(id), '.webp') as image
+			                    from product_images where is_primary = 1) pi on pi.product_refer = oi.product_refer
 			WHERE o.customer_refer = UUID_TO_BIN(?);
 			`, customerId,
 		)
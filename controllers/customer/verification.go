@@ -0,0 +1,328 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package customer
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/auth"
+	authControllers "github.com/Tus1688/openmerce-backend/controllers/auth"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/mailgun"
+	"github.com/Tus1688/openmerce-backend/service/otp"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// verificationTTL is how long a code sent to the new email or phone number is valid
+	verificationTTL = 10 * time.Minute
+	// verificationCooldown is the minimum time between two codes for the same customer
+	verificationCooldown = time.Minute
+	// verificationMaxAttempts is the number of wrong codes before the pending verification is discarded
+	verificationMaxAttempts = 5
+	// verificationAttempts is the suffix of the key which counts the checks of a pending verification
+	verificationAttempts = ":attempts"
+)
+
+// pendingVerification is stored on redisInstance[9] while the customer has not confirmed the code
+type pendingVerification struct {
+	Target string `json:"target"`
+	Code   string `json:"code"`
+}
+
+// RequestEmailChange send a verification code to the new email address
+func RequestEmailChange(c *gin.Context) {
+	var request models.ReqEmailChange
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	address, err := mail.ParseAddress(request.Email)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+	email := address.Address
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
+	var exist int8
+	err = database.MysqlInstance.QueryRow("SELECT 1 FROM customers WHERE email = ?", email).Scan(&exist)
+	if err != nil && err != sql.ErrNoRows {
+		c.Status(500)
+		return
+	}
+	if exist == 1 {
+		c.JSON(409, gin.H{"error": "Email already registered"})
+		return
+	}
+	blacklisted, err := authControllers.IsDomainBlacklisted(email[strings.LastIndex(email, "@")+1:])
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if blacklisted {
+		c.JSON(400, gin.H{"error": "Email domain is not allowed"})
+		return
+	}
+	code, err := createVerification("email:"+claims.Uid, email)
+	if err != nil {
+		if err == errVerificationCooldown {
+			c.JSON(429, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(500)
+		return
+	}
+	err = mailgun.SendEmail(
+		mailgun.Send{
+			FromName:    "Openmerce Auth Service",
+			FromAddress: "noreply",
+			To:          email,
+			Subject:     "Openmerce Email Change Verification",
+			Body:        "Your verification code is: " + code,
+		},
+	)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	c.Status(200)
+}
+
+// ConfirmEmailChange change the email of the customer once the code is correct and notify the old email address
+func ConfirmEmailChange(c *gin.Context) {
+	var request models.ReqVerificationCode
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
+	newEmail, status := checkVerification("email:"+claims.Uid, request.Code)
+	if status != 200 {
+		c.Status(status)
+		return
+	}
+	var oldEmail string
+	err := database.MysqlInstance.
+		QueryRow("SELECT email FROM customers WHERE id = UUID_TO_BIN(?)", claims.Uid).
+		Scan(&oldEmail)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	_, err = database.MysqlInstance.Exec(
+		"UPDATE customers SET email = ?, updated_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?)", newEmail,
+		claims.Uid,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			c.JSON(409, gin.H{"error": "Email already registered"})
+			return
+		}
+		c.Status(500)
+		return
+	}
+	discardVerification("email:" + claims.Uid)
+	go func(oldEmail string, newEmail string) {
+		err := mailgun.SendEmail(
+			mailgun.Send{
+				FromName:    "Openmerce Auth Service",
+				FromAddress: "noreply",
+				To:          oldEmail,
+				Subject:     "Openmerce Email Changed",
+				Body: "The email address of your Openmerce account has been changed to " + newEmail +
+					". If you did not make this change, please contact our support immediately.",
+			},
+		)
+		if err != nil {
+			logging.InsertLog(logging.WARN, "ConfirmEmailChange:"+err.Error())
+		}
+	}(oldEmail, newEmail)
+	c.Status(200)
+}
+
+// RequestPhoneVerification send a one time password to the phone number through the configured otp sender
+func RequestPhoneVerification(c *gin.Context) {
+	if otp.DefaultSender == nil {
+		c.JSON(503, gin.H{"error": "Phone verification is not available"})
+		return
+	}
+	var request models.ReqPhoneVerification
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	phoneNumber, ok := models.NormalizePhoneNumber(request.PhoneNumber)
+	if !ok {
+		c.JSON(400, gin.H{"error": "Invalid phone number"})
+		return
+	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
+	var exist int8
+	err := database.MysqlInstance.QueryRow(
+		"SELECT 1 FROM customers WHERE phone_number = ? AND id != UUID_TO_BIN(?)", phoneNumber, claims.Uid,
+	).Scan(&exist)
+	if err != nil && err != sql.ErrNoRows {
+		c.Status(500)
+		return
+	}
+	if exist == 1 {
+		c.JSON(409, gin.H{"error": "Phone number already registered"})
+		return
+	}
+	code, err := createVerification("phone:"+claims.Uid, phoneNumber)
+	if err != nil {
+		if err == errVerificationCooldown {
+			c.JSON(429, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(500)
+		return
+	}
+	if err := otp.DefaultSender.SendOtp(phoneNumber, code); err != nil {
+		go logging.InsertLog(logging.ERROR, "RequestPhoneVerification:"+err.Error())
+		c.Status(500)
+		return
+	}
+	c.Status(200)
+}
+
+// ConfirmPhoneVerification save the phone number as verified once the code is correct
+func ConfirmPhoneVerification(c *gin.Context) {
+	if otp.DefaultSender == nil {
+		c.JSON(503, gin.H{"error": "Phone verification is not available"})
+		return
+	}
+	var request models.ReqVerificationCode
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
+	phoneNumber, status := checkVerification("phone:"+claims.Uid, request.Code)
+	if status != 200 {
+		c.Status(status)
+		return
+	}
+	_, err := database.MysqlInstance.Exec(
+		"UPDATE customers SET phone_number = ?, phone_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?)",
+		phoneNumber, claims.Uid,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			c.JSON(409, gin.H{"error": "Phone number already registered"})
+			return
+		}
+		c.Status(500)
+		return
+	}
+	discardVerification("phone:" + claims.Uid)
+	c.Status(200)
+}
+
+var errVerificationCooldown = fmt.Errorf(
+	"please wait %d seconds before requesting another code", int(verificationCooldown.Seconds()),
+)
+
+// createVerification store a new random 6-digit code for the target, it replaces the previous pending verification
+func createVerification(key string, target string) (string, error) {
+	ctx := context.Background()
+	ttl, err := database.RedisInstance[9].TTL(ctx, key).Result()
+	if err != nil {
+		return "", err
+	}
+	if ttl > verificationTTL-verificationCooldown {
+		return "", errVerificationCooldown
+	}
+	randNumber, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", randNumber.Int64())
+	value, err := json.Marshal(pendingVerification{Target: target, Code: code})
+	if err != nil {
+		return "", err
+	}
+	// the new code starts with no attempt
+	pipe := database.RedisInstance[9].TxPipeline()
+	pipe.Set(ctx, key, value, verificationTTL)
+	pipe.Del(ctx, key+verificationAttempts)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// checkVerification return the target of the pending verification and 200 when the code is correct, otherwise the
+// status code which should be returned to the customer
+func checkVerification(key string, code string) (string, int) {
+	ctx := context.Background()
+	res, err := database.RedisInstance[9].Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			// the code has expired or was never requested
+			return "", 404
+		}
+		return "", 500
+	}
+	// every check is counted with INCR before the code is compared, so parallel guesses can't exceed the limit
+	attempts, err := database.RedisInstance[9].Incr(ctx, key+verificationAttempts).Result()
+	if err != nil {
+		return "", 500
+	}
+	if attempts == 1 {
+		_ = database.RedisInstance[9].Expire(ctx, key+verificationAttempts, verificationTTL).Err()
+	}
+	if attempts > verificationMaxAttempts {
+		discardVerification(key)
+		return "", 401
+	}
+	var value pendingVerification
+	if err := json.Unmarshal([]byte(res), &value); err != nil {
+		return "", 500
+	}
+	if value.Code != code {
+		if attempts == verificationMaxAttempts {
+			discardVerification(key)
+		}
+		return "", 401
+	}
+	return value.Target, 200
+}
+
+// discardVerification remove the pending verification along with its attempt count
+func discardVerification(key string) {
+	_ = database.RedisInstance[9].Del(context.Background(), key, key+verificationAttempts).Err()
+}
@@ -36,18 +36,15 @@ func AddToWishlist(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	// check if the product exist
 	var exists uint8
-	err = database.MysqlInstance.
-		QueryRow("SELECT 1 FROM products WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL", request.ID).
+	err := database.MysqlInstance.
+		QueryRow(
+			"SELECT 1 FROM products WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL AND status = 'published'", request.ID,
+		).
 		Scan(&exists)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -78,13 +75,8 @@ func DeleteWishlist(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 	res, err := database.MysqlInstance.Exec(
 		"DELETE FROM wishlists WHERE product_refer = UUID_TO_BIN(?) AND customer_refer = UUID_TO_BIN(?)", request.ID,
@@ -107,13 +99,8 @@ func DeleteWishlist(c *gin.Context) {
 }
 
 func GetWishlist(c *gin.Context) {
-	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
-	token, _ := c.Cookie("ac_cus")
-	claims, err := auth.ExtractClaimAccessTokenCustomer(token)
-	if err != nil {
-		c.Status(401)
-		return
-	}
+	// the claims are verified by TokenExpiredCustomer middleware
+	claims := auth.CustomerClaims(c)
 	customerId := claims.Uid
 
 	var request models.APICommonQueryUUID
@@ -153,14 +140,7 @@ func GetWishlist(c *gin.Context) {
 			FROM
 			    wishlists w
 			    left join products p on p.id = w.product_refer
-			        LEFT JOIN (
-			        SELECT
-			            id,
-			            product_refer,
-			            ROW_NUMBER() OVER (PARTITION BY product_refer ORDER BY created_at) AS rn
-			        FROM
-			            product_images
-			    ) pi ON p.id = pi.product_refer AND pi.rn = 1
+			        LEFT JOIN product_images pi ON p.id = pi.product_refer AND pi.is_primary = 1
 			        LEFT JOIN
 			    order_items oi ON oi.product_refer = p.id
 			        LEFT JOIN
@@ -168,7 +148,7 @@ func GetWishlist(c *gin.Context) {
 			        AND (o.transaction_status = 'settlement'
 			            OR o.transaction_status = 'capture')
 			WHERE
-			    p.deleted_at IS NULL
+			    p.deleted_at IS NULL AND p.status = 'published'
 			AND w.customer_refer = UUID_TO_BIN(?)
 			GROUP BY
 			    p.id,
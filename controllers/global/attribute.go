@@ -0,0 +1,167 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package global
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// maxAttributeFilters limit the number of attribute filters of a listing as each of them is a subquery
+const maxAttributeFilters = 10
+
+// GetCategoryAttribute return the filterable attributes of a category for the filter panel of the listing
+func GetCategoryAttribute(c *gin.Context) {
+	var request models.CategoryAttributeQuery
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	attributes, err := CategoryAttributes(request.CategoryID, true)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	c.JSON(200, attributes)
+}
+
+// CategoryAttributes return the attribute schema of a category ordered by its position
+func CategoryAttributes(categoryId uint, filterableOnly bool) ([]models.CategoryAttribute, error) {
+	query := `SELECT id, category_refer, code, name, type, unit, COALESCE(options, '[]'), required, filterable, position
+		FROM category_attributes WHERE category_refer = ?`
+	if filterableOnly {
+		query += " AND filterable = 1"
+	}
+	rows, err := database.MysqlInstance.Query(query+" ORDER BY position, id", categoryId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	attributes := []models.CategoryAttribute{}
+	for rows.Next() {
+		var attribute models.CategoryAttribute
+		var options []byte
+		if err := rows.Scan(
+			&attribute.ID, &attribute.CategoryID, &attribute.Code, &attribute.Name, &attribute.Type, &attribute.Unit,
+			&options, &attribute.Required, &attribute.Filterable, &attribute.Position,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(options, &attribute.Options); err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes, rows.Err()
+}
+
+// ProductAttributes return the attribute values of a product ordered by the position of the attribute
+func ProductAttributes(productId string) ([]models.ProductAttributeValue, error) {
+	rows, err := database.MysqlInstance.
+		Query(
+			`
+			SELECT a.code, a.name, a.type, a.unit, COALESCE(v.value_text, ''), COALESCE(v.value_number, 0)
+			FROM product_attribute_values v
+			         INNER JOIN category_attributes a ON a.id = v.attribute_refer
+			         INNER JOIN products p ON p.id = v.product_refer AND p.category_refer = a.category_refer
+			WHERE v.product_refer = UUID_TO_BIN(?)
+			ORDER BY a.position, a.id`, productId,
+		)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := []models.ProductAttributeValue{}
+	for rows.Next() {
+		var value models.ProductAttributeValue
+		var text string
+		var number float64
+		if err := rows.Scan(&value.Code, &value.Name, &value.Type, &value.Unit, &text, &number); err != nil {
+			return nil, err
+		}
+		switch value.Type {
+		case "number":
+			value.Value = number
+		case "boolean":
+			value.Value = text == "true"
+		default:
+			value.Value = text
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// attributeFilter build the condition of the attribute filters of a listing, a value is matched against the text
+// and the number column as the type of the attribute isn't known from the query
+func attributeFilter(filters map[string]string) (string, []interface{}) {
+	var condition string
+	var args []interface{}
+	count := 0
+	for code, raw := range filters {
+		if count == maxAttributeFilters || raw == "" {
+			continue
+		}
+		count++
+		condition += ` AND EXISTS (
+			SELECT 1 FROM product_attribute_values v INNER JOIN category_attributes a ON a.id = v.attribute_refer
+			WHERE v.product_refer = p.id AND a.category_refer = p.category_refer AND a.code = ? AND `
+		args = append(args, code)
+		if from, to, ok := strings.Cut(raw, ".."); ok {
+			var bounds []string
+			if from, err := strconv.ParseFloat(from, 64); err == nil {
+				bounds = append(bounds, "v.value_number >= ?")
+				args = append(args, from)
+			}
+			if to, err := strconv.ParseFloat(to, 64); err == nil {
+				bounds = append(bounds, "v.value_number <= ?")
+				args = append(args, to)
+			}
+			if len(bounds) == 0 {
+				bounds = append(bounds, "v.value_number IS NOT NULL")
+			}
+			condition += strings.Join(bounds, " AND ") + ")"
+			continue
+		}
+		values := strings.Split(raw, ",")
+		condition += "(v.value_text IN (?" + strings.Repeat(", ?", len(values)-1) + ")"
+		for _, value := range values {
+			args = append(args, strings.TrimSpace(value))
+		}
+		var numbers []interface{}
+		for _, value := range values {
+			if number, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				numbers = append(numbers, number)
+			}
+		}
+		if len(numbers) > 0 {
+			condition += " OR v.value_number IN (?" + strings.Repeat(", ?", len(numbers)-1) + ")"
+			args = append(args, numbers...)
+		}
+		condition += "))"
+	}
+	return condition, args
+}
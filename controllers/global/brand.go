@@ -0,0 +1,98 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package global
+
+import (
+	"database/sql"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// GetBrand return every brand, or the brand page along with a page of its products when the slug is given
+func GetBrand(c *gin.Context) {
+	var request models.BrandQuery
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	if request.Slug == "" {
+		var response []models.Brand
+		rows, err := database.MysqlInstance.
+			Query("SELECT id, name, slug, description, COALESCE(logo, '') FROM brands WHERE deleted_at IS NULL ORDER BY name")
+		if err != nil {
+			c.Status(500)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var brand models.Brand
+			if err := rows.Scan(&brand.ID, &brand.Name, &brand.Slug, &brand.Description, &brand.Logo); err != nil {
+				c.Status(500)
+				return
+			}
+			response = append(response, brand)
+		}
+		if len(response) == 0 {
+			c.Status(404)
+			return
+		}
+		c.JSON(200, response)
+		return
+	}
+
+	var response models.BrandPage
+	err := database.MysqlInstance.
+		QueryRow(
+			"SELECT id, name, slug, description, COALESCE(logo, '') FROM brands WHERE slug = ? AND deleted_at IS NULL",
+			request.Slug,
+		).
+		Scan(
+			&response.Brand.ID, &response.Brand.Name, &response.Brand.Slug, &response.Brand.Description,
+			&response.Brand.Logo,
+		)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Status(404)
+			return
+		}
+		c.Status(500)
+		return
+	}
+	products, total, _, err := ListProducts(
+		models.CatalogQuery{
+			PageQuery: request.PageQuery, Brand: response.Brand.ID, Sort: request.Sort, Status: "published",
+		},
+	)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "brand:"+err.Error())
+		c.Status(500)
+		return
+	}
+	data := make([]models.HomepageProduct, 0, len(products))
+	for _, product := range products {
+		data = append(data, product.HomepageProduct)
+	}
+	response.Products = models.NewPaginatedResponse(data, request.PageQuery, total)
+	c.JSON(200, response)
+}
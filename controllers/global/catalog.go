@@ -0,0 +1,206 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package global
+
+import (
+	"strings"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/search"
+)
+
+// listingPrice is the price a product is listed, sorted and filtered at: the cheapest of its variants, a variant
+// without its own price costs the base price of the product
+const listingPrice = "COALESCE(sku.price, p.price)"
+
+// catalogSort map the sort query into the ORDER BY clause, p.id is the tiebreaker to keep the pages stable
+var catalogSort = map[string]string{
+	"newest":       "p.created_at DESC, p.id",
+	"price_asc":    listingPrice + " ASC, p.id",
+	"price_desc":   listingPrice + " DESC, p.id",
+	"best_selling": "sold DESC, p.id",
+	"rating":       "p.cumulative_review DESC, p.id",
+}
+
+// ListProducts return a page of the products matching the query along with the total of the matching products, it
+// backs both the public listing and the staff product table. The full text part is answered by the search engine
+// which also gives the facets, they are nil when the search falls back to mysql. The search engine only knows the
+// published products so the other listings always search with mysql
+func ListProducts(request models.CatalogQuery) ([]models.StaffProductResponse, uint64, *search.Facets, error) {
+	// listing a category includes the products of its descendants
+	var categories []uint
+	if request.Category != 0 {
+		var err error
+		categories, err = CategoryDescendants(request.Category)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if len(categories) == 0 {
+			return []models.StaffProductResponse{}, 0, nil, nil
+		}
+	}
+	var hits []string
+	var hitsTotal int
+	var facets *search.Facets
+	if request.Search != "" && search.DefaultEngine != nil && request.Status == "published" {
+		result, err := search.DefaultEngine.Search(
+			search.Query{
+				Text: request.Search, Categories: categories, Brand: request.Brand,
+				PriceFrom: request.PriceFrom, PriceTo: request.PriceTo,
+			},
+		)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		facets = &result.Facets
+		if len(result.IDs) == 0 {
+			return []models.StaffProductResponse{}, 0, facets, nil
+		}
+		hits = result.IDs
+		hitsTotal = result.Total
+	}
+	from := `
+		FROM products p
+		         LEFT JOIN (
+		    SELECT s.product_refer, MIN(COALESCE(s.price, sp.price)) AS price, SUM(i.quantity) AS quantity
+		    FROM product_skus s
+		             INNER JOIN products sp ON sp.id = s.product_refer
+		             LEFT JOIN inventories i ON i.sku_refer = s.id
+		    WHERE s.deleted_at IS NULL
+		    GROUP BY s.product_refer
+		) sku ON sku.product_refer = p.id
+		WHERE p.deleted_at IS NULL`
+	var args []interface{}
+	if hits != nil {
+		from += " AND p.id IN (UUID_TO_BIN(?)" + strings.Repeat(", UUID_TO_BIN(?)", len(hits)-1) + ")"
+		for _, id := range hits {
+			args = append(args, id)
+		}
+	} else if request.Search != "" {
+		// every word is required, the operators of the user input are dropped by the tokenizer
+		var terms []string
+		for _, token := range search.Tokenize(request.Search) {
+			terms = append(terms, "+"+token+"*")
+		}
+		if len(terms) == 0 {
+			return []models.StaffProductResponse{}, 0, nil, nil
+		}
+		from += " AND MATCH(p.name) AGAINST(? IN BOOLEAN MODE)"
+		args = append(args, strings.Join(terms, " "))
+	}
+	if len(categories) > 0 {
+		from += " AND p.category_refer IN (?" + strings.Repeat(", ?", len(categories)-1) + ")"
+		for _, id := range categories {
+			args = append(args, id)
+		}
+	}
+	if request.Brand != 0 {
+		from += " AND p.brand_refer = ?"
+		args = append(args, request.Brand)
+	}
+	if request.PriceFrom != 0 {
+		from += " AND " + listingPrice + " >= ?"
+		args = append(args, request.PriceFrom)
+	}
+	if request.PriceTo != 0 {
+		from += " AND " + listingPrice + " <= ?"
+		args = append(args, request.PriceTo)
+	}
+	if request.MinRating != 0 {
+		from += " AND p.cumulative_review >= ?"
+		args = append(args, request.MinRating)
+	}
+	if request.InStock {
+		from += " AND sku.quantity > 0"
+	}
+	if len(request.Attributes) > 0 {
+		condition, conditionArgs := attributeFilter(request.Attributes)
+		from += condition
+		args = append(args, conditionArgs...)
+	}
+	if request.Status != "" {
+		from += " AND p.status = ?"
+		args = append(args, request.Status)
+	}
+
+	var total uint64
+	if err := database.MysqlInstance.QueryRow("SELECT COUNT(*) "+from, args...).Scan(&total); err != nil {
+		return nil, 0, nil, err
+	}
+	if total == 0 {
+		return []models.StaffProductResponse{}, 0, facets, nil
+	}
+	// the search engine return at most search.MaxHits ids, the total is then its own estimate minus the hits which
+	// didn't pass the filters above
+	if hitsTotal > len(hits) {
+		total = uint64(hitsTotal - len(hits) + int(total))
+	}
+
+	// the sold count and the first image are only resolved for the rows of the requested page
+	query := `
+		SELECT BIN_TO_UUID(p.id),
+		       p.name,
+		       p.slug,
+		       ` + listingPrice + `,
+		       COALESCE((SELECT CONCAT(BIN_TO_UUID(pi.id), '.webp') FROM product_images pi
+		                 WHERE pi.product_refer = p.id AND pi.is_primary = 1), '') AS image,
+		       p.cumulative_review,
+		       COALESCE((SELECT SUM(oi.quantity) FROM order_items oi INNER JOIN orders o ON oi.order_refer = o.id
+		                 WHERE oi.product_refer = p.id AND o.transaction_status IN ('settlement', 'capture')), 0) AS sold,
+		       COALESCE(sku.quantity, 0),
+		       p.status` + from
+	queryArgs := append([]interface{}{}, args...)
+	if hits != nil && (request.Sort == "" || request.Sort == "relevance") {
+		// the hits are already ordered by relevance
+		query += " ORDER BY FIELD(BIN_TO_UUID(p.id)" + strings.Repeat(", ?", len(hits)) + ")"
+		for _, id := range hits {
+			queryArgs = append(queryArgs, id)
+		}
+	} else if request.Search != "" && (request.Sort == "" || request.Sort == "relevance") {
+		query += " ORDER BY MATCH(p.name) AGAINST(? IN BOOLEAN MODE) DESC, p.id"
+		queryArgs = append(queryArgs, args[0])
+	} else if order, ok := catalogSort[request.Sort]; ok {
+		query += " ORDER BY " + order
+	} else {
+		query += " ORDER BY " + catalogSort["newest"]
+	}
+	query += " LIMIT ? OFFSET ?"
+	queryArgs = append(queryArgs, request.Limit(), request.Offset())
+
+	rows, err := database.MysqlInstance.Query(query, queryArgs...)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer rows.Close()
+	response := []models.StaffProductResponse{}
+	for rows.Next() {
+		var product models.StaffProductResponse
+		if err := rows.Scan(
+			&product.ID, &product.Name, &product.Slug, &product.Price, &product.ImageUrl, &product.Rating, &product.Sold,
+			&product.Stock, &product.Status,
+		); err != nil {
+			return nil, 0, nil, err
+		}
+		response = append(response, product)
+	}
+	return response, total, facets, rows.Err()
+}
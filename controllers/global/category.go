@@ -21,15 +21,23 @@
 package global
 
 import (
+	"database/sql"
+	"net/url"
+
 	"github.com/Tus1688/openmerce-backend/database"
 	"github.com/Tus1688/openmerce-backend/models"
 	"github.com/gin-gonic/gin"
 )
 
 func GetCategory(c *gin.Context) {
+	if slug := c.Query("slug"); slug != "" {
+		getCategoryBySlug(c, slug)
+		return
+	}
 	var response []models.CategoryResponseCompact
+	// the categories are ordered by their position so the tree can be built by the client in a single pass
 	rows, err := database.MysqlInstance.
-		Query("SELECT id, name FROM categories WHERE deleted_at IS NULL")
+		Query("SELECT id, parent_refer, name, slug FROM categories WHERE deleted_at IS NULL ORDER BY position, id")
 	if err != nil {
 		c.Status(500)
 		return
@@ -37,7 +45,7 @@ func GetCategory(c *gin.Context) {
 	defer rows.Close()
 	for rows.Next() {
 		var category models.CategoryResponseCompact
-		if err := rows.Scan(&category.ID, &category.Name); err != nil {
+		if err := rows.Scan(&category.ID, &category.ParentID, &category.Name, &category.Slug); err != nil {
 			c.Status(500)
 			return
 		}
@@ -49,3 +57,88 @@ func GetCategory(c *gin.Context) {
 	}
 	c.JSON(200, response)
 }
+
+// CategoryDescendants return the category along with every category below it, it is empty when the category does
+// not exist
+func CategoryDescendants(categoryId uint) ([]uint, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		WITH RECURSIVE tree AS (
+		    SELECT id FROM categories WHERE id = ? AND deleted_at IS NULL
+		    UNION ALL
+		    SELECT c.id FROM categories c INNER JOIN tree t ON c.parent_refer = t.id WHERE c.deleted_at IS NULL
+		)
+		SELECT id FROM tree`, categoryId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []uint
+	for rows.Next() {
+		var id uint
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CategoryPath return the breadcrumbs of the category, from the root down to the category itself
+func CategoryPath(categoryId uint) ([]models.CategoryResponseCompact, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		WITH RECURSIVE path AS (
+		    SELECT id, parent_refer, name, slug, 0 AS depth FROM categories WHERE id = ?
+		    UNION ALL
+		    SELECT c.id, c.parent_refer, c.name, c.slug, p.depth + 1
+		    FROM categories c INNER JOIN path p ON c.id = p.parent_refer
+		)
+		SELECT id, name, slug FROM path ORDER BY depth DESC`, categoryId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	path := []models.CategoryResponseCompact{}
+	for rows.Next() {
+		var category models.CategoryResponseCompact
+		if err := rows.Scan(&category.ID, &category.Name, &category.Slug); err != nil {
+			return nil, err
+		}
+		path = append(path, category)
+	}
+	return path, rows.Err()
+}
+
+// getCategoryBySlug answer the readable url of the category, an old slug is redirected to the current one
+func getCategoryBySlug(c *gin.Context, slug string) {
+	id, current, err := categoryBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Status(404)
+			return
+		}
+		c.Status(500)
+		return
+	}
+	if current != slug {
+		c.Redirect(301, c.Request.URL.Path+"?slug="+url.QueryEscape(current))
+		return
+	}
+	response := models.CategoryDetail{ID: id, Slug: current, CanonicalUrl: CategoryUrl(current)}
+	err = database.MysqlInstance.
+		QueryRow("SELECT parent_refer, name, description FROM categories WHERE id = ?", id).
+		Scan(&response.ParentID, &response.Name, &response.Description)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	response.Breadcrumbs, err = CategoryPath(id)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	c.JSON(200, response)
+}
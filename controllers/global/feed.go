@@ -0,0 +1,413 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package global
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/service/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// FeedTitle is the name of the store on the product feeds
+var FeedTitle = "Openmerce"
+
+// FeedCurrency is the ISO 4217 currency of the prices on the product feeds
+var FeedCurrency = "IDR"
+
+// feedFiles map the generated files into their content type, they are kept in redis db 12 by their name
+var feedFiles = map[string]string{
+	"sitemap.xml": "application/xml; charset=utf-8",
+	"google.xml":  "application/xml; charset=utf-8",
+	"google.tsv":  "text/tab-separated-values; charset=utf-8",
+	"meta.csv":    "text/csv; charset=utf-8",
+}
+
+const (
+	feedGeneratedKey = "generated_at"
+	// feedMaxAge is how long the clients and the proxies may cache a generated file
+	feedMaxAge = 15 * time.Minute
+	// maxAdditionalImages is the limit of Google Merchant Center, Meta takes up to 20
+	maxAdditionalImages = 10
+)
+
+// googleColumns are the attributes of the Google Merchant Center feed, metaColumns are the ones of the Meta catalog
+var (
+	googleColumns = []string{
+		"id", "item_group_id", "title", "description", "link", "image_link", "additional_image_link", "availability",
+		"price", "brand", "gtin", "mpn", "identifier_exists", "condition", "product_type",
+	}
+	metaColumns = []string{
+		"id", "item_group_id", "title", "description", "link", "image_link", "additional_image_link", "availability",
+		"price", "brand", "gtin", "mpn", "condition", "product_type",
+	}
+)
+
+var feedLock sync.Mutex
+
+// feedItem is a variant of a published product, the feeds list every variant and group them by the product
+type feedItem struct {
+	id          string
+	productId   string
+	title       string
+	description string
+	slug        string
+	images      []string
+	stock       uint
+	price       uint
+	brand       string
+	gtin        string
+	mpn         string
+	productType string
+	updatedAt   time.Time
+}
+
+// value return the feed attribute of the item, the urls are absolute when StorefrontUrl and MEDIA_BASE_URL are set
+func (item feedItem) value(column string) string {
+	switch column {
+	case "id":
+		return item.id
+	case "item_group_id":
+		return item.productId
+	case "title":
+		return item.title
+	case "description":
+		return item.description
+	case "link":
+		return ProductUrl(item.slug)
+	case "image_link":
+		if len(item.images) > 0 {
+			return storage.Url(item.images[0])
+		}
+	case "additional_image_link":
+		var urls []string
+		for i := 1; i < len(item.images) && i <= maxAdditionalImages; i++ {
+			urls = append(urls, storage.Url(item.images[i]))
+		}
+		return strings.Join(urls, ",")
+	case "availability":
+		if item.stock > 0 {
+			return "in stock"
+		}
+		return "out of stock"
+	case "price":
+		return fmt.Sprintf("%d %s", item.price, FeedCurrency)
+	case "brand":
+		return item.brand
+	case "gtin":
+		return item.gtin
+	case "mpn":
+		return item.mpn
+	case "identifier_exists":
+		// a product without gtin has to be identified by its brand and mpn
+		if item.gtin == "" && (item.brand == "" || item.mpn == "") {
+			return "no"
+		}
+		return "yes"
+	case "condition":
+		return "new"
+	case "product_type":
+		return item.productType
+	}
+	return ""
+}
+
+// GetFeed serve a generated product feed: google.xml, google.tsv or meta.csv
+func GetFeed(c *gin.Context) {
+	name := c.Param("file")
+	if _, ok := feedFiles[name]; !ok || name == "sitemap.xml" {
+		c.Status(404)
+		return
+	}
+	serveFeed(c, name)
+}
+
+// GetSitemap serve the generated sitemap.xml
+func GetSitemap(c *gin.Context) {
+	serveFeed(c, "sitemap.xml")
+}
+
+// serveFeed answer the cached file, the conditional requests are answered by its generation time
+func serveFeed(c *gin.Context, name string) {
+	content, generatedAt, err := cachedFeed(name)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-feed:"+err.Error())
+		c.Status(500)
+		return
+	}
+	c.Header("Content-Type", feedFiles[name])
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(feedMaxAge.Seconds())))
+	http.ServeContent(c.Writer, c.Request, name, generatedAt, bytes.NewReader(content))
+}
+
+// cachedFeed return the file along with its generation time, the files are generated right away when they are missing
+// e.g. the redis has been flushed
+func cachedFeed(name string) ([]byte, time.Time, error) {
+	for attempt := 0; ; attempt++ {
+		values, err := database.RedisInstance[12].MGet(context.Background(), name, feedGeneratedKey).Result()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		content, hasContent := values[0].(string)
+		generated, hasGenerated := values[1].(string)
+		if hasContent && hasGenerated {
+			generatedAt, err := time.Parse(time.RFC3339, generated)
+			return []byte(content), generatedAt, err
+		}
+		if attempt > 0 {
+			return nil, time.Time{}, errors.New("the feeds are missing after being generated")
+		}
+		if err := RegenerateFeeds(); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+}
+
+// WatchFeeds regenerate the sitemap and the product feeds right away and then every interval
+func WatchFeeds(interval time.Duration) {
+	for {
+		if err := RegenerateFeeds(); err != nil {
+			logging.InsertLog(logging.ERROR, "feeds:"+err.Error())
+		}
+		time.Sleep(interval)
+	}
+}
+
+// RegenerateFeeds build the sitemap and every product feed from the published products, the cached files are replaced
+// at once so they always come from the same generation
+func RegenerateFeeds() error {
+	feedLock.Lock()
+	defer feedLock.Unlock()
+	items, err := feedItems()
+	if err != nil {
+		return err
+	}
+	sitemap, err := buildSitemap(items)
+	if err != nil {
+		return err
+	}
+	googleXML, err := buildGoogleXML(items)
+	if err != nil {
+		return err
+	}
+	metaCSV, err := buildCSV(items, metaColumns)
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		"sitemap.xml": sitemap,
+		"google.xml":  googleXML,
+		"google.tsv":  buildTSV(items, googleColumns),
+		"meta.csv":    metaCSV,
+	}
+	pipe := database.RedisInstance[12].TxPipeline()
+	for name, content := range files {
+		pipe.Set(context.Background(), name, content, 0)
+	}
+	pipe.Set(context.Background(), feedGeneratedKey, time.Now().UTC().Format(time.RFC3339), 0)
+	_, err = pipe.Exec(context.Background())
+	return err
+}
+
+// feedItems return every variant of the published products, the images of the variant come before the ones of the
+// product
+func feedItems() ([]feedItem, error) {
+	categories, err := categoryTypes()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(s.id), BIN_TO_UUID(p.id), p.name, p.slug, p.description, p.category_refer, COALESCE(b.name, ''),
+		       COALESCE(s.sku, ''), COALESCE(s.gtin, ''),
+		       COALESCE((SELECT GROUP_CONCAT(v.value ORDER BY o.position, o.id SEPARATOR ' / ')
+		                 FROM product_sku_values sv
+		                          INNER JOIN product_option_values v ON v.id = sv.option_value_refer
+		                          INNER JOIN product_options o ON o.id = v.option_refer
+		                 WHERE sv.sku_refer = s.id), ''),
+		       COALESCE(s.price, p.price), COALESCE(i.quantity, 0),
+		       COALESCE((SELECT GROUP_CONCAT(CONCAT(BIN_TO_UUID(pi.id), '.webp') ORDER BY pi.sku_refer IS NULL, pi.is_primary DESC, pi.position, pi.id SEPARATOR ' ')
+		                 FROM product_images pi WHERE pi.product_refer = p.id AND (pi.sku_refer IS NULL OR pi.sku_refer = s.id)), ''),
+		       GREATEST(COALESCE(p.updated_at, p.created_at), COALESCE(s.updated_at, s.created_at))
+		FROM products p
+		         INNER JOIN product_skus s ON s.product_refer = p.id AND s.deleted_at IS NULL
+		         LEFT JOIN brands b ON b.id = p.brand_refer AND b.deleted_at IS NULL
+		         LEFT JOIN inventories i ON i.sku_refer = s.id
+		WHERE p.deleted_at IS NULL AND p.status = 'published'
+		ORDER BY p.name, s.created_at, s.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []feedItem
+	for rows.Next() {
+		var item feedItem
+		var categoryId uint
+		var options, images string
+		if err := rows.Scan(
+			&item.id, &item.productId, &item.title, &item.slug, &item.description, &categoryId, &item.brand, &item.mpn,
+			&item.gtin, &options, &item.price, &item.stock, &images, &item.updatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if options != "" {
+			item.title += " - " + options
+		}
+		item.images = strings.Fields(images)
+		item.productType = categories[categoryId]
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// categoryTypes map every category into its path e.g. "Fashion > Men > Shirt", which is the product_type of the feeds
+func categoryTypes() (map[uint]string, error) {
+	rows, err := database.MysqlInstance.Query("SELECT id, COALESCE(parent_refer, 0), name FROM categories")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	parents := map[uint]uint{}
+	names := map[uint]string{}
+	for rows.Next() {
+		var id, parent uint
+		var name string
+		if err := rows.Scan(&id, &parent, &name); err != nil {
+			return nil, err
+		}
+		parents[id] = parent
+		names[id] = name
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	types := map[uint]string{}
+	for id := range names {
+		path := []string{}
+		// the depth is bounded in case the tree is broken by a cycle
+		for current := id; current != 0 && len(path) < len(names); current = parents[current] {
+			path = append([]string{names[current]}, path...)
+		}
+		types[id] = strings.Join(path, " > ")
+	}
+	return types, nil
+}
+
+// googleFeed is the RSS 2.0 feed of Google Merchant Center, the attributes are in the g namespace
+type googleFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	G       string   `xml:"xmlns:g,attr"`
+	Channel struct {
+		Title string       `xml:"title"`
+		Link  string       `xml:"link"`
+		Items []googleItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type googleItem struct {
+	Attributes []googleAttribute
+}
+
+type googleAttribute struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+func buildGoogleXML(items []feedItem) ([]byte, error) {
+	feed := googleFeed{Version: "2.0", G: "http://base.google.com/ns/1.0"}
+	feed.Channel.Title = FeedTitle
+	feed.Channel.Link = StorefrontUrl + "/"
+	for _, item := range items {
+		var element googleItem
+		for _, column := range googleColumns {
+			value := item.value(column)
+			if value == "" {
+				continue
+			}
+			// the additional images are repeated instead of being separated by commas
+			if column == "additional_image_link" {
+				for _, link := range strings.Split(value, ",") {
+					element.Attributes = append(
+						element.Attributes, googleAttribute{XMLName: xml.Name{Local: "g:" + column}, Value: link},
+					)
+				}
+				continue
+			}
+			element.Attributes = append(
+				element.Attributes, googleAttribute{XMLName: xml.Name{Local: "g:" + column}, Value: value},
+			)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, element)
+	}
+	content, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), content...), nil
+}
+
+// buildTSV write the feed as tab separated values, Google doesn't unquote the fields so the tabs and the line breaks
+// of the values are replaced by spaces
+func buildTSV(items []feedItem, columns []string) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString(strings.Join(columns, "\t") + "\n")
+	replacer := strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+	for _, item := range items {
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = replacer.Replace(item.value(column))
+		}
+		buffer.WriteString(strings.Join(values, "\t") + "\n")
+	}
+	return buffer.Bytes()
+}
+
+func buildCSV(items []feedItem, columns []string) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	if err := writer.Write(columns); err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = item.value(column)
+		}
+		if err := writer.Write(values); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
@@ -23,12 +23,16 @@ package global
 import (
 	"context"
 	"database/sql"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Tus1688/openmerce-backend/database"
 	"github.com/Tus1688/openmerce-backend/logging"
 	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/search"
+	"github.com/Tus1688/openmerce-backend/service/storage"
 	"github.com/gin-gonic/gin"
 )
 
@@ -69,22 +73,9 @@ func GetProductSold(c *gin.Context) {
 }
 
 func GetProduct(c *gin.Context) {
-	var requestID models.APICommonQueryUUID
-	var requestSearch models.APICommonQuerySearch
-
-	if err := c.ShouldBindQuery(&requestID); err == nil {
-		var response models.ProductDetail
-
-		err := database.MysqlInstance.QueryRow(
-			`
-			SELECT BIN_TO_UUID(p.id), p.name, p.description, p.price, p.weight, c.name, p.cumulative_review, CONCAT(p.length, ' x ', p.width, ' x ', p.height), i.quantity FROM products p, categories c, inventories i
-			WHERE p.category_refer = c.id AND p.deleted_at IS NULL AND p.id = UUID_TO_BIN(?) AND p.id = i.product_refer`,
-			requestID.ID,
-		).
-			Scan(
-				&response.ID, &response.Name, &response.Description, &response.Price, &response.Weight,
-				&response.CategoryName, &response.CumulativeReview, &response.Dimension, &response.Stock,
-			)
+	// the readable url of the product, an old slug is redirected to the current one
+	if slug := c.Query("slug"); slug != "" {
+		id, current, err := productBySlug(slug)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				c.Status(404)
@@ -93,100 +84,83 @@ func GetProduct(c *gin.Context) {
 			c.Status(500)
 			return
 		}
-		rows, err := database.MysqlInstance.Query(
-			`
-		SELECT CONCAT(BIN_TO_UUID(id), '.webp') FROM product_images WHERE product_refer = UUID_TO_BIN(?)`, requestID.ID,
-		)
+		if current != slug {
+			c.Redirect(301, c.Request.URL.Path+"?slug="+url.QueryEscape(current))
+			return
+		}
+		response, err := ProductDetail(id, true)
 		if err != nil {
+			// the product may be unpublished or deleted in the meantime
+			if err == sql.ErrNoRows {
+				c.Status(404)
+				return
+			}
 			c.Status(500)
 			return
 		}
-		defer rows.Close()
-		for rows.Next() {
-			var image string
-			err := rows.Scan(&image)
-			if err != nil {
-				c.Status(500)
+		c.JSON(200, response)
+		return
+	}
+	var requestID models.APICommonQueryUUID
+
+	if err := c.ShouldBindQuery(&requestID); err == nil {
+		response, err := ProductDetail(requestID.ID, true)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.Status(404)
 				return
 			}
-			response.ImageUrls = append(response.ImageUrls, image)
+			c.Status(500)
+			return
 		}
 		c.JSON(200, response)
 		return
 	}
 
-	if err := c.ShouldBindQuery(&requestSearch); err == nil {
-		sqlQuery :=
-			`
-			SELECT
-			    BIN_TO_UUID(p.id) AS id,
-			    p.name,
-			    p.price,
-			    COALESCE(CONCAT(BIN_TO_UUID(pi.id), '.webp'), '') AS image,
-			    p.cumulative_review,
-			    COUNT(oi.id) AS sold_count
-			FROM
-			    products p
-			        LEFT JOIN (
-			        SELECT
-			            id,
-			            product_refer,
-			            ROW_NUMBER() OVER (PARTITION BY product_refer ORDER BY created_at) AS rn
-			        FROM
-			            product_images
-			    ) pi ON p.id = pi.product_refer AND pi.rn = 1
-			        LEFT JOIN
-			    order_items oi ON oi.product_refer = p.id
-			        LEFT JOIN
-			    orders o ON oi.order_refer = o.id
-			        AND (o.transaction_status = 'settlement'
-			            OR o.transaction_status = 'capture')
-			WHERE
-			    p.deleted_at IS NULL AND MATCH(p.name) AGAINST(? IN BOOLEAN MODE)`
-		args := []interface{}{requestSearch.Search + "*"}
-		category := c.Query("category")
-		if category != "" {
-			sqlQuery += " AND category_refer = ?"
-			args = append(args, category)
-		}
-		priceFrom := c.Query("price_from")
-		if priceFrom != "" {
-			sqlQuery += " AND price >= ?"
-			args = append(args, priceFrom)
+	// search or category listing
+	if c.Query("search") != "" || c.Query("category") != "" || c.Query("brand") != "" {
+		var request models.CatalogQuery
+		if err := c.ShouldBindQuery(&request); err != nil {
+			c.Status(400)
+			return
 		}
-		priceTo := c.Query("price_to")
-		if priceTo != "" {
-			sqlQuery += " AND price <= ?"
-			args = append(args, priceTo)
+		// a client which sends neither page nor page_size still gets the bare array it got before the listing was
+		// paginated, the envelope is only returned when a page is asked for
+		legacy := c.Query("page") == "" && c.Query("page_size") == ""
+		if request.PageSize == 0 {
+			request.PageSize = request.LegacyLimit
 		}
-		sqlQuery += " GROUP BY p.id, image"
-		limit := c.Query("limit")
-		if limit != "" {
-			sqlQuery += " LIMIT ?"
-			args = append(args, limit)
+		if legacy && request.PageSize == 0 {
+			request.PageSize = models.MaxPageSize
 		}
-		var response []models.HomepageProduct
-		rows, err := database.MysqlInstance.Query(sqlQuery, args...)
+		request.Attributes = c.QueryMap("attr")
+		request.Status = "published"
+		products, total, facets, err := ListProducts(request)
 		if err != nil {
+			go logging.InsertLog(logging.ERROR, "search:"+err.Error())
 			c.Status(500)
 			return
 		}
-		defer rows.Close()
-		for rows.Next() {
-			var product models.HomepageProduct
-			if err := rows.Scan(
-				&product.ID, &product.Name, &product.Price, &product.ImageUrl, &product.Rating, &product.Sold,
-			); err != nil {
-				c.Status(500)
+		response := make([]models.HomepageProduct, 0, len(products))
+		for _, product := range products {
+			response = append(response, product.HomepageProduct)
+		}
+		if request.Search != "" && total > 0 {
+			go search.RecordQuery(request.Search)
+		}
+		if legacy {
+			if len(response) == 0 {
+				c.Status(404)
 				return
 			}
-			response = append(response, product)
-		}
-		if response == nil {
-			c.Status(404)
+			c.JSON(200, response)
 			return
 		}
-		c.JSON(200, response)
+		paginated := models.NewPaginatedResponse(response, request.PageQuery, total)
+		if facets != nil {
+			paginated.Facets = facets
+		}
+		c.JSON(200, paginated)
 		return
 	}
 	// check if there is no query for id because it binds to uuid and will return 400 if the id is not a valid uuid
@@ -195,12 +169,13 @@ func GetProduct(c *gin.Context) {
 		return
 	}
 	// return everything if no query is provided
-	var response []models.HomepageProductResponse
 
-	// get categories which should be included in the home page
+	// get categories which should be included in the home page, the order and the count are set by the staff
 	var categories []uint
 	rows, err := database.MysqlInstance.
-		Query("SELECT id FROM categories WHERE homepage_visibility = 1 AND deleted_at IS NULL LIMIT 5")
+		Query(
+			"SELECT id FROM categories WHERE homepage_visibility = 1 AND deleted_at IS NULL ORDER BY homepage_position, id",
+		)
 	if err != nil {
 		c.Status(500)
 		return
@@ -215,12 +190,13 @@ func GetProduct(c *gin.Context) {
 		categories = append(categories, category)
 	}
 
+	// each chunk is written to the index of its category to keep the order
+	response := make([]models.HomepageProductResponse, len(categories))
 	var wg sync.WaitGroup
 	errChan := make(chan error)
-	mu := sync.Mutex{}
-	for _, category := range categories {
+	for i, category := range categories {
 		wg.Add(1)
-		go func(category uint) {
+		go func(i int, category uint) {
 			defer wg.Done()
 			var chunk models.HomepageProductResponse
 			//	get category detail
@@ -232,27 +208,35 @@ func GetProduct(c *gin.Context) {
 				errChan <- err
 				return
 			}
-			//  get products in the category
+			//  get products in the category and its descendants
+			tree, err := CategoryDescendants(category)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			// the category may be deleted in the meantime
+			if len(tree) == 0 {
+				tree = []uint{category}
+			}
+			args := []interface{}{}
+			for _, id := range tree {
+				args = append(args, id)
+			}
 			rows, err := database.MysqlInstance.
 				Query(
 					`
 					SELECT
 					    BIN_TO_UUID(p.id) AS id,
 					    p.name,
-					    p.price,
+					    p.slug,
+					    COALESCE((SELECT MIN(COALESCE(s.price, p.price)) FROM product_skus s
+					              WHERE s.product_refer = p.id AND s.deleted_at IS NULL), p.price) AS price,
 					    COALESCE(CONCAT(BIN_TO_UUID(pi.id), '.webp'), '') AS image,
 					    p.cumulative_review,
 					    COUNT(oi.id) AS sold_count
 					FROM
 					    products p
-					        LEFT JOIN (
-					        SELECT
-					            id,
-					            product_refer,
-					            ROW_NUMBER() OVER (PARTITION BY product_refer ORDER BY created_at) AS rn
-					        FROM
-					            product_images
-					    ) pi ON p.id = pi.product_refer AND pi.rn = 1
+					        LEFT JOIN product_images pi ON p.id = pi.product_refer AND pi.is_primary = 1
 					        LEFT JOIN
 					    order_items oi ON oi.product_refer = p.id
 					        LEFT JOIN
@@ -260,12 +244,12 @@ func GetProduct(c *gin.Context) {
 					        AND (o.transaction_status = 'settlement'
 					            OR o.transaction_status = 'capture')
 					WHERE
-					    p.deleted_at IS NULL
-					  AND p.category_refer = ?
+					    p.deleted_at IS NULL AND p.status = 'published'
+					  AND p.category_refer IN (?`+strings.Repeat(", ?", len(tree)-1)+`)
 					GROUP BY
 					    p.id,
 					    image
-					LIMIT 12;`, category,
+					LIMIT 12;`, args...,
 				)
 			if err != nil {
 				errChan <- err
@@ -275,17 +259,16 @@ func GetProduct(c *gin.Context) {
 			for rows.Next() {
 				var product models.HomepageProduct
 				if err := rows.Scan(
-					&product.ID, &product.Name, &product.Price, &product.ImageUrl, &product.Rating, &product.Sold,
+					&product.ID, &product.Name, &product.Slug, &product.Price, &product.ImageUrl, &product.Rating,
+					&product.Sold,
 				); err != nil {
 					errChan <- err
 					return
 				}
 				chunk.Products = append(chunk.Products, product)
 			}
-			mu.Lock()
-			response = append(response, chunk)
-			mu.Unlock()
-		}(category)
+			response[i] = chunk
+		}(i, category)
 	}
 	go func() {
 		wg.Wait()
@@ -299,3 +282,85 @@ func GetProduct(c *gin.Context) {
 	}
 	c.JSON(200, response)
 }
+
+// ProductDetail return the product with its images, attributes and variants, the draft and archived products are
+// only found when publishedOnly is false and then the status and the schedule are included
+func ProductDetail(id string, publishedOnly bool) (models.ProductDetail, error) {
+	var response models.ProductDetail
+	var categoryId uint
+	var brandId sql.NullInt64
+	var brandName, brandSlug sql.NullString
+	var status string
+	var publishAt, unpublishAt sql.NullTime
+
+	query := `
+		SELECT BIN_TO_UUID(p.id), p.name, p.slug, p.description, p.price, p.weight, c.id, c.name, b.id, b.name, b.slug, p.cumulative_review, CONCAT(p.length, ' x ', p.width, ' x ', p.height),
+		       (SELECT COALESCE(SUM(i.quantity), 0) FROM inventories i, product_skus s WHERE i.sku_refer = s.id AND s.product_refer = p.id AND s.deleted_at IS NULL),
+		       p.status, p.publish_at, p.unpublish_at
+		FROM products p
+		         INNER JOIN categories c ON p.category_refer = c.id
+		         LEFT JOIN brands b ON p.brand_refer = b.id AND b.deleted_at IS NULL
+		WHERE p.deleted_at IS NULL AND p.id = UUID_TO_BIN(?)`
+	if publishedOnly {
+		query += " AND p.status = 'published'"
+	}
+	err := database.MysqlInstance.QueryRow(query, id).
+		Scan(
+			&response.ID, &response.Name, &response.Slug, &response.Description, &response.Price, &response.Weight, &categoryId,
+			&response.CategoryName, &brandId, &brandName, &brandSlug, &response.CumulativeReview,
+			&response.Dimension, &response.Stock, &status, &publishAt, &unpublishAt,
+		)
+	if err != nil {
+		return response, err
+	}
+	response.CanonicalUrl = ProductUrl(response.Slug)
+	if !publishedOnly {
+		response.Status = status
+		if publishAt.Valid {
+			response.PublishAt = &publishAt.Time
+		}
+		if unpublishAt.Valid {
+			response.UnpublishAt = &unpublishAt.Time
+		}
+	}
+	// the primary image always comes first, the rest follow the order set by the staff
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT CONCAT(BIN_TO_UUID(id), '.webp'), alt_text, is_primary, COALESCE(BIN_TO_UUID(sku_refer), '')
+		FROM product_images WHERE product_refer = UUID_TO_BIN(?) ORDER BY is_primary DESC, position, id`, id,
+	)
+	if err != nil {
+		return response, err
+	}
+	defer rows.Close()
+	response.Images = []models.ProductImageResponse{}
+	for rows.Next() {
+		var image models.ProductImageResponse
+		if err := rows.Scan(&image.File, &image.AltText, &image.Primary, &image.SkuID); err != nil {
+			return response, err
+		}
+		if storage.Thumbnails() {
+			image.Thumbnail = storage.ThumbnailName(image.File)
+		}
+		response.ImageUrls = append(response.ImageUrls, image.File)
+		response.Images = append(response.Images, image)
+	}
+	if err := rows.Err(); err != nil {
+		return response, err
+	}
+	if brandId.Valid {
+		response.Brand = &models.BrandResponseCompact{
+			ID: uint(brandId.Int64), Name: brandName.String, Slug: brandSlug.String,
+		}
+	}
+	response.Breadcrumbs, err = CategoryPath(categoryId)
+	if err != nil {
+		return response, err
+	}
+	response.Attributes, err = ProductAttributes(id)
+	if err != nil {
+		return response, err
+	}
+	response.Options, response.Skus, err = ProductVariants(id)
+	return response, err
+}
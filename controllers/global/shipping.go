@@ -106,8 +106,11 @@ func GetRatesProduct(c *gin.Context) {
 		return
 	}
 	// check from redis cache first if there is a match
-	// redisKey := request.productid + "_" + request.areaID
+	// redisKey := request.productid + "_" + request.areaID or request.productid + "_" + request.skuid + "_" + request.areaID
 	redisKey := fmt.Sprintf("%s_%d", request.ProductID, request.AreaID)
+	if request.SkuID != "" {
+		redisKey = fmt.Sprintf("%s_%s_%d", request.ProductID, request.SkuID, request.AreaID)
+	}
 	val, err := database.RedisInstance[5].Get(context.Background(), redisKey).Result()
 	if err == nil {
 		var res freight.WholeResult
@@ -128,12 +131,24 @@ func GetRatesProduct(c *gin.Context) {
 	product := freight.CalculateFreightRequest{
 		ID: request.AreaID,
 	}
-	err = database.MysqlInstance.
-		QueryRow(
-			"SELECT weight, length, width, height FROM products WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL",
-			request.ProductID,
-		).
-		Scan(&product.Weight, &product.Length, &product.Width, &product.Height)
+	if request.SkuID != "" {
+		err = database.MysqlInstance.
+			QueryRow(
+				`SELECT COALESCE(s.weight, p.weight), COALESCE(s.length, p.length), COALESCE(s.width, p.width),
+				COALESCE(s.height, p.height) FROM products p, product_skus s
+				WHERE p.id = UUID_TO_BIN(?) AND s.id = UUID_TO_BIN(?) AND s.product_refer = p.id
+				AND p.deleted_at IS NULL AND p.status = 'published' AND s.deleted_at IS NULL`,
+				request.ProductID, request.SkuID,
+			).
+			Scan(&product.Weight, &product.Length, &product.Width, &product.Height)
+	} else {
+		err = database.MysqlInstance.
+			QueryRow(
+				"SELECT weight, length, width, height FROM products WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL AND status = 'published'",
+				request.ProductID,
+			).
+			Scan(&product.Weight, &product.Length, &product.Width, &product.Height)
+	}
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.Status(404)
@@ -0,0 +1,108 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package global
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/database"
+)
+
+// StaticPages are the storefront paths which are listed on the sitemap along with the products, categories and brands
+var StaticPages = []string{"/"}
+
+type sitemapUrlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Urls    []sitemapUrl `xml:"url"`
+}
+
+type sitemapUrl struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// buildSitemap list the static pages, the published products and the categories and brands, a sitemap is limited to
+// 50.000 urls which is well above the size of the catalog
+func buildSitemap(items []feedItem) ([]byte, error) {
+	sitemap := sitemapUrlSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, page := range StaticPages {
+		sitemap.Urls = append(sitemap.Urls, sitemapUrl{Loc: StorefrontUrl + page})
+	}
+	// the variants of a product share its page, the page is modified whenever any of them is
+	productIndex := map[string]int{}
+	var products []sitemapUrl
+	var lastMods []time.Time
+	for _, item := range items {
+		if i, ok := productIndex[item.productId]; ok {
+			if item.updatedAt.After(lastMods[i]) {
+				lastMods[i] = item.updatedAt
+			}
+			continue
+		}
+		productIndex[item.productId] = len(products)
+		products = append(products, sitemapUrl{Loc: ProductUrl(item.slug)})
+		lastMods = append(lastMods, item.updatedAt)
+	}
+	for i := range products {
+		products[i].LastMod = lastMods[i].Format("2006-01-02")
+	}
+	sitemap.Urls = append(sitemap.Urls, products...)
+
+	for _, listing := range []struct {
+		query string
+		url   func(string) string
+	}{
+		{"SELECT slug, COALESCE(updated_at, created_at) FROM categories WHERE deleted_at IS NULL ORDER BY id", CategoryUrl},
+		{"SELECT slug, COALESCE(updated_at, created_at) FROM brands WHERE deleted_at IS NULL ORDER BY id", BrandUrl},
+	} {
+		urls, err := sitemapUrls(listing.query, listing.url)
+		if err != nil {
+			return nil, err
+		}
+		sitemap.Urls = append(sitemap.Urls, urls...)
+	}
+	content, err := xml.MarshalIndent(sitemap, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), content...), nil
+}
+
+// sitemapUrls return the url of every slug given by the query along with its last modification
+func sitemapUrls(query string, url func(string) string) ([]sitemapUrl, error) {
+	rows, err := database.MysqlInstance.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var urls []sitemapUrl
+	for rows.Next() {
+		var slug string
+		var lastMod time.Time
+		if err := rows.Scan(&slug, &lastMod); err != nil {
+			return nil, err
+		}
+		urls = append(urls, sitemapUrl{Loc: url(slug), LastMod: lastMod.Format("2006-01-02")})
+	}
+	return urls, rows.Err()
+}
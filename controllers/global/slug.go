@@ -0,0 +1,72 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package global
+
+import (
+	"github.com/Tus1688/openmerce-backend/database"
+)
+
+// StorefrontUrl is the base url of the storefront e.g. https://openmerce.com, the canonical urls are relative when it
+// is empty
+var StorefrontUrl string
+
+func ProductUrl(slug string) string {
+	return StorefrontUrl + "/product/" + slug
+}
+
+func CategoryUrl(slug string) string {
+	return StorefrontUrl + "/category/" + slug
+}
+
+func BrandUrl(slug string) string {
+	return StorefrontUrl + "/brand/" + slug
+}
+
+// productBySlug return the id and the current slug of the published product which has or had the slug, the current
+// slug differs from the given one when the product was renamed
+func productBySlug(slug string) (string, string, error) {
+	var id, current string
+	err := database.MysqlInstance.QueryRow(
+		`
+		SELECT BIN_TO_UUID(p.id), p.slug FROM products p
+		WHERE p.slug = ? AND p.deleted_at IS NULL AND p.status = 'published'
+		UNION ALL
+		SELECT BIN_TO_UUID(p.id), p.slug FROM product_slug_history h INNER JOIN products p ON p.id = h.product_refer
+		WHERE h.slug = ? AND p.deleted_at IS NULL AND p.status = 'published'
+		LIMIT 1`, slug, slug,
+	).Scan(&id, &current)
+	return id, current, err
+}
+
+// categoryBySlug is productBySlug for the categories
+func categoryBySlug(slug string) (uint, string, error) {
+	var id uint
+	var current string
+	err := database.MysqlInstance.QueryRow(
+		`
+		SELECT id, slug FROM categories WHERE slug = ? AND deleted_at IS NULL
+		UNION ALL
+		SELECT c.id, c.slug FROM category_slug_history h INNER JOIN categories c ON c.id = h.category_refer
+		WHERE h.slug = ? AND c.deleted_at IS NULL
+		LIMIT 1`, slug, slug,
+	).Scan(&id, &current)
+	return id, current, err
+}
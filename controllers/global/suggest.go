@@ -0,0 +1,179 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package global
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/search"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	suggestProductLimit  = 5
+	suggestCategoryLimit = 3
+	suggestQueryLimit    = 5
+	// suggestCandidates is the number of the most relevant products which are ranked again by their sales
+	suggestCandidates = 20
+)
+
+// GetSuggestProduct suggest product names, categories and popular queries for the search box as the user types
+func GetSuggestProduct(c *gin.Context) {
+	var request models.APICommonQuerySearch
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	query := search.NormalizeQuery(request.Search)
+	if query == "" {
+		c.Status(400)
+		return
+	}
+	// check from redis cache first if there is a match
+	val, err := database.RedisInstance[11].Get(context.Background(), search.SuggestionCacheKey(query)).Result()
+	if err == nil {
+		var res models.ProductSuggestion
+		if err := json.Unmarshal([]byte(val), &res); err == nil {
+			c.Header("Cache-Control", "public, max-age=300")
+			c.JSON(200, res)
+			return
+		}
+	}
+
+	products, err := suggestProducts(query)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-suggest:"+err.Error())
+		c.Status(500)
+		return
+	}
+	res := models.ProductSuggestion{Products: products, Categories: []models.CategoryResponseCompact{}}
+	rows, err := database.MysqlInstance.
+		Query(
+			`
+			SELECT c.id, c.name, c.slug
+			FROM categories c
+			         LEFT JOIN products p ON p.category_refer = c.id AND p.deleted_at IS NULL AND p.status = 'published'
+			WHERE c.deleted_at IS NULL AND c.name LIKE ?
+			GROUP BY c.id, c.name, c.slug
+			ORDER BY COUNT(p.id) DESC
+			LIMIT ?`,
+			// the normalized query only has letters, digits and spaces so it can't escape the pattern
+			"%"+query+"%", suggestCategoryLimit,
+		)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "2-suggest:"+err.Error())
+		c.Status(500)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category models.CategoryResponseCompact
+		if err := rows.Scan(&category.ID, &category.Name, &category.Slug); err != nil {
+			c.Status(500)
+			return
+		}
+		res.Categories = append(res.Categories, category)
+	}
+	res.Queries, err = search.PopularQueries(query, suggestQueryLimit)
+	if err != nil {
+		// the popular queries are optional, the suggestion is still useful without them
+		log.Print(err)
+		res.Queries = []string{}
+	}
+
+	go func(key string, res models.ProductSuggestion) {
+		jsonString, err := json.Marshal(res)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		err = database.RedisInstance[11].Set(context.Background(), key, string(jsonString), search.SuggestionTTL).Err()
+		if err != nil {
+			log.Print(err)
+		}
+	}(search.SuggestionCacheKey(query), res)
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(200, res)
+}
+
+// suggestProducts take the most relevant products of the query and rank them by their sales
+func suggestProducts(query string) ([]models.ProductSuggestionItem, error) {
+	var filter string
+	var args []interface{}
+	if search.DefaultEngine != nil {
+		result, err := search.DefaultEngine.Search(search.Query{Text: query})
+		if err != nil {
+			return nil, err
+		}
+		if len(result.IDs) == 0 {
+			return []models.ProductSuggestionItem{}, nil
+		}
+		if len(result.IDs) > suggestCandidates {
+			result.IDs = result.IDs[:suggestCandidates]
+		}
+		filter = "p.id IN (UUID_TO_BIN(?)" + strings.Repeat(", UUID_TO_BIN(?)", len(result.IDs)-1) + ")"
+		for _, id := range result.IDs {
+			args = append(args, id)
+		}
+	} else {
+		var terms []string
+		for _, token := range search.Tokenize(query) {
+			terms = append(terms, "+"+token+"*")
+		}
+		filter = "MATCH(p.name) AGAINST(? IN BOOLEAN MODE)"
+		args = append(args, strings.Join(terms, " "))
+	}
+	rows, err := database.MysqlInstance.
+		Query(
+			`
+			SELECT BIN_TO_UUID(p.id),
+			       p.name,
+			       p.slug,
+			       COALESCE((SELECT CONCAT(BIN_TO_UUID(pi.id), '.webp') FROM product_images pi
+			                 WHERE pi.product_refer = p.id AND pi.is_primary = 1), '') AS image
+			FROM products p
+			WHERE p.deleted_at IS NULL AND p.status = 'published' AND `+filter+`
+			ORDER BY COALESCE((SELECT SUM(oi.quantity) FROM order_items oi INNER JOIN orders o ON oi.order_refer = o.id
+			                   WHERE oi.product_refer = p.id AND o.transaction_status IN ('settlement', 'capture')), 0) DESC,
+			         p.cumulative_review DESC
+			LIMIT ?`,
+			append(args, suggestProductLimit)...,
+		)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	products := []models.ProductSuggestionItem{}
+	for rows.Next() {
+		var product models.ProductSuggestionItem
+		if err := rows.Scan(&product.ID, &product.Name, &product.Slug, &product.Image); err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
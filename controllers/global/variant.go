@@ -0,0 +1,124 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package global
+
+import (
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/models"
+)
+
+// ProductVariants return the variant matrix of a product, options are ordered by their position and the values by
+// their creation. A product without variant returns no option and a single sku
+func ProductVariants(productId string) ([]models.ProductOption, []models.ProductSku, error) {
+	var skus []models.ProductSku
+	skuIndex := map[string]int{}
+	rows, err := database.MysqlInstance.
+		Query(
+			`
+			SELECT BIN_TO_UUID(s.id), COALESCE(s.sku, ''), COALESCE(s.gtin, ''), COALESCE(s.price, p.price), COALESCE(s.weight, p.weight),
+			       CONCAT(COALESCE(s.length, p.length), ' x ', COALESCE(s.width, p.width), ' x ', COALESCE(s.height, p.height)),
+			       COALESCE(i.quantity, 0)
+			FROM product_skus s
+			         INNER JOIN products p ON p.id = s.product_refer
+			         LEFT JOIN inventories i ON i.sku_refer = s.id
+			WHERE s.product_refer = UUID_TO_BIN(?) AND s.deleted_at IS NULL
+			ORDER BY s.created_at`, productId,
+		)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		sku := models.ProductSku{Options: map[string]string{}, ImageUrls: []string{}}
+		if err := rows.Scan(&sku.ID, &sku.Sku, &sku.Gtin, &sku.Price, &sku.Weight, &sku.Dimension, &sku.Stock); err != nil {
+			return nil, nil, err
+		}
+		skuIndex[sku.ID] = len(skus)
+		skus = append(skus, sku)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var options []models.ProductOption
+	optionIndex := map[string]int{}
+	seen := map[string]bool{}
+	valueRows, err := database.MysqlInstance.
+		Query(
+			`
+			SELECT BIN_TO_UUID(sv.sku_refer), o.name, v.value
+			FROM product_sku_values sv
+			         INNER JOIN product_option_values v ON v.id = sv.option_value_refer
+			         INNER JOIN product_options o ON o.id = v.option_refer
+			         INNER JOIN product_skus s ON s.id = sv.sku_refer
+			WHERE s.product_refer = UUID_TO_BIN(?) AND s.deleted_at IS NULL
+			ORDER BY o.position, o.id, v.id`, productId,
+		)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer valueRows.Close()
+	for valueRows.Next() {
+		var skuId, name, value string
+		if err := valueRows.Scan(&skuId, &name, &value); err != nil {
+			return nil, nil, err
+		}
+		if i, ok := skuIndex[skuId]; ok {
+			skus[i].Options[name] = value
+		}
+		i, ok := optionIndex[name]
+		if !ok {
+			i = len(options)
+			optionIndex[name] = i
+			options = append(options, models.ProductOption{Name: name})
+		}
+		if !seen[name+"\x00"+value] {
+			seen[name+"\x00"+value] = true
+			options[i].Values = append(options[i].Values, value)
+		}
+	}
+	if err := valueRows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	imageRows, err := database.MysqlInstance.
+		Query(
+			`SELECT BIN_TO_UUID(sku_refer), CONCAT(BIN_TO_UUID(id), '.webp') FROM product_images
+			WHERE product_refer = UUID_TO_BIN(?) AND sku_refer IS NOT NULL ORDER BY position, id`, productId,
+		)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer imageRows.Close()
+	for imageRows.Next() {
+		var skuId, image string
+		if err := imageRows.Scan(&skuId, &image); err != nil {
+			return nil, nil, err
+		}
+		if i, ok := skuIndex[skuId]; ok {
+			skus[i].ImageUrls = append(skus[i].ImageUrls, image)
+		}
+	}
+	if options == nil {
+		options = []models.ProductOption{}
+	}
+	return options, skus, imageRows.Err()
+}
@@ -0,0 +1,370 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package staff
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Tus1688/openmerce-backend/controllers/global"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// categoryAttributeAuditQuery is the state of a category attribute which is recorded on the audit trail
+const categoryAttributeAuditQuery = `SELECT category_refer, code, name, type, unit, options, required, filterable, position
+	FROM category_attributes WHERE id = ?`
+
+// attributeCode is a lowercase identifier as it is used as the query key of the listing filter
+var attributeCode = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// errAttribute is the error of an invalid attribute value on the product request, its message is shown to the staff
+type errAttribute struct {
+	message string
+}
+
+func (e errAttribute) Error() string {
+	return e.message
+}
+
+// attributeValue is a parsed value of the product request, both text and number are nil when the value is removed
+type attributeValue struct {
+	attributeId uint
+	text        interface{}
+	number      interface{}
+}
+
+func GetCategoryAttributes(c *gin.Context) {
+	var request models.CategoryAttributeQuery
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	attributes, err := global.CategoryAttributes(request.CategoryID, false)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-getattr:"+err.Error())
+		c.Status(500)
+		return
+	}
+	c.JSON(200, attributes)
+}
+
+func AddCategoryAttribute(c *gin.Context) {
+	var request models.CategoryAttributeCreate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	if !attributeCode.MatchString(request.Code) {
+		c.JSON(400, gin.H{"error": "code must be lowercase letters, digits or underscore"})
+		return
+	}
+	var options interface{}
+	if request.Type == "enum" {
+		if len(request.Options) == 0 {
+			c.JSON(400, gin.H{"error": "an enum attribute needs at least one option"})
+			return
+		}
+		b, err := json.Marshal(request.Options)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+		options = string(b)
+	}
+	filterable := request.Filterable == nil || *request.Filterable
+	res, err := database.MysqlInstance.Exec(
+		`INSERT INTO category_attributes (category_refer, code, name, type, unit, options, required, filterable, position)
+		SELECT id, ?, ?, ?, ?, ?, ?, ?, ? FROM categories WHERE id = ? AND deleted_at IS NULL`,
+		request.Code, request.Name, request.Type, request.Unit, options, request.Required, filterable, request.Position,
+		request.CategoryID,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			c.JSON(409, gin.H{"error": "attribute code already exists in the category"})
+			return
+		}
+		go logging.InsertLog(logging.ERROR, "1-addattr:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "category not found"})
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionCreate, logging.EntityCategoryAttribute, strconv.FormatInt(id, 10), nil,
+		logging.Snapshot(categoryAttributeAuditQuery, id),
+	)
+	c.JSON(201, gin.H{"id": id})
+}
+
+func UpdateCategoryAttribute(c *gin.Context) {
+	var request models.CategoryAttributeUpdate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	var attributeType string
+	err := database.MysqlInstance.QueryRow("SELECT type FROM category_attributes WHERE id = ?", request.ID).
+		Scan(&attributeType)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Status(404)
+			return
+		}
+		c.Status(500)
+		return
+	}
+	query := "UPDATE category_attributes SET updated_at = CURRENT_TIMESTAMP"
+	var args []interface{}
+	if request.Name != "" {
+		query += ", name = ?"
+		args = append(args, request.Name)
+	}
+	if request.Unit != nil {
+		query += ", unit = ?"
+		args = append(args, *request.Unit)
+	}
+	if request.Options != nil {
+		if attributeType != "enum" {
+			c.JSON(400, gin.H{"error": "only an enum attribute has options"})
+			return
+		}
+		if len(request.Options) == 0 {
+			c.JSON(400, gin.H{"error": "an enum attribute needs at least one option"})
+			return
+		}
+		// the options which are still used by a product can't be removed
+		var used int
+		err := database.MysqlInstance.
+			QueryRow(
+				"SELECT COUNT(*) FROM product_attribute_values WHERE attribute_refer = ? AND value_text NOT IN (?"+
+					strings.Repeat(", ?", len(request.Options)-1)+")",
+				append([]interface{}{request.ID}, stringsToArgs(request.Options)...)...,
+			).
+			Scan(&used)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+		if used > 0 {
+			c.JSON(409, gin.H{"error": "a removed option is still used by a product"})
+			return
+		}
+		b, err := json.Marshal(request.Options)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+		query += ", options = ?"
+		args = append(args, string(b))
+	}
+	if request.Required != nil {
+		query += ", required = ?"
+		args = append(args, *request.Required)
+	}
+	if request.Filterable != nil {
+		query += ", filterable = ?"
+		args = append(args, *request.Filterable)
+	}
+	if request.Position != nil {
+		query += ", position = ?"
+		args = append(args, *request.Position)
+	}
+	if len(args) == 0 {
+		c.Status(400)
+		return
+	}
+	query += " WHERE id = ?"
+	args = append(args, request.ID)
+	before := logging.Snapshot(categoryAttributeAuditQuery, request.ID)
+	if _, err := database.MysqlInstance.Exec(query, args...); err != nil {
+		go logging.InsertLog(logging.ERROR, "1-updattr:"+err.Error())
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityCategoryAttribute, strconv.FormatUint(uint64(request.ID), 10), before,
+		logging.Snapshot(categoryAttributeAuditQuery, request.ID),
+	)
+	c.Status(200)
+}
+
+// DeleteCategoryAttribute remove the attribute along with its values on the products
+func DeleteCategoryAttribute(c *gin.Context) {
+	var request models.APICommonQueryID
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	before := logging.Snapshot(categoryAttributeAuditQuery, request.ID)
+	res, err := database.MysqlInstance.Exec("DELETE FROM category_attributes WHERE id = ?", request.ID)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-delattr:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		c.Status(404)
+		return
+	}
+	logging.Audit(c, logging.ActionDelete, logging.EntityCategoryAttribute, strconv.Itoa(request.ID), before, nil)
+	c.Status(200)
+}
+
+// parseAttributes check the attribute values of the product request against the schema of the category. Every
+// required attribute must be given when requireAll is set, which is the case on create and on category change
+func parseAttributes(categoryId uint, values map[string]interface{}, requireAll bool) ([]attributeValue, error) {
+	schema, err := global.CategoryAttributes(categoryId, false)
+	if err != nil {
+		return nil, err
+	}
+	byCode := map[string]models.CategoryAttribute{}
+	for _, attribute := range schema {
+		byCode[attribute.Code] = attribute
+	}
+	var parsed []attributeValue
+	for code, value := range values {
+		attribute, ok := byCode[code]
+		if !ok {
+			return nil, errAttribute{fmt.Sprintf("unknown attribute %s for the category", code)}
+		}
+		if value == nil {
+			if attribute.Required {
+				return nil, errAttribute{fmt.Sprintf("attribute %s is required", code)}
+			}
+			parsed = append(parsed, attributeValue{attributeId: attribute.ID})
+			continue
+		}
+		result := attributeValue{attributeId: attribute.ID}
+		switch attribute.Type {
+		case "number":
+			number, ok := value.(float64)
+			if !ok {
+				return nil, errAttribute{fmt.Sprintf("attribute %s must be a number", code)}
+			}
+			result.number = number
+		case "boolean":
+			boolean, ok := value.(bool)
+			if !ok {
+				return nil, errAttribute{fmt.Sprintf("attribute %s must be a boolean", code)}
+			}
+			result.text = strconv.FormatBool(boolean)
+		case "enum":
+			text, ok := value.(string)
+			if !ok || !contains(attribute.Options, text) {
+				return nil, errAttribute{
+					fmt.Sprintf("attribute %s must be one of %s", code, strings.Join(attribute.Options, ", ")),
+				}
+			}
+			result.text = text
+		default:
+			text, ok := value.(string)
+			if !ok || text == "" || len(text) > 255 {
+				return nil, errAttribute{fmt.Sprintf("attribute %s must be a text up to 255 characters", code)}
+			}
+			result.text = text
+		}
+		parsed = append(parsed, result)
+	}
+	if requireAll {
+		for _, attribute := range schema {
+			if _, ok := values[attribute.Code]; attribute.Required && !ok {
+				return nil, errAttribute{fmt.Sprintf("attribute %s is required", attribute.Code)}
+			}
+		}
+	}
+	return parsed, nil
+}
+
+// saveAttributesTx write the parsed values of a product within the transaction of the caller and drop the values
+// which belong to another category
+func saveAttributesTx(tx *sql.Tx, productId string, values []attributeValue) error {
+	_, err := tx.Exec(
+		`DELETE v FROM product_attribute_values v
+		INNER JOIN category_attributes a ON a.id = v.attribute_refer
+		INNER JOIN products p ON p.id = v.product_refer
+		WHERE v.product_refer = UUID_TO_BIN(?) AND a.category_refer <> p.category_refer`, productId,
+	)
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		if value.text == nil && value.number == nil {
+			_, err = tx.Exec(
+				"DELETE FROM product_attribute_values WHERE product_refer = UUID_TO_BIN(?) AND attribute_refer = ?",
+				productId, value.attributeId,
+			)
+		} else {
+			_, err = tx.Exec(
+				`INSERT INTO product_attribute_values (product_refer, attribute_refer, value_text, value_number)
+				VALUES (UUID_TO_BIN(?), ?, ?, ?)
+				ON DUPLICATE KEY UPDATE value_text = VALUES(value_text), value_number = VALUES(value_number)`,
+				productId, value.attributeId, value.text, value.number,
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attributeError write the response of a parseAttributes error
+func attributeError(c *gin.Context, err error, code string) {
+	var invalid errAttribute
+	if errors.As(err, &invalid) {
+		c.JSON(400, gin.H{"error": invalid.Error()})
+		return
+	}
+	go logging.InsertLog(logging.ERROR, code+err.Error())
+	c.Status(500)
+}
+
+func contains(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+func stringsToArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
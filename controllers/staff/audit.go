@@ -0,0 +1,103 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package staff
+
+import (
+	"database/sql"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// GetAudit query the audit trail from the newest, filtered by staff, entity, action and date
+func GetAudit(c *gin.Context) {
+	var request models.AuditQuery
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	query := `
+		SELECT a.id, a.staff_refer, s.username, COALESCE(a.api_key_refer, 0), a.action, a.entity, a.entity_id, a.before_value, a.after_value,
+		       COALESCE(a.ip_address, ''), a.created_at
+		FROM audit_logs a, staffs s WHERE a.staff_refer = s.id`
+	var args []interface{}
+	if request.StaffID != 0 {
+		query += " AND a.staff_refer = ?"
+		args = append(args, request.StaffID)
+	}
+	if request.Entity != "" {
+		query += " AND a.entity = ?"
+		args = append(args, request.Entity)
+	}
+	if request.EntityID != "" {
+		query += " AND a.entity_id = ?"
+		args = append(args, request.EntityID)
+	}
+	if request.Action != "" {
+		query += " AND a.action = ?"
+		args = append(args, request.Action)
+	}
+	if !request.From.IsZero() {
+		query += " AND a.created_at >= ?"
+		args = append(args, request.From)
+	}
+	if !request.To.IsZero() {
+		// the to date is inclusive
+		query += " AND a.created_at < DATE_ADD(?, INTERVAL 1 DAY)"
+		args = append(args, request.To)
+	}
+	if request.Limit == 0 || request.Limit > 100 {
+		request.Limit = 100
+	}
+	query += " ORDER BY a.id DESC LIMIT ? OFFSET ?"
+	args = append(args, request.Limit, request.Offset)
+	rows, err := database.MysqlInstance.Query(query, args...)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer rows.Close()
+	var response []models.AuditResponse
+	for rows.Next() {
+		var item models.AuditResponse
+		var before, after sql.NullString
+		if err := rows.Scan(
+			&item.ID, &item.StaffID, &item.StaffUsername, &item.ApiKeyID, &item.Action, &item.Entity, &item.EntityID, &before,
+			&after, &item.IpAddress, &item.CreatedAt,
+		); err != nil {
+			c.Status(500)
+			return
+		}
+		if before.Valid {
+			item.Before = []byte(before.String)
+		}
+		if after.Valid {
+			item.After = []byte(after.String)
+		}
+		response = append(response, item)
+	}
+	if len(response) == 0 {
+		c.Status(404)
+		return
+	}
+	c.JSON(200, response)
+}
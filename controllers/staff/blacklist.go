@@ -0,0 +1,203 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package staff
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"strings"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// importBatchSize is the maximum number of domains inserted in a single query on bulk import
+const importBatchSize = 500
+
+func GetBlacklistDomain(c *gin.Context) {
+	var request models.APICommonQuerySearch
+	query := "SELECT domain_name FROM blacklist_domains"
+	var args []interface{}
+	if err := c.ShouldBindQuery(&request); err == nil {
+		query += " WHERE domain_name LIKE ?"
+		args = append(args, strings.ToLower(request.Search)+"%")
+	}
+	query += " ORDER BY domain_name LIMIT 100"
+	rows, err := database.MysqlInstance.Query(query, args...)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer rows.Close()
+	var response []string
+	for rows.Next() {
+		var domain string
+		if err := rows.Scan(&domain); err != nil {
+			c.Status(500)
+			return
+		}
+		response = append(response, domain)
+	}
+	if len(response) == 0 {
+		c.Status(404)
+		return
+	}
+	c.JSON(200, response)
+}
+
+func AddBlacklistDomain(c *gin.Context) {
+	var request models.BlacklistDomain
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	domain, ok := models.NormalizeDomain(request.Domain)
+	if !ok {
+		c.JSON(400, gin.H{"error": "Invalid domain name"})
+		return
+	}
+	res, err := database.MysqlInstance.Exec("INSERT IGNORE INTO blacklist_domains (domain_name) VALUES (?)", domain)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-addblacklist:"+err.Error())
+		c.Status(500)
+		return
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if affected == 0 {
+		c.JSON(409, gin.H{"error": "Domain already blacklisted"})
+		return
+	}
+	logging.Audit(c, logging.ActionCreate, logging.EntityBlacklistDomain, domain, nil, gin.H{"domain": domain})
+	go ClearBlacklistDomainCache()
+	c.Status(201)
+}
+
+func DeleteBlacklistDomain(c *gin.Context) {
+	var request models.BlacklistDomainQuery
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	domain, ok := models.NormalizeDomain(request.Domain)
+	if !ok {
+		c.Status(400)
+		return
+	}
+	res, err := database.MysqlInstance.Exec("DELETE FROM blacklist_domains WHERE domain_name = ?", domain)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-delblacklist:"+err.Error())
+		c.Status(500)
+		return
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if affected == 0 {
+		c.Status(404)
+		return
+	}
+	logging.Audit(c, logging.ActionDelete, logging.EntityBlacklistDomain, domain, gin.H{"domain": domain}, nil)
+	go ClearBlacklistDomainCache()
+	c.Status(200)
+}
+
+// ImportBlacklistDomain handle bulk import from a text file of disposable email providers (one domain per line),
+// empty lines and lines starting with "#" are ignored
+func ImportBlacklistDomain(c *gin.Context) {
+	var request models.BlacklistDomainImport
+	if err := c.ShouldBind(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	file, err := request.File.Open()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer file.Close()
+	var domains []interface{}
+	var invalid []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domain, ok := models.NormalizeDomain(line)
+		if !ok {
+			invalid = append(invalid, line)
+			continue
+		}
+		domains = append(domains, domain)
+	}
+	if err := scanner.Err(); err != nil {
+		c.Status(400)
+		return
+	}
+	if len(domains) == 0 {
+		c.JSON(400, gin.H{"error": "No valid domain found", "invalid": invalid})
+		return
+	}
+	var inserted int64
+	for start := 0; start < len(domains); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(domains) {
+			end = len(domains)
+		}
+		batch := domains[start:end]
+		query := "INSERT IGNORE INTO blacklist_domains (domain_name) VALUES (?)" + strings.Repeat(", (?)", len(batch)-1)
+		res, err := database.MysqlInstance.Exec(query, batch...)
+		if err != nil {
+			go logging.InsertLog(logging.ERROR, "1-importblacklist:"+err.Error())
+			c.Status(500)
+			return
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			c.Status(500)
+			return
+		}
+		inserted += affected
+	}
+	logging.Audit(
+		c, logging.ActionCreate, logging.EntityBlacklistDomain, "import", nil,
+		gin.H{"file": request.File.Filename, "inserted": inserted, "invalid": len(invalid)},
+	)
+	go ClearBlacklistDomainCache()
+	c.JSON(201, gin.H{"inserted": inserted, "invalid": invalid})
+}
+
+// ClearBlacklistDomainCache is used to clear the whole redisInstance[7] as a parent domain change affects every
+// cached subdomain
+func ClearBlacklistDomainCache() {
+	if err := database.RedisInstance[7].FlushDB(context.Background()).Err(); err != nil {
+		log.Print(err)
+	}
+}
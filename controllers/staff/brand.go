@@ -0,0 +1,322 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package staff
+
+import (
+	"database/sql"
+	"errors"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/search"
+	"github.com/gin-gonic/gin"
+)
+
+// brandAuditQuery is the state of a brand which is recorded on the audit trail
+const brandAuditQuery = "SELECT name, slug, description, logo, deleted_at FROM brands WHERE id = ?"
+
+var errBrandNotFound = errors.New("brand not found")
+
+func GetBrands(c *gin.Context) {
+	brands := []models.Brand{}
+	rows, err := database.MysqlInstance.
+		Query("SELECT id, name, slug, description, COALESCE(logo, '') FROM brands WHERE deleted_at IS NULL ORDER BY name")
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var brand models.Brand
+		if err := rows.Scan(&brand.ID, &brand.Name, &brand.Slug, &brand.Description, &brand.Logo); err != nil {
+			c.Status(500)
+			return
+		}
+		brands = append(brands, brand)
+	}
+	c.JSON(200, brands)
+}
+
+func AddBrand(c *gin.Context) {
+	var request models.BrandCreate
+	if err := c.ShouldBind(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	slug := slugify(request.Slug)
+	if slug == "" {
+		slug = slugify(request.Name)
+	}
+	if slug == "" {
+		c.JSON(400, gin.H{"error": "slug must contain a letter or digit"})
+		return
+	}
+	var logo interface{}
+	if request.Logo != nil {
+		if !validLogo(c, request.Logo) {
+			return
+		}
+		file, err := uploadImage(request.Logo)
+		if err != nil {
+			go logging.InsertLog(logging.ERROR, "1-addbrand:"+err.Error())
+			c.Status(500)
+			return
+		}
+		logo = file
+	}
+	// a deleted brand with the same name is brought back instead
+	var existingBrandId uint
+	var oldLogo sql.NullString
+	err := database.MysqlInstance.
+		QueryRow("SELECT id, logo FROM brands WHERE name = ? AND deleted_at IS NOT NULL", request.Name).
+		Scan(&existingBrandId, &oldLogo)
+	if err != nil && err != sql.ErrNoRows {
+		c.Status(500)
+		return
+	}
+	var id int64
+	if existingBrandId != 0 {
+		_, err = database.MysqlInstance.Exec(
+			"UPDATE brands SET deleted_at = NULL, updated_at = NULL, slug = ?, description = ?, logo = ? WHERE id = ?",
+			slug, request.Description, logo, existingBrandId,
+		)
+		id = int64(existingBrandId)
+	} else {
+		var res sql.Result
+		res, err = database.MysqlInstance.Exec(
+			"INSERT INTO brands (name, slug, description, logo) VALUES (?, ?, ?, ?)",
+			request.Name, slug, request.Description, logo,
+		)
+		if err == nil {
+			id, err = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		if logo != nil {
+			go deleteImage(logo.(string))
+		}
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			c.JSON(409, gin.H{"error": "brand name or slug already exists"})
+			return
+		}
+		go logging.InsertLog(logging.ERROR, "2-addbrand:"+err.Error())
+		c.Status(500)
+		return
+	}
+	// the logo of the brought back brand is replaced
+	if oldLogo.Valid {
+		go func(fileName string) {
+			if err := deleteImage(fileName); err != nil {
+				logging.InsertLog(logging.ERROR, "3-addbrand:"+err.Error())
+			}
+		}(oldLogo.String)
+	}
+	logging.Audit(
+		c, logging.ActionCreate, logging.EntityBrand, strconv.FormatInt(id, 10), nil,
+		logging.Snapshot(brandAuditQuery, id),
+	)
+	c.JSON(201, gin.H{"id": id, "slug": slug})
+}
+
+func UpdateBrand(c *gin.Context) {
+	var request models.BrandUpdate
+	if err := c.ShouldBind(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	var oldLogo sql.NullString
+	err := database.MysqlInstance.
+		QueryRow("SELECT logo FROM brands WHERE id = ? AND deleted_at IS NULL", request.ID).
+		Scan(&oldLogo)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Status(404)
+			return
+		}
+		c.Status(500)
+		return
+	}
+	query := "UPDATE brands SET updated_at = CURRENT_TIMESTAMP"
+	var args []interface{}
+	if request.Name != "" {
+		query += ", name = ?"
+		args = append(args, request.Name)
+	}
+	if request.Slug != "" {
+		slug := slugify(request.Slug)
+		if slug == "" {
+			c.JSON(400, gin.H{"error": "slug must contain a letter or digit"})
+			return
+		}
+		query += ", slug = ?"
+		args = append(args, slug)
+	}
+	if request.Description != nil {
+		query += ", description = ?"
+		args = append(args, *request.Description)
+	}
+	var logo string
+	if request.Logo != nil {
+		if !validLogo(c, request.Logo) {
+			return
+		}
+		logo, err = uploadImage(request.Logo)
+		if err != nil {
+			go logging.InsertLog(logging.ERROR, "1-updbrand:"+err.Error())
+			c.Status(500)
+			return
+		}
+		query += ", logo = ?"
+		args = append(args, logo)
+	}
+	if len(args) == 0 {
+		c.Status(400)
+		return
+	}
+	query += " WHERE id = ? AND deleted_at IS NULL"
+	args = append(args, request.ID)
+	before := logging.Snapshot(brandAuditQuery, request.ID)
+	if _, err := database.MysqlInstance.Exec(query, args...); err != nil {
+		if logo != "" {
+			go deleteImage(logo)
+		}
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			c.JSON(409, gin.H{"error": "brand name or slug already exists"})
+			return
+		}
+		go logging.InsertLog(logging.ERROR, "2-updbrand:"+err.Error())
+		c.Status(500)
+		return
+	}
+	// the replaced logo is no longer referenced
+	if logo != "" && oldLogo.Valid {
+		go func(fileName string) {
+			if err := deleteImage(fileName); err != nil {
+				logging.InsertLog(logging.ERROR, "3-updbrand:"+err.Error())
+			}
+		}(oldLogo.String)
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityBrand, strconv.FormatUint(uint64(request.ID), 10), before,
+		logging.Snapshot(brandAuditQuery, request.ID),
+	)
+	if request.Name != "" {
+		go search.SyncBrand(request.ID)
+	}
+	c.Status(200)
+}
+
+// validLogo write the response and return false when the logo isn't an acceptable image
+func validLogo(c *gin.Context, logo *multipart.FileHeader) bool {
+	err := validateImage(logo)
+	if err == nil {
+		return true
+	}
+	var invalid errInvalidImage
+	if errors.As(err, &invalid) {
+		c.JSON(400, gin.H{"error": "logo: " + invalid.Error()})
+		return false
+	}
+	go logging.InsertLog(logging.ERROR, "vallogo:"+err.Error())
+	c.Status(500)
+	return false
+}
+
+func DeleteBrand(c *gin.Context) {
+	var request models.APICommonQueryID
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	var exist int8
+	err := database.MysqlInstance.QueryRow(
+		"SELECT 1 FROM products WHERE brand_refer = ? AND deleted_at IS NULL LIMIT 1", request.ID,
+	).Scan(&exist)
+	if err != nil && err != sql.ErrNoRows {
+		c.Status(500)
+		return
+	}
+	if exist == 1 {
+		c.JSON(409, gin.H{"error": "brand is in use"})
+		return
+	}
+	before := logging.Snapshot(brandAuditQuery, request.ID)
+	res, err := database.MysqlInstance.Exec(
+		"UPDATE brands SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL", request.ID,
+	)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		c.Status(404)
+		return
+	}
+	logging.Audit(c, logging.ActionDelete, logging.EntityBrand, strconv.Itoa(request.ID), before, nil)
+	c.Status(200)
+}
+
+// checkBrand return errBrandNotFound when the brand doesn't exist
+func checkBrand(brandId uint) error {
+	var exist int8
+	err := database.MysqlInstance.
+		QueryRow("SELECT 1 FROM brands WHERE id = ? AND deleted_at IS NULL", brandId).
+		Scan(&exist)
+	if err == sql.ErrNoRows {
+		return errBrandNotFound
+	}
+	return err
+}
+
+func brandError(c *gin.Context, err error) {
+	if err == errBrandNotFound {
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(500)
+}
+
+// slugify lowercase the text and join its words with a dash e.g. "Dr. Martens" becomes "dr-martens"
+func slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	slug := b.String()
+	if len(slug) > 60 {
+		slug = strings.TrimRight(slug[:60], "-")
+	}
+	return slug
+}
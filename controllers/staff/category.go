@@ -22,16 +22,30 @@ package staff
 
 import (
 	"database/sql"
+	"errors"
+	"strconv"
 	"strings"
 
+	"github.com/Tus1688/openmerce-backend/controllers/global"
 	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
 	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/search"
 	"github.com/gin-gonic/gin"
 )
 
+var errParentNotFound = errors.New("parent category not found")
+
+// categoryAuditQuery is the state of a category which is recorded on the audit trail
+const categoryAuditQuery = `SELECT parent_refer, name, slug, description, position, homepage_visibility, homepage_position,
+	deleted_at FROM categories WHERE id = ?`
+
 func GetCategories(c *gin.Context) {
 	var categories []models.CategoryResponse
-	rows, err := database.MysqlInstance.Query("SELECT id, name, description, homepage_visibility FROM categories WHERE deleted_at IS NULL")
+	rows, err := database.MysqlInstance.Query(
+		`SELECT id, parent_refer, name, slug, description, position, homepage_visibility, homepage_position
+		FROM categories WHERE deleted_at IS NULL ORDER BY position, id`,
+	)
 	if err != nil {
 		c.Status(500)
 		return
@@ -40,7 +54,8 @@ func GetCategories(c *gin.Context) {
 	for rows.Next() {
 		var category models.CategoryResponse
 		if err := rows.Scan(
-			&category.ID, &category.Name, &category.Description, &category.HomePageVisibility,
+			&category.ID, &category.ParentID, &category.Name, &category.Slug, &category.Description, &category.Position,
+			&category.HomePageVisibility, &category.HomePagePosition,
 		); err != nil {
 			c.Status(500)
 			return
@@ -56,8 +71,20 @@ func AddNewCategory(c *gin.Context) {
 		c.Status(400)
 		return
 	}
+	if request.ParentID != nil {
+		if err := checkParentCategory(*request.ParentID); err != nil {
+			categoryError(c, err)
+			return
+		}
+	}
+	// the new category is put last among its siblings
+	position, err := nextCategoryPosition(request.ParentID)
+	if err != nil {
+		c.Status(500)
+		return
+	}
 	var existingCategoryId uint
-	err := database.MysqlInstance.
+	err = database.MysqlInstance.
 		QueryRow("SELECT id FROM categories WHERE name = ? AND deleted_at IS NOT NULL", request.Name).
 		Scan(&existingCategoryId)
 	if err != nil && err != sql.ErrNoRows {
@@ -68,20 +95,36 @@ func AddNewCategory(c *gin.Context) {
 	if existingCategoryId != 0 {
 		//	update the deleted_at to null
 		_, err := database.MysqlInstance.Exec(
-			"UPDATE categories SET deleted_at = NULL, updated_at = NULL, description = ?, homepage_visibility = ? WHERE id = ?",
-			request.Description, request.HomePageVisibility, existingCategoryId,
+			"UPDATE categories SET deleted_at = NULL, updated_at = NULL, parent_refer = ?, description = ?, position = ?, homepage_visibility = ? WHERE id = ?",
+			request.ParentID, request.Description, position, request.HomePageVisibility, existingCategoryId,
 		)
 		if err != nil {
 			c.Status(500)
 			return
 		}
+		logging.Audit(
+			c, logging.ActionCreate, logging.EntityCategory, strconv.FormatUint(uint64(existingCategoryId), 10), nil,
+			logging.Snapshot(categoryAuditQuery, existingCategoryId),
+		)
 		c.JSON(201, gin.H{"id": existingCategoryId})
 		return
 	}
 	// if there is no existing category with the same name, create a new one
-	res, err := database.MysqlInstance.Exec(
-		"INSERT INTO categories (name, description, homepage_visibility) VALUES (?, ?, ?)",
-		request.Name, request.Description, request.HomePageVisibility,
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	slug, err := categorySlugs.unique(tx, request.Name, 0)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-addcat:"+err.Error())
+		c.Status(500)
+		return
+	}
+	res, err := tx.Exec(
+		"INSERT INTO categories (parent_refer, name, slug, description, position, homepage_visibility) VALUES (?, ?, ?, ?, ?, ?)",
+		request.ParentID, request.Name, slug, request.Description, position, request.HomePageVisibility,
 	)
 	if err != nil {
 		if strings.Contains(err.Error(), "Duplicate entry") {
@@ -96,6 +139,15 @@ func AddNewCategory(c *gin.Context) {
 		c.Status(500)
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		go logging.InsertLog(logging.ERROR, "2-addcat:"+err.Error())
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionCreate, logging.EntityCategory, strconv.FormatInt(id, 10), nil,
+		logging.Snapshot(categoryAuditQuery, id),
+	)
 	c.JSON(201, gin.H{"id": id})
 }
 
@@ -117,6 +169,18 @@ func DeleteCategory(c *gin.Context) {
 		c.JSON(409, gin.H{"error": "category is in use"})
 		return
 	}
+	err = database.MysqlInstance.QueryRow(
+		"SELECT 1 FROM categories WHERE parent_refer = ? AND deleted_at IS NULL LIMIT 1", request.ID,
+	).Scan(&exist)
+	if err != nil && err != sql.ErrNoRows {
+		c.Status(500)
+		return
+	}
+	if exist == 1 {
+		c.JSON(409, gin.H{"error": "category has subcategories"})
+		return
+	}
+	before := logging.Snapshot(categoryAuditQuery, request.ID)
 	res, err := database.MysqlInstance.Exec(
 		"UPDATE categories SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL", request.ID,
 	)
@@ -133,6 +197,7 @@ func DeleteCategory(c *gin.Context) {
 		c.Status(404)
 		return
 	}
+	logging.Audit(c, logging.ActionDelete, logging.EntityCategory, strconv.Itoa(request.ID), before, nil)
 	c.Status(200)
 }
 
@@ -158,7 +223,15 @@ func UpdateCategory(c *gin.Context) {
 	}
 	query += " WHERE id = ? AND deleted_at IS NULL"
 	args = append(args, request.ID)
-	res, err := database.MysqlInstance.Exec(query, args...)
+	before := logging.Snapshot(categoryAuditQuery, request.ID)
+	// the slug is renamed along with the name so the slug history always matches the slug
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(query, args...)
 	if err != nil {
 		if strings.Contains(err.Error(), "Duplicate entry") {
 			c.JSON(409, gin.H{"error": "Category name already exists"})
@@ -176,5 +249,293 @@ func UpdateCategory(c *gin.Context) {
 		c.Status(404)
 		return
 	}
+	if request.Name != "" {
+		if err := categorySlugs.rename(tx, request.ID, request.Name); err != nil {
+			go logging.InsertLog(logging.ERROR, "1-updcat:"+err.Error())
+			c.Status(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		go logging.InsertLog(logging.ERROR, "2-updcat:"+err.Error())
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityCategory, strconv.FormatUint(uint64(request.ID), 10), before,
+		logging.Snapshot(categoryAuditQuery, request.ID),
+	)
+	if request.Name != "" {
+		go search.SyncCategory(request.ID)
+	}
+	c.Status(200)
+}
+
+// MoveCategory put the category under another parent or at another position among its siblings
+func MoveCategory(c *gin.Context) {
+	var request models.CategoryMove
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	if request.ParentID != nil {
+		if err := checkParentCategory(*request.ParentID); err != nil {
+			categoryError(c, err)
+			return
+		}
+		// a category can't be moved below itself as it would detach the subtree from the root
+		tree, err := global.CategoryDescendants(request.ID)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+		for _, id := range tree {
+			if id == *request.ParentID {
+				c.JSON(409, gin.H{"error": "category can't be moved under itself"})
+				return
+			}
+		}
+	}
+	before := logging.Snapshot(categoryAuditQuery, request.ID)
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	var oldParent sql.NullInt64
+	var oldPosition uint
+	err = tx.QueryRow(
+		"SELECT parent_refer, position FROM categories WHERE id = ? AND deleted_at IS NULL FOR UPDATE", request.ID,
+	).Scan(&oldParent, &oldPosition)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Status(404)
+			return
+		}
+		c.Status(500)
+		return
+	}
+	// close the gap on the old siblings
+	_, err = tx.Exec(
+		`UPDATE categories SET position = position - 1
+		WHERE parent_refer <=> ? AND position > ? AND id <> ? AND deleted_at IS NULL`,
+		oldParent, oldPosition, request.ID,
+	)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-movecat:"+err.Error())
+		c.Status(500)
+		return
+	}
+	var siblings uint
+	err = tx.QueryRow(
+		"SELECT COUNT(*) FROM categories WHERE parent_refer <=> ? AND id <> ? AND deleted_at IS NULL",
+		request.ParentID, request.ID,
+	).Scan(&siblings)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	position := siblings
+	if request.Position != nil && *request.Position < siblings {
+		position = *request.Position
+	}
+	// open a gap on the new siblings
+	_, err = tx.Exec(
+		`UPDATE categories SET position = position + 1
+		WHERE parent_refer <=> ? AND position >= ? AND id <> ? AND deleted_at IS NULL`,
+		request.ParentID, position, request.ID,
+	)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "2-movecat:"+err.Error())
+		c.Status(500)
+		return
+	}
+	_, err = tx.Exec(
+		"UPDATE categories SET parent_refer = ?, position = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		request.ParentID, position, request.ID,
+	)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "3-movecat:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityCategory, strconv.FormatUint(uint64(request.ID), 10), before,
+		logging.Snapshot(categoryAuditQuery, request.ID),
+	)
+	c.Status(200)
+}
+
+// ReorderCategories set the order of the children of a parent, every child has to be listed
+func ReorderCategories(c *gin.Context) {
+	var request models.CategoryReorder
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	children, err := categoryIds(
+		"SELECT id FROM categories WHERE parent_refer <=> ? AND deleted_at IS NULL ORDER BY position, id",
+		request.ParentID,
+	)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if !sameCategories(children, request.IDs) {
+		c.JSON(400, gin.H{"error": "ids must list every child of the parent exactly once"})
+		return
+	}
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	for i, id := range request.IDs {
+		_, err := tx.Exec("UPDATE categories SET position = ? WHERE id = ?", i, id)
+		if err != nil {
+			go logging.InsertLog(logging.ERROR, "1-ordercat:"+err.Error())
+			c.Status(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityCategory, "order",
+		gin.H{"parent_id": request.ParentID, "ids": children}, gin.H{"parent_id": request.ParentID, "ids": request.IDs},
+	)
+	c.Status(200)
+}
+
+// UpdateHomepageCategories show the listed categories on the homepage in the given order and hide the rest
+func UpdateHomepageCategories(c *gin.Context) {
+	var request models.CategoryHomepage
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	seen := map[uint]bool{}
+	for _, id := range request.IDs {
+		if seen[id] {
+			c.JSON(400, gin.H{"error": "duplicate category id"})
+			return
+		}
+		seen[id] = true
+	}
+	before, err := categoryIds(
+		"SELECT id FROM categories WHERE homepage_visibility = 1 AND deleted_at IS NULL ORDER BY homepage_position, id",
+	)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE categories SET homepage_visibility = 0 WHERE homepage_visibility = 1"); err != nil {
+		go logging.InsertLog(logging.ERROR, "1-homecat:"+err.Error())
+		c.Status(500)
+		return
+	}
+	for i, id := range request.IDs {
+		res, err := tx.Exec(
+			"UPDATE categories SET homepage_visibility = 1, homepage_position = ? WHERE id = ? AND deleted_at IS NULL",
+			i, id,
+		)
+		if err != nil {
+			go logging.InsertLog(logging.ERROR, "2-homecat:"+err.Error())
+			c.Status(500)
+			return
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			c.JSON(404, gin.H{"error": "category " + strconv.FormatUint(uint64(id), 10) + " not found"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityCategory, "homepage", gin.H{"ids": before}, gin.H{"ids": request.IDs},
+	)
 	c.Status(200)
 }
+
+// checkParentCategory return errParentNotFound when the parent doesn't exist
+func checkParentCategory(parentId uint) error {
+	var exist int8
+	err := database.MysqlInstance.
+		QueryRow("SELECT 1 FROM categories WHERE id = ? AND deleted_at IS NULL", parentId).
+		Scan(&exist)
+	if err == sql.ErrNoRows {
+		return errParentNotFound
+	}
+	return err
+}
+
+// nextCategoryPosition return the position after the last child of the parent
+func nextCategoryPosition(parentId *uint) (uint, error) {
+	var position uint
+	err := database.MysqlInstance.
+		QueryRow(
+			"SELECT COALESCE(MAX(position) + 1, 0) FROM categories WHERE parent_refer <=> ? AND deleted_at IS NULL",
+			parentId,
+		).
+		Scan(&position)
+	return position, err
+}
+
+func categoryIds(query string, args ...interface{}) ([]uint, error) {
+	rows, err := database.MysqlInstance.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []uint{}
+	for rows.Next() {
+		var id uint
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// sameCategories check that both list hold the same ids, each of them once
+func sameCategories(current []uint, requested []uint) bool {
+	if len(current) != len(requested) {
+		return false
+	}
+	remaining := map[uint]bool{}
+	for _, id := range current {
+		remaining[id] = true
+	}
+	for _, id := range requested {
+		if !remaining[id] {
+			return false
+		}
+		delete(remaining, id)
+	}
+	return true
+}
+
+func categoryError(c *gin.Context, err error) {
+	if err == errParentNotFound {
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(500)
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package staff
+
+import (
+	"database/sql"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/controllers/global"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/sheet"
+	"github.com/gin-gonic/gin"
+)
+
+// exportColumns are the columns of the catalog export, there is a row per variant so every stock level is listed.
+// The attributes follow as attr:<code> columns
+var exportColumns = []interface{}{
+	"product_id", "sku_id", "name", "slug", "description", "category", "brand", "sku", "gtin", "options", "price", "stock",
+	"weight", "dimensions", "images",
+}
+
+// ExportProducts download every product with the stock of its variants as csv (default) or xlsx
+func ExportProducts(c *gin.Context) {
+	var request models.ProductExportQuery
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	if request.Format == "" {
+		request.Format = sheet.FormatCSV
+	}
+	contentType := "text/csv; charset=utf-8"
+	if request.Format == sheet.FormatXLSX {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	// the rows are loaded before anything is written so a failure can still be answered with 500
+	rows, err := productExportRows()
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-exportproduct:"+err.Error())
+		c.Status(500)
+		return
+	}
+	c.Header(
+		"Content-Disposition",
+		`attachment; filename="products-`+time.Now().Format("20060102")+"."+request.Format+`"`,
+	)
+	c.Header("Content-Type", contentType)
+	c.Status(200)
+	if err := sheet.Write(c.Writer, request.Format, rows); err != nil {
+		go logging.InsertLog(logging.ERROR, "2-exportproduct:"+err.Error())
+	}
+}
+
+// RegenerateFeeds rebuild the sitemap and the product feeds right away instead of waiting for the next FEED_INTERVAL
+func RegenerateFeeds(c *gin.Context) {
+	if err := global.RegenerateFeeds(); err != nil {
+		go logging.InsertLog(logging.ERROR, "1-regenfeed:"+err.Error())
+		c.Status(500)
+		return
+	}
+	c.Status(200)
+}
+
+// WriteProductExport write the catalog export in the given format, it is used by the export-products command
+func WriteProductExport(w io.Writer, format string) error {
+	rows, err := productExportRows()
+	if err != nil {
+		return err
+	}
+	return sheet.Write(w, format, rows)
+}
+
+// productExportRows return the header and a row per variant of every live product ordered by the product name
+func productExportRows() ([][]interface{}, error) {
+	attributes, codes, err := exportAttributes()
+	if err != nil {
+		return nil, err
+	}
+	header := append([]interface{}{}, exportColumns...)
+	for _, code := range codes {
+		header = append(header, attributePrefix+code)
+	}
+	result := [][]interface{}{header}
+
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(p.id), BIN_TO_UUID(s.id), p.name, p.slug, p.description, c.name, COALESCE(b.name, ''), COALESCE(s.sku, ''),
+		       COALESCE(s.gtin, ''),
+		       COALESCE((SELECT GROUP_CONCAT(CONCAT(o.name, '=', v.value) ORDER BY o.position, o.id SEPARATOR '; ')
+		                 FROM product_sku_values sv
+		                          INNER JOIN product_option_values v ON v.id = sv.option_value_refer
+		                          INNER JOIN product_options o ON o.id = v.option_refer
+		                 WHERE sv.sku_refer = s.id), ''),
+		       COALESCE(s.price, p.price), COALESCE(i.quantity, 0), COALESCE(s.weight, p.weight),
+		       CONCAT(COALESCE(s.length, p.length), ' x ', COALESCE(s.width, p.width), ' x ', COALESCE(s.height, p.height)),
+		       COALESCE((SELECT GROUP_CONCAT(CONCAT(BIN_TO_UUID(pi.id), '.webp') ORDER BY pi.is_primary DESC, pi.position, pi.id SEPARATOR ' ')
+		                 FROM product_images pi WHERE pi.product_refer = p.id), '')
+		FROM products p
+		         INNER JOIN categories c ON c.id = p.category_refer
+		         LEFT JOIN brands b ON b.id = p.brand_refer AND b.deleted_at IS NULL
+		         INNER JOIN product_skus s ON s.product_refer = p.id AND s.deleted_at IS NULL
+		         LEFT JOIN inventories i ON i.sku_refer = s.id
+		WHERE p.deleted_at IS NULL
+		ORDER BY p.name, s.created_at, s.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productId, skuId, name, slug, description, category, brand, sku, gtin, options, dimensions, images string
+		var price, stock uint
+		var weight float64
+		if err := rows.Scan(
+			&productId, &skuId, &name, &slug, &description, &category, &brand, &sku, &gtin, &options, &price, &stock,
+			&weight, &dimensions, &images,
+		); err != nil {
+			return nil, err
+		}
+		row := []interface{}{
+			productId, skuId, name, slug, description, category, brand, sku, gtin, options, price, stock, weight,
+			dimensions, images,
+		}
+		for _, code := range codes {
+			row = append(row, attributes[productId][code])
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// exportAttributes return the attribute values by the product id and the attribute code, along with every code in
+// use in order
+func exportAttributes() (map[string]map[string]interface{}, []string, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(v.product_refer), a.code, v.value_text, v.value_number
+		FROM product_attribute_values v
+		         INNER JOIN category_attributes a ON a.id = v.attribute_refer
+		         INNER JOIN products p ON p.id = v.product_refer AND p.category_refer = a.category_refer
+		WHERE p.deleted_at IS NULL`,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	values := map[string]map[string]interface{}{}
+	seen := map[string]bool{}
+	for rows.Next() {
+		var productId, code string
+		var text sql.NullString
+		var number sql.NullFloat64
+		if err := rows.Scan(&productId, &code, &text, &number); err != nil {
+			return nil, nil, err
+		}
+		if values[productId] == nil {
+			values[productId] = map[string]interface{}{}
+		}
+		if number.Valid {
+			values[productId][code] = number.Float64
+		} else {
+			values[productId][code] = text.String
+		}
+		seen[code] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	codes := make([]string, 0, len(seen))
+	for code := range seen {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return values, codes, nil
+}
@@ -0,0 +1,322 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package staff
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// limits of an uploaded product image, the storage converts the accepted image into webp
+const (
+	maxImageSize      = 5 << 20
+	minImageDimension = 200
+	maxImageDimension = 6000
+)
+
+var allowedImageTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/webp": true}
+
+// errInvalidImage is the reason an uploaded picture is refused, its message is shown to the staff
+type errInvalidImage struct {
+	message string
+}
+
+func (e errInvalidImage) Error() string {
+	return e.message
+}
+
+// UpdateImage change the alt text of an image or make it the primary image of the product
+func UpdateImage(c *gin.Context) {
+	var request models.ProductImageUpdate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	if request.AltText == nil && !request.Primary {
+		c.Status(400)
+		return
+	}
+	imageId := strings.Replace(request.FileName, ".webp", "", 1)
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	var before models.ProductImageResponse
+	err = tx.QueryRow(
+		`SELECT alt_text, is_primary FROM product_images
+		WHERE id = UUID_TO_BIN(?) AND product_refer = UUID_TO_BIN(?) FOR UPDATE`,
+		imageId, request.ProductID,
+	).Scan(&before.AltText, &before.Primary)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Status(404)
+			return
+		}
+		c.Status(500)
+		return
+	}
+	after := before
+	if request.AltText != nil {
+		_, err := tx.Exec("UPDATE product_images SET alt_text = ? WHERE id = UUID_TO_BIN(?)", *request.AltText, imageId)
+		if err != nil {
+			go logging.InsertLog(logging.ERROR, "1-updimg:"+err.Error())
+			c.Status(500)
+			return
+		}
+		after.AltText = *request.AltText
+	}
+	if request.Primary && !before.Primary {
+		// the old primary image is unset first as a product can't have two primary images
+		_, err := tx.Exec(
+			"UPDATE product_images SET is_primary = 0 WHERE product_refer = UUID_TO_BIN(?) AND is_primary = 1",
+			request.ProductID,
+		)
+		if err == nil {
+			_, err = tx.Exec("UPDATE product_images SET is_primary = 1 WHERE id = UUID_TO_BIN(?)", imageId)
+		}
+		if err != nil {
+			go logging.InsertLog(logging.ERROR, "2-updimg:"+err.Error())
+			c.Status(500)
+			return
+		}
+		after.Primary = true
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityProductImage, request.FileName,
+		gin.H{"alt_text": before.AltText, "primary": before.Primary},
+		gin.H{"alt_text": after.AltText, "primary": after.Primary},
+	)
+	c.Status(200)
+}
+
+// ReorderImages set the position of every image of the product, the primary image is still shown first
+func ReorderImages(c *gin.Context) {
+	var request models.ProductImageReorder
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	rows, err := database.MysqlInstance.Query(
+		"SELECT CONCAT(BIN_TO_UUID(id), '.webp') FROM product_images WHERE product_refer = UUID_TO_BIN(?) ORDER BY position, id",
+		request.ProductID,
+	)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer rows.Close()
+	remaining := map[string]bool{}
+	var current []string
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			c.Status(500)
+			return
+		}
+		remaining[file] = true
+		current = append(current, file)
+	}
+	if len(current) == 0 {
+		c.Status(404)
+		return
+	}
+	if len(current) != len(request.FileNames) {
+		c.JSON(400, gin.H{"error": "file_names must list every image of the product exactly once"})
+		return
+	}
+	for _, file := range request.FileNames {
+		if !remaining[file] {
+			c.JSON(400, gin.H{"error": "file_names must list every image of the product exactly once"})
+			return
+		}
+		delete(remaining, file)
+	}
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	for i, file := range request.FileNames {
+		_, err := tx.Exec(
+			"UPDATE product_images SET position = ? WHERE id = UUID_TO_BIN(?)", i, strings.Replace(file, ".webp", "", 1),
+		)
+		if err != nil {
+			go logging.InsertLog(logging.ERROR, "1-orderimg:"+err.Error())
+			c.Status(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityProductImage, request.ProductID,
+		gin.H{"file_names": current}, gin.H{"file_names": request.FileNames},
+	)
+	c.Status(200)
+}
+
+// insertImages append the uploaded files after the existing images of the product, the first file becomes the
+// primary image when the product has none
+func insertImages(request models.ProductImage, skuId interface{}, files []string) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertImagesTx(tx, request.ProductID, skuId, files, request.AltTexts); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertImagesTx is insertImages within the transaction of the caller
+func insertImagesTx(tx *sql.Tx, productId string, skuId interface{}, files []string, altTexts []string) error {
+	// the product row is locked so the concurrent uploads don't take the same position
+	var exist int8
+	err := tx.QueryRow("SELECT 1 FROM products WHERE id = UUID_TO_BIN(?) FOR UPDATE", productId).Scan(&exist)
+	if err != nil {
+		return err
+	}
+	var position uint
+	var hasPrimary bool
+	err = tx.QueryRow(
+		`SELECT COALESCE(MAX(position) + 1, 0), COALESCE(MAX(is_primary), 0) FROM product_images
+		WHERE product_refer = UUID_TO_BIN(?)`, productId,
+	).Scan(&position, &hasPrimary)
+	if err != nil {
+		return err
+	}
+	for i, file := range files {
+		var altText string
+		if i < len(altTexts) {
+			altText = altTexts[i]
+		}
+		_, err := tx.Exec(
+			`INSERT INTO product_images (id, product_refer, sku_refer, position, is_primary, alt_text)
+			VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?)`,
+			strings.Replace(file, ".webp", "", 1), productId, skuId, position+uint(i), !hasPrimary && i == 0,
+			altText,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promotePrimaryImage make the first remaining image the primary one when the product lost its primary image
+func promotePrimaryImage(productId string) error {
+	var hasPrimary bool
+	err := database.MysqlInstance.
+		QueryRow(
+			"SELECT COALESCE(MAX(is_primary), 0) FROM product_images WHERE product_refer = UUID_TO_BIN(?)", productId,
+		).
+		Scan(&hasPrimary)
+	if err != nil || hasPrimary {
+		return err
+	}
+	_, err = database.MysqlInstance.Exec(
+		"UPDATE product_images SET is_primary = 1 WHERE product_refer = UUID_TO_BIN(?) ORDER BY position, id LIMIT 1",
+		productId,
+	)
+	return err
+}
+
+// removeImages clean up the files which were uploaded by a failed request
+func removeImages(files []string) {
+	for _, file := range files {
+		if err := deleteImage(file); err != nil {
+			logging.InsertLog(logging.ERROR, "rmimg:"+err.Error())
+		}
+	}
+}
+
+// validateImage check the size, the content type and the dimension of the picture before it is uploaded
+func validateImage(picture *multipart.FileHeader) error {
+	if picture.Size == 0 {
+		return errInvalidImage{"file is empty"}
+	}
+	if picture.Size > maxImageSize {
+		return errInvalidImage{fmt.Sprintf("file is larger than %d MB", maxImageSize>>20)}
+	}
+	file, err := picture.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	return validateImageData(data)
+}
+
+// validateImageData check the content of an image regardless of where it comes from
+func validateImageData(data []byte) error {
+	if len(data) > maxImageSize {
+		return errInvalidImage{fmt.Sprintf("file is larger than %d MB", maxImageSize>>20)}
+	}
+	if !allowedImageTypes[http.DetectContentType(data)] {
+		return errInvalidImage{"file must be a jpeg, png or webp image"}
+	}
+	width, height, err := storage.Dimension(data)
+	if err != nil {
+		return errInvalidImage{"image is corrupted"}
+	}
+	if width < minImageDimension || height < minImageDimension {
+		return errInvalidImage{fmt.Sprintf("image must be at least %dx%d pixels", minImageDimension, minImageDimension)}
+	}
+	if width > maxImageDimension || height > maxImageDimension {
+		return errInvalidImage{fmt.Sprintf("image must be at most %dx%d pixels", maxImageDimension, maxImageDimension)}
+	}
+	return nil
+}
+
+// imageError write the response of a validateImage error, index is the position of the picture in the request
+func imageError(c *gin.Context, err error, index int) {
+	var invalid errInvalidImage
+	if errors.As(err, &invalid) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("picture %d: %s", index+1, invalid.Error())})
+		return
+	}
+	go logging.InsertLog(logging.ERROR, "valimg:"+err.Error())
+	c.Status(500)
+}
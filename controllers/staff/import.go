@@ -0,0 +1,580 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package staff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/controllers/global"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/search"
+	"github.com/Tus1688/openmerce-backend/service/sheet"
+	"github.com/Tus1688/openmerce-backend/service/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// The import file has a header row, the columns are matched case-insensitively and the unknown columns are ignored:
+//
+//	name, category, price, stock, weight, dimensions  required, category is the id, the name or the slug of the
+//	                                                  category and dimensions is "length x width x height"
+//	description, brand, sku                           optional, brand is the name or the slug of the brand
+//	gtin                                              optional barcode (EAN, UPC or ISBN) of the product
+//	image_urls                                        optional http(s) urls separated by spaces, the first one
+//	                                                  becomes the primary image
+//	attr:<code>                                       the value of an attribute of the category
+var importRequiredColumns = []string{"name", "category", "price", "stock", "weight", "dimensions"}
+
+const (
+	maxImportRows   = 1000
+	attributePrefix = "attr:"
+)
+
+// errImport is a problem of the whole file, its message is shown to the staff
+type errImport struct {
+	message string
+}
+
+func (e errImport) Error() string {
+	return e.message
+}
+
+// importRow is a valid row which is ready to be created
+type importRow struct {
+	line       int
+	product    models.ProductCreate
+	attributes []attributeValue
+	imageUrls  []string
+	files      []string
+}
+
+// imageClient download the images of the import, the private addresses are refused so the import can't be used to
+// reach the internal services
+var imageClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(_ string, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+					return errors.New("address is not allowed")
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+// ImportProducts create the products of a csv or xlsx file as drafts. Every row is validated first and the products
+// are only created when none of them has an error, a dry run only reports the errors
+func ImportProducts(c *gin.Context) {
+	var request models.ProductImport
+	if err := c.ShouldBind(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	file, err := request.File.Open()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer file.Close()
+	rows, err := sheet.Read(request.File.Filename, file, request.File.Size)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "unable to read the file: " + err.Error()})
+		return
+	}
+	result, err := RunProductImport(rows, request.DryRun)
+	if err != nil {
+		var invalid errImport
+		if errors.As(err, &invalid) {
+			c.JSON(400, gin.H{"error": invalid.Error()})
+			return
+		}
+		go logging.InsertLog(logging.ERROR, "1-importproduct:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if len(result.Errors) > 0 {
+		c.JSON(400, result)
+		return
+	}
+	if request.DryRun {
+		c.JSON(200, result)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionCreate, logging.EntityProduct, "import", nil,
+		gin.H{"file": request.File.Filename, "products": result.Created},
+	)
+	go func(ids []string) {
+		for _, id := range ids {
+			search.Sync(id)
+		}
+	}(result.Created)
+	c.JSON(201, result)
+}
+
+// RunProductImport validate the rows of an import file where the first row is the header and create the products
+// unless it is a dry run or a row has an error. It is shared by the endpoint and the import-products command
+func RunProductImport(rows [][]string, dryRun bool) (models.ProductImportResult, error) {
+	result := models.ProductImportResult{DryRun: dryRun, Created: []string{}, Errors: []models.ProductImportError{}}
+	if len(rows) == 0 {
+		return result, errImport{"the file is empty"}
+	}
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := columns[name]; ok {
+			return result, errImport{fmt.Sprintf("column %s is given more than once", name)}
+		}
+		columns[name] = i
+	}
+	for _, name := range importRequiredColumns {
+		if _, ok := columns[name]; !ok {
+			return result, errImport{fmt.Sprintf("column %s is required", name)}
+		}
+	}
+	for line := 2; line <= len(rows); line++ {
+		if !emptyRow(rows[line-1]) {
+			result.Rows++
+		}
+	}
+	if result.Rows == 0 {
+		return result, errImport{"the file doesn't have any product"}
+	}
+	if result.Rows > maxImportRows {
+		return result, errImport{fmt.Sprintf("the file can have up to %d products", maxImportRows)}
+	}
+
+	resolver, err := newImportResolver(rows[1:], columns)
+	if err != nil {
+		return result, err
+	}
+	addError := func(line int, column string, message string) {
+		result.Errors = append(result.Errors, models.ProductImportError{Row: line, Column: column, Error: message})
+	}
+	var valid []importRow
+	for line := 2; line <= len(rows); line++ {
+		cells := rows[line-1]
+		if emptyRow(cells) {
+			continue
+		}
+		cell := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(cells) {
+				return strings.TrimSpace(cells[i])
+			}
+			return ""
+		}
+		errorCount := len(result.Errors)
+		row := importRow{line: line}
+		product := &row.product
+		product.Name = cell("name")
+		product.Description = cell("description")
+		product.Sku = cell("sku")
+		product.Gtin = cell("gtin")
+		switch {
+		case product.Name == "":
+			addError(line, "name", "name is required")
+		case len(product.Name) > 85:
+			addError(line, "name", "name must be up to 85 characters")
+		case resolver.nameTaken(product.Name, line):
+			addError(line, "name", "a product with the same name already exists")
+		}
+		if len(product.Description) > 300 {
+			addError(line, "description", "description must be up to 300 characters")
+		}
+		if product.Sku != "" {
+			if len(product.Sku) > 64 {
+				addError(line, "sku", "sku must be up to 64 characters")
+			} else if resolver.skuTaken(product.Sku, line) {
+				addError(line, "sku", "a variant with the same sku already exists")
+			}
+		}
+		if product.Gtin != "" && !models.ValidGtin(product.Gtin) {
+			addError(line, "gtin", "gtin must be a valid GTIN-8, UPC, EAN or GTIN-14")
+		}
+		if id, err := resolver.category(cell("category")); err != nil {
+			addError(line, "category", err.Error())
+		} else {
+			product.CategoryID = id
+		}
+		if brand := cell("brand"); brand != "" {
+			if id, err := resolver.brand(brand); err != nil {
+				addError(line, "brand", err.Error())
+			} else {
+				product.BrandID = id
+			}
+		}
+		if price, err := strconv.ParseUint(cell("price"), 10, 32); err != nil || price == 0 {
+			addError(line, "price", "price must be a positive whole number")
+		} else {
+			product.Price = uint(price)
+		}
+		if stock, err := strconv.ParseUint(cell("stock"), 10, 32); err != nil {
+			addError(line, "stock", "stock must be a whole number")
+		} else {
+			product.InitialStock = uint(stock)
+		}
+		if weight, err := strconv.ParseFloat(cell("weight"), 64); err != nil || weight <= 0 || weight >= 1e8 {
+			addError(line, "weight", "weight must be a positive number")
+		} else {
+			product.Weight = weight
+		}
+		if dimensions, ok := parseDimensions(cell("dimensions")); !ok {
+			addError(line, "dimensions", "dimensions must be length x width x height in whole numbers, e.g. 10 x 5 x 2")
+		} else {
+			product.Length, product.Width, product.Height = dimensions[0], dimensions[1], dimensions[2]
+		}
+		if urls := strings.Fields(cell("image_urls")); len(urls) > 10 {
+			addError(line, "image_urls", "a product can have up to 10 images")
+		} else {
+			for _, raw := range urls {
+				if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					addError(line, "image_urls", raw+" is not a http or https url")
+				}
+			}
+			row.imageUrls = urls
+		}
+		// the attributes can only be checked against a known category
+		if product.CategoryID != 0 {
+			values, err := resolver.attributes(product.CategoryID, columns, cell)
+			if err == nil {
+				row.attributes, err = parseAttributes(product.CategoryID, values, true)
+			}
+			var invalid errAttribute
+			if errors.As(err, &invalid) {
+				addError(line, "", invalid.Error())
+			} else if err != nil {
+				return result, err
+			}
+		}
+		if len(result.Errors) == errorCount {
+			valid = append(valid, row)
+		}
+	}
+	if dryRun || len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	// the images are only downloaded on commit, a broken image is reported like the other errors and the images
+	// which are already uploaded are removed
+	var uploaded []string
+	for i := range valid {
+		for _, raw := range valid[i].imageUrls {
+			data, err := fetchImage(raw)
+			if err != nil {
+				var invalid errInvalidImage
+				if !errors.As(err, &invalid) {
+					err = errInvalidImage{"unable to download the image"}
+				}
+				addError(valid[i].line, "image_urls", raw+": "+err.Error())
+				continue
+			}
+			if len(result.Errors) > 0 {
+				continue
+			}
+			// the url is already checked by the validation
+			u, _ := url.Parse(raw)
+			file, err := storage.DefaultStore.Upload(path.Base(u.Path), data)
+			if err != nil {
+				removeImages(uploaded)
+				return result, err
+			}
+			uploaded = append(uploaded, file)
+			valid[i].files = append(valid[i].files, file)
+		}
+	}
+	if len(result.Errors) > 0 {
+		removeImages(uploaded)
+		return result, nil
+	}
+	ids, err := createImportedProducts(valid)
+	if err != nil {
+		removeImages(uploaded)
+		return result, err
+	}
+	result.Created = ids
+	return result, nil
+}
+
+// createImportedProducts create every product in a single transaction so a failure leaves nothing behind
+func createImportedProducts(rows []importRow) ([]string, error) {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var ids []string
+	for _, row := range rows {
+		id, err := createProduct(tx, row.product, row.attributes)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row.line, err)
+		}
+		if err := insertImagesTx(tx, id.String(), nil, row.files, nil); err != nil {
+			return nil, fmt.Errorf("row %d: %w", row.line, err)
+		}
+		ids = append(ids, id.String())
+	}
+	return ids, tx.Commit()
+}
+
+// importResolver look up the categories, brands, names and skus of the whole file at once
+type importResolver struct {
+	categories     map[uint]bool
+	categoryByName map[string][]uint
+	brands         map[string]uint
+	// names and skus map the lower cased value to the first row which uses it, 0 means it is taken by a product
+	names   map[string]int
+	skus    map[string]int
+	schemas map[uint][]models.CategoryAttribute
+}
+
+func newImportResolver(rows [][]string, columns map[string]int) (*importResolver, error) {
+	resolver := &importResolver{
+		categories: map[uint]bool{}, categoryByName: map[string][]uint{}, brands: map[string]uint{},
+		names: map[string]int{}, skus: map[string]int{}, schemas: map[uint][]models.CategoryAttribute{},
+	}
+	categoryRows, err := database.MysqlInstance.Query("SELECT id, name, slug FROM categories WHERE deleted_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer categoryRows.Close()
+	for categoryRows.Next() {
+		var id uint
+		var name, slug string
+		if err := categoryRows.Scan(&id, &name, &slug); err != nil {
+			return nil, err
+		}
+		resolver.categories[id] = true
+		name = strings.ToLower(name)
+		resolver.categoryByName[name] = append(resolver.categoryByName[name], id)
+		if slug != name {
+			resolver.categoryByName[slug] = append(resolver.categoryByName[slug], id)
+		}
+	}
+	if err := categoryRows.Err(); err != nil {
+		return nil, err
+	}
+	brandRows, err := database.MysqlInstance.Query("SELECT id, name, slug FROM brands WHERE deleted_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer brandRows.Close()
+	for brandRows.Next() {
+		var id uint
+		var name, slug string
+		if err := brandRows.Scan(&id, &name, &slug); err != nil {
+			return nil, err
+		}
+		resolver.brands[strings.ToLower(name)] = id
+		resolver.brands[slug] = id
+	}
+	if err := brandRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the names and skus which are already used by the live products, a deleted product is revived by its name
+	lookup := func(column string, query string, taken map[string]int) error {
+		var values []interface{}
+		for _, cells := range rows {
+			if i := columns[column]; i < len(cells) && strings.TrimSpace(cells[i]) != "" {
+				values = append(values, strings.TrimSpace(cells[i]))
+			}
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		result, err := database.MysqlInstance.Query(query+" IN (?"+strings.Repeat(", ?", len(values)-1)+")", values...)
+		if err != nil {
+			return err
+		}
+		defer result.Close()
+		for result.Next() {
+			var value string
+			if err := result.Scan(&value); err != nil {
+				return err
+			}
+			taken[strings.ToLower(value)] = 0
+		}
+		return result.Err()
+	}
+	if err := lookup("name", "SELECT name FROM products WHERE deleted_at IS NULL AND name", resolver.names); err != nil {
+		return nil, err
+	}
+	if _, ok := columns["sku"]; ok {
+		if err := lookup("sku", "SELECT sku FROM product_skus WHERE sku", resolver.skus); err != nil {
+			return nil, err
+		}
+	}
+	return resolver, nil
+}
+
+// nameTaken report whether the name is used by a product or an earlier row, the comparison is case-insensitive like
+// the unique key of mysql
+func (r *importResolver) nameTaken(name string, line int) bool {
+	return taken(r.names, strings.ToLower(name), line)
+}
+
+func (r *importResolver) skuTaken(sku string, line int) bool {
+	return taken(r.skus, strings.ToLower(sku), line)
+}
+
+func taken(values map[string]int, value string, line int) bool {
+	if first, ok := values[value]; ok {
+		return first != line
+	}
+	values[value] = line
+	return false
+}
+
+func (r *importResolver) category(value string) (uint, error) {
+	if value == "" {
+		return 0, errors.New("category is required")
+	}
+	if id, err := strconv.ParseUint(value, 10, 32); err == nil {
+		if !r.categories[uint(id)] {
+			return 0, errors.New("category not found")
+		}
+		return uint(id), nil
+	}
+	ids := r.categoryByName[strings.ToLower(value)]
+	switch len(ids) {
+	case 0:
+		return 0, errors.New("category not found")
+	case 1:
+		return ids[0], nil
+	}
+	return 0, errors.New("there is more than one category with this name, use the category id instead")
+}
+
+func (r *importResolver) brand(value string) (uint, error) {
+	if id, ok := r.brands[strings.ToLower(value)]; ok {
+		return id, nil
+	}
+	return 0, errBrandNotFound
+}
+
+// attributes convert the attr:<code> cells into the values expected by parseAttributes, an empty cell is left out
+func (r *importResolver) attributes(
+	categoryId uint, columns map[string]int, cell func(column string) string,
+) (map[string]interface{}, error) {
+	schema, ok := r.schemas[categoryId]
+	if !ok {
+		var err error
+		schema, err = global.CategoryAttributes(categoryId, false)
+		if err != nil {
+			return nil, err
+		}
+		r.schemas[categoryId] = schema
+	}
+	types := map[string]string{}
+	for _, attribute := range schema {
+		types[attribute.Code] = attribute.Type
+	}
+	values := map[string]interface{}{}
+	for column := range columns {
+		code, ok := strings.CutPrefix(column, attributePrefix)
+		if !ok || cell(column) == "" {
+			continue
+		}
+		value := cell(column)
+		switch types[code] {
+		case "number":
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, errAttribute{fmt.Sprintf("attribute %s must be a number", code)}
+			}
+			values[code] = number
+		case "boolean":
+			boolean, err := strconv.ParseBool(strings.ToLower(value))
+			if err != nil {
+				return nil, errAttribute{fmt.Sprintf("attribute %s must be true or false", code)}
+			}
+			values[code] = boolean
+		default:
+			values[code] = value
+		}
+	}
+	return values, nil
+}
+
+// parseDimensions parse "length x width x height", the spaces are optional
+func parseDimensions(value string) ([3]uint16, bool) {
+	var dimensions [3]uint16
+	parts := strings.Split(strings.ToLower(value), "x")
+	if len(parts) != 3 {
+		return dimensions, false
+	}
+	for i, part := range parts {
+		number, err := strconv.ParseUint(strings.TrimSpace(part), 10, 16)
+		if err != nil || number == 0 {
+			return dimensions, false
+		}
+		dimensions[i] = uint16(number)
+	}
+	return dimensions, true
+}
+
+func emptyRow(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchImage download the image and check it like an uploaded picture
+func fetchImage(raw string) ([]byte, error) {
+	res, err := imageClient.Get(raw)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errInvalidImage{fmt.Sprintf("the server responded with %d", res.StatusCode)}
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	return data, validateImageData(data)
+}
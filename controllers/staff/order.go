@@ -23,13 +23,18 @@ package staff
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
 	"sync"
 
 	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
 	"github.com/Tus1688/openmerce-backend/models"
 	"github.com/gin-gonic/gin"
 )
 
+// orderAuditQuery is the state of an order which is recorded on the audit trail
+const orderAuditQuery = "SELECT transaction_status, is_shipped, courier_tracking_code, need_refund FROM orders WHERE id = ?"
+
 func GetOrder(c *gin.Context) {
 	// the token should be valid and exist as it is protected by TokenExpiredCustomer middleware
 	var request models.APICommonQueryID
@@ -88,12 +93,10 @@ func GetOrder(c *gin.Context) {
 			rows, err := database.MysqlInstance.
 				Query(
 					`
-					select oi.id, BIN_TO_UUID(oi.product_refer), oi.on_buy_name, oi.on_buy_price, coalesce(pi.image, ''), oi.quantity, (if (r.id is null, false, true)) as reviewed
+					select oi.id, BIN_TO_UUID(oi.product_refer), BIN_TO_UUID(oi.sku_refer), oi.on_buy_name, oi.on_buy_variant, oi.on_buy_price, coalesce(pi.image, ''), oi.quantity, (if (r.id is null, false, true)) as reviewed
 					from order_items oi
-					         left join (select pi.product_refer, CONCAT(BIN_TO_UUID(pi.id), '.webp') as image
-					                    from (select product_refer, min(id) as id
-					                          from product_images
-					                          group by product_refer) pi) pi on pi.product_refer = oi.product_refer
+					         left join (select product_refer, CONCAT(BIN_TO_UUID(id), '.webp') as image
+					                    from product_images where is_primary = 1) pi on pi.product_refer = oi.product_refer
 					         inner join orders o on oi.order_refer = o.id
 					left join reviews r on oi.id = r.order_item_refer
 					where oi.order_refer = ?;
@@ -107,8 +110,8 @@ func GetOrder(c *gin.Context) {
 			for rows.Next() {
 				var item models.ItemListOrderDetail
 				err := rows.Scan(
-					&item.OrderID, &item.ProductId, &item.ProductName, &item.ProductPrice, &item.ProductImage,
-					&item.Quantity, &item.Reviewed,
+					&item.OrderID, &item.ProductId, &item.SkuId, &item.ProductName, &item.Variant, &item.ProductPrice,
+					&item.ProductImage, &item.Quantity, &item.Reviewed,
 				)
 				if err != nil {
 					errChan <- err
@@ -189,12 +192,9 @@ func GetOrder(c *gin.Context) {
 			    GROUP BY order_refer
 			) oi ON oi.order_refer = o.id
 			         LEFT JOIN (
-			    SELECT pi.product_refer, CONCAT(BIN_TO_UUID(pi.id), '.webp') AS image
-			    FROM (
-			             SELECT product_refer, MIN(id) AS id
-			             FROM product_images
-			             GROUP BY product_refer
-			         ) pi
+			    SELECT product_refer, CONCAT(BIN_TO_UUID(id), '.webp') AS image
+			    FROM product_images
+			    WHERE is_primary = 1
 			) pi ON pi.product_refer = (SELECT product_refer FROM order_items WHERE order_refer = o.id LIMIT 1)
 			         LEFT JOIN (
 			    SELECT id, name
@@ -245,6 +245,7 @@ func ShipOrder(c *gin.Context) {
 		c.Status(500)
 		return
 	}
+	before := logging.Snapshot(orderAuditQuery, request.OrderId)
 	_, err = database.MysqlInstance.
 		Exec(
 			"UPDATE orders SET is_shipped = true, courier_tracking_code = ? WHERE id = ? AND transaction_status = 'settlement' OR transaction_status = 'capture'",
@@ -254,5 +255,9 @@ func ShipOrder(c *gin.Context) {
 		c.Status(500)
 		return
 	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityOrder, strconv.FormatUint(request.OrderId, 10), before,
+		logging.Snapshot(orderAuditQuery, request.OrderId),
+	)
 	c.Status(200)
 }
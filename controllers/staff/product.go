@@ -21,32 +21,45 @@
 package staff
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
-	"io"
 	"log"
-	"mime/multipart"
-	"net/http"
-	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/Tus1688/openmerce-backend/controllers/global"
 	"github.com/Tus1688/openmerce-backend/database"
 	"github.com/Tus1688/openmerce-backend/logging"
 	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/search"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+var (
+	errProductExists = errors.New("Product name already exists")
+	errSkuExists     = errors.New("SKU already exists")
+)
+
+// productAuditQuery is the state of a product which is recorded on the audit trail
+const productAuditQuery = `
+	SELECT p.name, p.slug, p.description, p.price, p.weight, p.category_refer, p.brand_refer, p.length, p.width, p.height,
+	       (SELECT SUM(i.quantity) FROM inventories i, product_skus s
+	        WHERE i.sku_refer = s.id AND s.product_refer = p.id AND s.deleted_at IS NULL) AS stock, p.status,
+	       p.publish_at, p.unpublish_at, p.deleted_at
+	FROM products p WHERE p.id = UUID_TO_BIN(?)`
+
 func AddNewProduct(c *gin.Context) {
 	var request models.ProductCreate
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.Status(400)
 		return
 	}
+	if request.Gtin != "" && !models.ValidGtin(request.Gtin) {
+		c.JSON(400, gin.H{"error": "invalid gtin"})
+		return
+	}
 	wg := sync.WaitGroup{}
 	errChan := make(chan error, 1)
 	// check if the category exists
@@ -67,16 +80,11 @@ func AddNewProduct(c *gin.Context) {
 		}
 	}(request.CategoryID)
 
-	var id uuid.UUID
-	// check if soft-delete product with the same name exists
-	var existingProductID uuid.UUID
-	err := database.MysqlInstance.
-		QueryRow("SELECT BIN_TO_UUID(id) FROM products WHERE name = ? AND deleted_at IS NOT NULL", request.Name).
-		Scan(&existingProductID)
-	if err != nil && err != sql.ErrNoRows {
-		go logging.InsertLog(logging.ERROR, "1-exist:"+err.Error())
-		c.Status(500)
-		return
+	if request.BrandID != 0 {
+		if err := checkBrand(request.BrandID); err != nil {
+			brandError(c, err)
+			return
+		}
 	}
 
 	// wait for the category check to finish
@@ -93,61 +101,118 @@ func AddNewProduct(c *gin.Context) {
 			return
 		}
 	}
+	attributes, err := parseAttributes(request.CategoryID, request.Attributes, true)
+	if err != nil {
+		attributeError(c, err, "2-attr:")
+		return
+	}
+
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "3-tx:"+err.Error())
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	id, err := createProduct(tx, request, attributes)
+	if err != nil {
+		if err == errProductExists || err == errSkuExists {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+		go logging.InsertLog(logging.ERROR, "3-insert:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		go logging.InsertLog(logging.ERROR, "4-commit:"+err.Error())
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionCreate, logging.EntityProduct, id.String(), nil,
+		logging.Snapshot(productAuditQuery, id),
+	)
+	go search.Sync(id.String())
+	c.JSON(201, gin.H{"id": id})
+}
 
-	if existingProductID != uuid.Nil {
+// createProduct insert the product along with its default sku and attributes, a deleted product with the same name
+// is revived without its previous variants and attributes but with its slug
+func createProduct(tx *sql.Tx, request models.ProductCreate, attributes []attributeValue) (uuid.UUID, error) {
+	var brandId interface{}
+	if request.BrandID != 0 {
+		brandId = request.BrandID
+	}
+	var id uuid.UUID
+	err := tx.QueryRow("SELECT BIN_TO_UUID(id) FROM products WHERE name = ? AND deleted_at IS NOT NULL", request.Name).
+		Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return uuid.Nil, err
+	}
+	if id != uuid.Nil {
 		//	update the deleted_at to NULL
-		_, err = database.MysqlInstance.
-			Exec(
-				"UPDATE products SET deleted_at = NULL, created_at = CURRENT_TIMESTAMP, updated_at = NULL, description = ?, price = ?, weight = ?, category_refer = ?, cumulative_review = 0, length = ?, width = ?, height = ?  WHERE id = UUID_TO_BIN(?)",
-				request.Description, request.Price, request.Weight, request.CategoryID, request.Length, request.Width,
-				request.Height, existingProductID,
-			)
+		_, err = tx.Exec(
+			"UPDATE products SET deleted_at = NULL, created_at = CURRENT_TIMESTAMP, updated_at = NULL, status = 'draft', publish_at = NULL, unpublish_at = NULL, description = ?, price = ?, weight = ?, category_refer = ?, brand_refer = ?, cumulative_review = 0, length = ?, width = ?, height = ?  WHERE id = UUID_TO_BIN(?)",
+			request.Description, request.Price, request.Weight, request.CategoryID, brandId, request.Length,
+			request.Width, request.Height, id,
+		)
 		if err != nil {
-			c.Status(500)
-			go logging.InsertLog(logging.ERROR, "2-cat:"+err.Error())
-			return
+			return uuid.Nil, err
+		}
+		// the variants and attributes of the previously deleted product are not carried over
+		if err := deleteSkusTx(tx, id.String(), ""); err != nil {
+			return uuid.Nil, err
+		}
+		_, err = tx.Exec("DELETE FROM product_attribute_values WHERE product_refer = UUID_TO_BIN(?)", id)
+		if err != nil {
+			return uuid.Nil, err
 		}
-		id = existingProductID
 	} else {
 		id = uuid.New()
-		// insert the new product
-		_, err = database.MysqlInstance.
-			Exec(
-				"INSERT INTO products (id, name, description, price, weight, category_refer, length, width, height) VALUES (UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?, ?, ?)",
-				id, request.Name, request.Description, request.Price, request.Weight, request.CategoryID,
-				request.Length, request.Width, request.Height,
-			)
+		slug, err := productSlugs.unique(tx, request.Name, id)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		_, err = tx.Exec(
+			"INSERT INTO products (id, name, slug, description, price, weight, category_refer, brand_refer, length, width, height) VALUES (UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			id, request.Name, slug, request.Description, request.Price, request.Weight, request.CategoryID, brandId,
+			request.Length, request.Width, request.Height,
+		)
 		if err != nil {
-			//	check if the product name already exists
 			if strings.Contains(err.Error(), "Duplicate entry") {
-				c.JSON(409, gin.H{"error": "Product name already exists"})
-				return
+				return uuid.Nil, errProductExists
 			}
-			go logging.InsertLog(logging.ERROR, "3-insert:"+err.Error())
-			c.Status(500)
-			return
+			return uuid.Nil, err
 		}
 	}
-	// insert the new product into inventories
-	if existingProductID != uuid.Nil {
-		_, err = database.MysqlInstance.
-			Exec(
-				"UPDATE inventories SET quantity = ?, updated_at = CURRENT_TIMESTAMP WHERE product_refer = UUID_TO_BIN(?)",
-				request.InitialStock, existingProductID,
-			)
-	} else {
-		_, err = database.MysqlInstance.
-			Exec(
-				"INSERT INTO inventories (product_refer, quantity, updated_at) VALUE (UUID_TO_BIN(?), ?, CURRENT_TIMESTAMP)",
-				id, request.InitialStock,
-			)
+	// insert the default sku and its inventory
+	skuId := uuid.New()
+	var skuCode, gtin interface{}
+	if request.Sku != "" {
+		skuCode = request.Sku
+	}
+	if request.Gtin != "" {
+		gtin = request.Gtin
 	}
+	_, err = tx.Exec(
+		"INSERT INTO product_skus (id, product_refer, sku, gtin) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?)",
+		skuId, id, skuCode, gtin,
+	)
 	if err != nil {
-		go logging.InsertLog(logging.ERROR, "4-inventory:"+err.Error())
-		c.Status(500)
-		return
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return uuid.Nil, errSkuExists
+		}
+		return uuid.Nil, err
 	}
-	c.JSON(201, gin.H{"id": id})
+	_, err = tx.Exec(
+		"INSERT INTO inventories (product_refer, sku_refer, quantity, updated_at) VALUE (UUID_TO_BIN(?), UUID_TO_BIN(?), ?, CURRENT_TIMESTAMP)",
+		id, skuId, request.InitialStock,
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return id, saveAttributesTx(tx, id.String(), attributes)
 }
 
 func AddImage(c *gin.Context) {
@@ -156,6 +221,10 @@ func AddImage(c *gin.Context) {
 		c.Status(400)
 		return
 	}
+	if len(request.AltTexts) > len(request.Pictures) {
+		c.JSON(400, gin.H{"error": "more alt texts than pictures"})
+		return
+	}
 	// check if the product exists
 	var exist int8
 	err := database.MysqlInstance.QueryRow(
@@ -165,76 +234,51 @@ func AddImage(c *gin.Context) {
 		c.Status(404)
 		return
 	}
-	//	Insert the image by sending request into NginxFSBaseUrl
-	url := NginxFSBaseUrl + "/handler"
-	//	upload the image to NginxFS
-	image, err := request.Picture.Open()
-	if err != nil {
-		c.Status(500)
-		go logging.InsertLog(logging.ERROR, "1-addimg:"+err.Error())
-		return
-	}
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("picture", request.Picture.Filename)
-	if err != nil {
-		go logging.InsertLog(logging.ERROR, "2-addimg:"+err.Error())
-		c.Status(500)
-		return
-	}
-	_, err = io.Copy(part, image)
-	if err != nil {
-		go logging.InsertLog(logging.ERROR, "3-addimg:"+err.Error())
-		c.Status(500)
-		return
-	}
-	err = writer.Close()
-	if err != nil {
-		go logging.InsertLog(logging.ERROR, "4-addimg:"+err.Error())
-		c.Status(500)
-		return
-	}
-	req, err := http.NewRequest("POST", url, body)
-	if err != nil {
-		go logging.InsertLog(logging.ERROR, "5-addimg:"+err.Error())
-		c.Status(500)
-		return
-	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", NginxFSAuthorization)
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		go logging.InsertLog(logging.ERROR, "6-addimg:"+err.Error())
-		c.Status(500)
-		return
+	// the image may belong to a specific variant of the product
+	var skuId interface{}
+	if request.SkuID != "" {
+		err := database.MysqlInstance.QueryRow(
+			"SELECT 1 FROM product_skus WHERE id = UUID_TO_BIN(?) AND product_refer = UUID_TO_BIN(?) AND deleted_at IS NULL",
+			request.SkuID, request.ProductID,
+		).Scan(&exist)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "sku not found"})
+			return
+		}
+		skuId = request.SkuID
 	}
-	defer res.Body.Close()
-	if res.StatusCode != 201 {
-		go logging.InsertLog(logging.ERROR, "7-addimg:"+err.Error())
-		c.Status(500)
-		return
+	// every picture is checked before anything is sent to the image server
+	for i, picture := range request.Pictures {
+		if err := validateImage(picture); err != nil {
+			imageError(c, err, i)
+			return
+		}
 	}
-	// we are going to get "id": uuid from the response
-	var response struct {
-		File string `json:"file"`
+	var files []string
+	for _, picture := range request.Pictures {
+		file, err := uploadImage(picture)
+		if err != nil {
+			go logging.InsertLog(logging.ERROR, "1-addimg:"+err.Error())
+			go removeImages(files)
+			c.Status(500)
+			return
+		}
+		files = append(files, file)
 	}
-	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
-		go logging.InsertLog(logging.ERROR, "8-addimg:"+err.Error())
+	if err := insertImages(request, skuId, files); err != nil {
+		go logging.InsertLog(logging.ERROR, "2-addimg:"+err.Error())
+		go removeImages(files)
 		c.Status(500)
 		return
 	}
-	//	insert the response.File into product_images
-	_, err = database.MysqlInstance.
-		Exec(
-			"INSERT INTO product_images (id, product_refer) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?))",
-			strings.Replace(response.File, ".webp", "", 1), request.ProductID,
+	for _, file := range files {
+		logging.Audit(
+			c, logging.ActionCreate, logging.EntityProductImage, file, nil,
+			gin.H{"product_id": request.ProductID, "sku_id": request.SkuID, "file": file},
 		)
-	if err != nil {
-		go logging.InsertLog(logging.ERROR, "9-addimg:"+err.Error())
-		c.Status(500)
-		return
 	}
-	c.JSON(201, gin.H{"file": response.File})
+	// file is kept for the clients which upload a single picture
+	c.JSON(201, gin.H{"file": files[0], "files": files})
 }
 
 func DeleteProduct(c *gin.Context) {
@@ -243,6 +287,7 @@ func DeleteProduct(c *gin.Context) {
 		c.Status(400)
 		return
 	}
+	before := logging.Snapshot(productAuditQuery, request.ID)
 	//	try to delete the product by set deleted_at to current timestamp
 	res, err := database.MysqlInstance.Exec(
 		"UPDATE products SET deleted_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL",
@@ -285,31 +330,17 @@ func DeleteProduct(c *gin.Context) {
 		imageUrls = append(imageUrls, imageUrl)
 	}
 
-	//	delete the images from NginxFS
+	//	delete the images from the storage
 	var wg sync.WaitGroup
 	errChan := make(chan error)
 	for _, imageUrl := range imageUrls {
 		wg.Add(1)
 		go func(targetUrl string) {
 			defer wg.Done()
-			url := NginxFSBaseUrl + "/handler?file=" + targetUrl + ".webp"
-			req, err := http.NewRequest(http.MethodDelete, url, nil)
-			req.Header.Set("Authorization", NginxFSAuthorization)
-			if err != nil {
-				errChan <- err
-				return
-			}
-			res, err := http.DefaultClient.Do(req)
-			if err != nil {
+			if err := deleteImage(targetUrl + ".webp"); err != nil {
 				errChan <- err
 				return
 			}
-			defer res.Body.Close()
-			if res.StatusCode != 200 && res.StatusCode != 404 {
-				// 404 considered as success as it maybe deleted by other request
-				errChan <- errors.New("failed to delete image from NginxFS with status code " + strconv.Itoa(res.StatusCode))
-				return
-			}
 			//	delete the image from product_images
 			_, err = database.MysqlInstance.Exec("DELETE FROM product_images WHERE id = UUID_TO_BIN(?)", targetUrl)
 			if err != nil {
@@ -351,7 +382,9 @@ func DeleteProduct(c *gin.Context) {
 			return
 		}
 	}
+	logging.Audit(c, logging.ActionDelete, logging.EntityProduct, request.ID, before, nil)
 	go ClearFreightCache(request.ID)
+	go search.Sync(request.ID)
 	c.Status(200)
 }
 
@@ -361,14 +394,17 @@ func DeleteImage(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	//	check if the product exists
-	var exist int8
+	//	check if the image exists along with the status and the image count of its product
+	var status string
+	var images uint
 	// strips FileName replace ".webp" with "" to get the id
 	err := database.MysqlInstance.
 		QueryRow(
-			"SELECT 1 FROM product_images WHERE id = UUID_TO_BIN(?) AND product_refer = UUID_TO_BIN(?)",
+			`SELECT p.status, (SELECT COUNT(*) FROM product_images WHERE product_refer = p.id)
+			FROM product_images pi INNER JOIN products p ON p.id = pi.product_refer
+			WHERE pi.id = UUID_TO_BIN(?) AND pi.product_refer = UUID_TO_BIN(?)`,
 			strings.Replace(request.FileName, ".webp", "", 1), request.ProductID,
-		).Scan(&exist)
+		).Scan(&status, &images)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.Status(404)
@@ -378,31 +414,16 @@ func DeleteImage(c *gin.Context) {
 		c.Status(500)
 		return
 	}
-	if exist != 1 {
-		c.Status(404)
+	// a published product must keep at least one image, the same rule as checkPublishable
+	if status == "published" && images <= 1 {
+		c.JSON(409, gin.H{"error": "the last image of a published product can't be deleted, unpublish it first"})
 		return
 	}
-	url := NginxFSBaseUrl + "/handler?file=" + request.FileName
-	req, err := http.NewRequest(http.MethodDelete, url, nil)
-	req.Header.Set("Authorization", NginxFSAuthorization)
-	if err != nil {
+	if err := deleteImage(request.FileName); err != nil {
 		go logging.InsertLog(logging.ERROR, "2-delimg"+err.Error())
 		c.Status(500)
 		return
 	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		go logging.InsertLog(logging.ERROR, "3-delimg"+err.Error())
-		c.Status(500)
-		return
-	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 && res.StatusCode != 404 {
-		go logging.InsertLog(logging.ERROR, "4-delimg"+err.Error())
-		// 404 considered as success as it maybe deleted by other request
-		c.Status(500)
-		return
-	}
 	//	delete the image from product_images
 	_, err = database.MysqlInstance.
 		Exec(
@@ -414,6 +435,13 @@ func DeleteImage(c *gin.Context) {
 		c.Status(500)
 		return
 	}
+	if err := promotePrimaryImage(request.ProductID); err != nil {
+		go logging.InsertLog(logging.ERROR, "6-delimg"+err.Error())
+	}
+	logging.Audit(
+		c, logging.ActionDelete, logging.EntityProductImage, request.FileName,
+		gin.H{"product_id": request.ProductID, "file": request.FileName}, nil,
+	)
 	c.Status(200)
 }
 
@@ -429,6 +457,22 @@ func UpdateProduct(c *gin.Context) {
 	query := "UPDATE products p"
 	var args []interface{}
 	if request.Stock != 0 {
+		// the stock of a product with variants is managed per sku
+		var skuCount int
+		err := database.MysqlInstance.
+			QueryRow(
+				"SELECT COUNT(*) FROM product_skus WHERE product_refer = UUID_TO_BIN(?) AND deleted_at IS NULL",
+				request.ID,
+			).
+			Scan(&skuCount)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+		if skuCount > 1 {
+			c.JSON(409, gin.H{"error": "product has variants, update the stock of each sku instead"})
+			return
+		}
 		query += ", inventories i SET i.quantity = ?, i.updated_at = CURRENT_TIMESTAMP, "
 		args = append(args, request.Stock)
 		somethingToUpdate = true
@@ -518,8 +562,24 @@ func UpdateProduct(c *gin.Context) {
 		args = append(args, request.Height)
 		somethingToUpdate = true
 	}
+	if request.BrandID != nil {
+		if *request.BrandID == 0 {
+			query += ", p.brand_refer = NULL"
+		} else {
+			if err := checkBrand(*request.BrandID); err != nil {
+				brandError(c, err)
+				return
+			}
+			query += ", p.brand_refer = ?"
+			args = append(args, *request.BrandID)
+		}
+		somethingToUpdate = true
+	}
+	if request.Attributes != nil {
+		somethingToUpdate = true
+	}
 	if request.Stock != 0 {
-		query += " WHERE i.product_refer = UUID_TO_BIN(?) AND "
+		query += " WHERE i.sku_refer = (SELECT id FROM product_skus WHERE product_refer = UUID_TO_BIN(?) AND deleted_at IS NULL) AND "
 		args = append(args, request.ID)
 		somethingToUpdate = true
 	} else {
@@ -531,6 +591,7 @@ func UpdateProduct(c *gin.Context) {
 	}
 	query += "p.id = UUID_TO_BIN(?) AND p.deleted_at IS NULL"
 	args = append(args, request.ID)
+	before := logging.Snapshot(productAuditQuery, request.ID)
 	wg.Wait()
 	close(errChan)
 	for err := range errChan {
@@ -543,7 +604,40 @@ func UpdateProduct(c *gin.Context) {
 			return
 		}
 	}
-	res, err := database.MysqlInstance.Exec(query, args...)
+	// the attributes are checked against the new category, every required attribute of it has to be filled
+	var attributes []attributeValue
+	if request.Attributes != nil || request.CategoryID != 0 {
+		categoryId := request.CategoryID
+		if categoryId == 0 {
+			err := database.MysqlInstance.
+				QueryRow(
+					"SELECT category_refer FROM products WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL", request.ID,
+				).
+				Scan(&categoryId)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					c.Status(404)
+					return
+				}
+				c.Status(500)
+				return
+			}
+		}
+		var err error
+		attributes, err = parseAttributes(categoryId, request.Attributes, request.CategoryID != 0)
+		if err != nil {
+			attributeError(c, err, "1-updattr:")
+			return
+		}
+	}
+	// the product, its attributes and its slug are updated together so a failure leaves the product untouched
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(query, args...)
 	if err != nil {
 		if strings.Contains(err.Error(), "Duplicate") {
 			c.JSON(409, gin.H{"error": "Product name already exist"})
@@ -563,7 +657,31 @@ func UpdateProduct(c *gin.Context) {
 		c.Status(404)
 		return
 	}
+	if request.Attributes != nil || request.CategoryID != 0 {
+		if err := saveAttributesTx(tx, request.ID, attributes); err != nil {
+			go logging.InsertLog(logging.ERROR, "2-updattr:"+err.Error())
+			c.Status(500)
+			return
+		}
+	}
+	if request.Name != "" {
+		if err := productSlugs.rename(tx, request.ID, request.Name); err != nil {
+			go logging.InsertLog(logging.ERROR, "3-updslug:"+err.Error())
+			c.Status(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		go logging.InsertLog(logging.ERROR, "4-updprod:"+err.Error())
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityProduct, request.ID, before,
+		logging.Snapshot(productAuditQuery, request.ID),
+	)
 	go ClearFreightCache(request.ID)
+	go search.Sync(request.ID)
 	c.Status(200)
 }
 
@@ -583,53 +701,45 @@ func ClearFreightCache(productID string) {
 	}
 }
 
+// GetProduct return a page of the product table, it accepts the same filters and sorting as the public listing
 func GetProduct(c *gin.Context) {
-	var response []models.HomepageProduct
-	rows, err := database.MysqlInstance.
-		Query(
-			`
-			SELECT
-			    BIN_TO_UUID(p.id) AS id,
-			    p.name,
-			    p.price,
-			    COALESCE(CONCAT(BIN_TO_UUID(pi.id), '.webp'), '') AS image,
-			    p.cumulative_review,
-			    COUNT(oi.id) AS sold_count
-			FROM
-			    products p
-			        LEFT JOIN (
-			        SELECT
-			            id,
-			            product_refer,
-			            ROW_NUMBER() OVER (PARTITION BY product_refer ORDER BY created_at) AS rn
-			        FROM
-			            product_images
-			    ) pi ON p.id = pi.product_refer AND pi.rn = 1
-			        LEFT JOIN
-			    order_items oi ON oi.product_refer = p.id
-			        LEFT JOIN
-			    orders o ON oi.order_refer = o.id
-			        AND (o.transaction_status = 'settlement'
-			            OR o.transaction_status = 'capture')
-			WHERE
-			    p.deleted_at IS NULL
-			GROUP BY
-			    p.id,
-			    image`,
-		)
-	if err != nil {
-		c.Status(500)
-		return
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var product models.HomepageProduct
-		err := rows.Scan(&product.ID, &product.Name, &product.Price, &product.ImageUrl, &product.Rating, &product.Sold)
+	// the staff can see the detail of the products which are not published yet
+	var requestID models.APICommonQueryUUID
+	if err := c.ShouldBindQuery(&requestID); err == nil {
+		response, err := global.ProductDetail(requestID.ID, false)
 		if err != nil {
+			if err == sql.ErrNoRows {
+				c.Status(404)
+				return
+			}
+			go logging.InsertLog(logging.ERROR, "0-getprod:"+err.Error())
 			c.Status(500)
 			return
 		}
-		response = append(response, product)
+		c.JSON(200, response)
+		return
+	}
+	if c.Query("id") != "" {
+		c.Status(400)
+		return
+	}
+	var request models.CatalogQuery
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	request.Attributes = c.QueryMap("attr")
+	switch request.Status = c.Query("status"); request.Status {
+	case "", "draft", "published", "archived":
+	default:
+		c.Status(400)
+		return
+	}
+	products, total, _, err := global.ListProducts(request)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-getprod:"+err.Error())
+		c.Status(500)
+		return
 	}
-	c.JSON(200, response)
+	c.JSON(200, models.NewPaginatedResponse(products, request.PageQuery, total))
 }
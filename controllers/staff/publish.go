@@ -0,0 +1,220 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package staff
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/search"
+	"github.com/gin-gonic/gin"
+)
+
+// errPublish is the reason a product can't be published or scheduled, its message is shown to the staff
+type errPublish struct {
+	message string
+}
+
+func (e errPublish) Error() string {
+	return e.message
+}
+
+// UpdateProductStatus change the status of a product and its schedule, a product is only published when it is
+// ready to be sold
+func UpdateProductStatus(c *gin.Context) {
+	var request models.ProductStatusUpdate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	now := time.Now()
+	switch {
+	case request.PublishAt != nil && request.Status != "draft":
+		c.JSON(400, gin.H{"error": "only a draft can be scheduled to be published"})
+		return
+	case request.PublishAt != nil && !request.PublishAt.After(now):
+		c.JSON(400, gin.H{"error": "publish_at must be in the future"})
+		return
+	case request.UnpublishAt != nil && request.Status == "archived":
+		c.JSON(400, gin.H{"error": "an archived product can't be scheduled to be unpublished"})
+		return
+	case request.UnpublishAt != nil && !request.UnpublishAt.After(now):
+		c.JSON(400, gin.H{"error": "unpublish_at must be in the future"})
+		return
+	case request.PublishAt != nil && request.UnpublishAt != nil && !request.UnpublishAt.After(*request.PublishAt):
+		c.JSON(400, gin.H{"error": "unpublish_at must be after publish_at"})
+		return
+	}
+	var exist int8
+	err := database.MysqlInstance.
+		QueryRow("SELECT 1 FROM products WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL", request.ID).
+		Scan(&exist)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Status(404)
+			return
+		}
+		go logging.InsertLog(logging.ERROR, "1-status:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if request.Status == "published" {
+		if err := checkPublishable(request.ID); err != nil {
+			publishError(c, err)
+			return
+		}
+	}
+	before := logging.Snapshot(productAuditQuery, request.ID)
+	_, err = database.MysqlInstance.Exec(
+		`UPDATE products SET status = ?, publish_at = ?, unpublish_at = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = UUID_TO_BIN(?)`,
+		request.Status, request.PublishAt, request.UnpublishAt, request.ID,
+	)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "2-status:"+err.Error())
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityProduct, request.ID, before,
+		logging.Snapshot(productAuditQuery, request.ID),
+	)
+	go search.Sync(request.ID)
+	c.Status(200)
+}
+
+// checkPublishable return an errPublish when the product doesn't have an image or any stock yet
+func checkPublishable(productId string) error {
+	var images, stock uint
+	err := database.MysqlInstance.QueryRow(
+		`
+		SELECT (SELECT COUNT(*) FROM product_images WHERE product_refer = p.id),
+		       (SELECT COALESCE(SUM(i.quantity), 0) FROM inventories i, product_skus s
+		        WHERE i.sku_refer = s.id AND s.product_refer = p.id AND s.deleted_at IS NULL)
+		FROM products p WHERE p.id = UUID_TO_BIN(?)`, productId,
+	).Scan(&images, &stock)
+	if err != nil {
+		return err
+	}
+	if images == 0 {
+		return errPublish{"the product needs at least one image to be published"}
+	}
+	if stock == 0 {
+		return errPublish{"the product needs stock to be published"}
+	}
+	return nil
+}
+
+func publishError(c *gin.Context, err error) {
+	var invalid errPublish
+	if errors.As(err, &invalid) {
+		c.JSON(409, gin.H{"error": invalid.Error()})
+		return
+	}
+	go logging.InsertLog(logging.ERROR, "publish:"+err.Error())
+	c.Status(500)
+}
+
+// WatchProductSchedule apply the publish_at and unpublish_at of the products every interval, it is safe to run on
+// every instance as a schedule is only applied once. The other instances using the memory search engine pick the
+// change up with search.WatchIndex
+func WatchProductSchedule(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		if err := applyProductSchedule(time.Now()); err != nil {
+			logging.InsertLog(logging.ERROR, "product schedule:"+err.Error())
+		}
+	}
+}
+
+// applyProductSchedule publish the drafts whose publish_at has passed and archive the published products whose
+// unpublish_at has passed. A draft which isn't ready to be sold stays a draft and its publish_at is dropped
+func applyProductSchedule(now time.Time) error {
+	due, err := scheduledProducts(
+		"SELECT BIN_TO_UUID(id) FROM products WHERE publish_at <= ? AND status = 'draft' AND deleted_at IS NULL", now,
+	)
+	if err != nil {
+		return err
+	}
+	for _, id := range due {
+		status := "published"
+		if err := checkPublishable(id); err != nil {
+			var invalid errPublish
+			if !errors.As(err, &invalid) {
+				return err
+			}
+			logging.InsertLog(logging.WARN, "scheduled publish of "+id+" is skipped: "+invalid.Error())
+			status = "draft"
+		}
+		res, err := database.MysqlInstance.Exec(
+			`UPDATE products SET status = ?, publish_at = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = UUID_TO_BIN(?) AND publish_at <= ? AND status = 'draft'`, status, id, now,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected > 0 && status == "published" {
+			search.Sync(id)
+		}
+	}
+
+	due, err = scheduledProducts(
+		"SELECT BIN_TO_UUID(id) FROM products WHERE unpublish_at <= ? AND status = 'published' AND deleted_at IS NULL",
+		now,
+	)
+	if err != nil {
+		return err
+	}
+	for _, id := range due {
+		res, err := database.MysqlInstance.Exec(
+			`UPDATE products SET status = 'archived', unpublish_at = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = UUID_TO_BIN(?) AND unpublish_at <= ? AND status = 'published'`, id, now,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected > 0 {
+			search.Sync(id)
+		}
+	}
+	return nil
+}
+
+func scheduledProducts(query string, now time.Time) ([]string, error) {
+	rows, err := database.MysqlInstance.Query(query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
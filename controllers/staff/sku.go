@@ -0,0 +1,555 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package staff
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/Tus1688/openmerce-backend/controllers/global"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/search"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// skuAuditQuery is the state of a sku which is recorded on the audit trail
+const skuAuditQuery = `
+	SELECT BIN_TO_UUID(s.product_refer) AS product_id, s.sku, s.gtin, s.price, s.weight, s.length, s.width, s.height,
+	       i.quantity AS stock, (
+	           SELECT GROUP_CONCAT(CONCAT(o.name, ': ', v.value) ORDER BY o.position, o.id SEPARATOR ', ')
+	           FROM product_sku_values sv
+	               JOIN product_option_values v ON v.id = sv.option_value_refer
+	               JOIN product_options o ON o.id = v.option_refer
+	           WHERE sv.sku_refer = s.id
+	       ) AS options, s.deleted_at
+	FROM product_skus s LEFT JOIN inventories i ON i.sku_refer = s.id WHERE s.id = UUID_TO_BIN(?)`
+
+var (
+	errSkuProductNotFound = errors.New("product not found")
+	errSkuOptionMismatch  = errors.New("options must have the same names as the other variants of the product")
+	errSkuDuplicate       = errors.New("a variant with the same options already exists")
+)
+
+// GetProductSku return the variant matrix of a product
+func GetProductSku(c *gin.Context) {
+	var request models.APICommonQueryUUID
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	options, skus, err := global.ProductVariants(request.ID)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-getsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if len(skus) == 0 {
+		c.Status(404)
+		return
+	}
+	c.JSON(200, gin.H{"options": options, "skus": skus})
+}
+
+// AddProductSku add a new variant into an existing product. The first variant of a product takes over its default sku
+// which has no option, so the stock, the cart items and the orders of the default sku are kept along with its id.
+// Its code, gtin, pricing and stock are only replaced when they are given
+func AddProductSku(c *gin.Context) {
+	var request models.ProductSkuCreate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	if request.Gtin != "" && !models.ValidGtin(request.Gtin) {
+		c.JSON(400, gin.H{"error": "invalid gtin"})
+		return
+	}
+	id := uuid.New().String()
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-addsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	defaultSku, err := optionlessSku(tx, request.ProductID)
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "7-addsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	var before map[string]interface{}
+	if defaultSku != "" {
+		id = defaultSku
+		before = logging.Snapshot(skuAuditQuery, id)
+	}
+	if err := checkSkuOptions(tx, request.ProductID, id, request.Options); err != nil {
+		skuOptionError(c, err, "2-addsku:")
+		return
+	}
+	var skuCode, gtin interface{}
+	if request.Sku != "" {
+		skuCode = request.Sku
+	}
+	if request.Gtin != "" {
+		gtin = request.Gtin
+	}
+	if defaultSku != "" {
+		_, err = tx.Exec(
+			`UPDATE product_skus SET sku = COALESCE(?, sku), gtin = COALESCE(?, gtin), price = COALESCE(?, price),
+			weight = COALESCE(?, weight), length = COALESCE(?, length), width = COALESCE(?, width),
+			height = COALESCE(?, height), updated_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?)`,
+			skuCode, gtin, request.Price, request.Weight, request.Length, request.Width, request.Height, id,
+		)
+	} else {
+		_, err = tx.Exec(
+			`INSERT INTO product_skus (id, product_refer, sku, gtin, price, weight, length, width, height)
+			VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?, ?)`,
+			id, request.ProductID, skuCode, gtin, request.Price, request.Weight, request.Length, request.Width,
+			request.Height,
+		)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			c.JSON(409, gin.H{"error": "SKU already exists"})
+			return
+		}
+		go logging.InsertLog(logging.ERROR, "3-addsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if defaultSku == "" {
+		_, err = tx.Exec(
+			"INSERT INTO inventories (product_refer, sku_refer, quantity, updated_at) VALUE (UUID_TO_BIN(?), UUID_TO_BIN(?), ?, CURRENT_TIMESTAMP)",
+			request.ProductID, id, request.Stock,
+		)
+	} else if request.Stock != 0 {
+		_, err = tx.Exec(
+			"UPDATE inventories SET quantity = ?, updated_at = CURRENT_TIMESTAMP WHERE sku_refer = UUID_TO_BIN(?)",
+			request.Stock, id,
+		)
+	}
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "4-addsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if err := setSkuOptions(tx, request.ProductID, id, request.Options); err != nil {
+		go logging.InsertLog(logging.ERROR, "5-addsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		go logging.InsertLog(logging.ERROR, "6-addsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if defaultSku != "" {
+		logging.Audit(
+			c, logging.ActionUpdate, logging.EntityProductSku, id, before, logging.Snapshot(skuAuditQuery, id),
+		)
+	} else {
+		logging.Audit(c, logging.ActionCreate, logging.EntityProductSku, id, nil, logging.Snapshot(skuAuditQuery, id))
+	}
+	go ClearFreightCache(request.ProductID)
+	go search.Sync(request.ProductID)
+	c.JSON(201, gin.H{"id": id})
+}
+
+// optionlessSku lock the skus of the product and return the id of its default sku when it is the only sku and has no
+// option, otherwise an empty string
+func optionlessSku(tx *sql.Tx, productId string) (string, error) {
+	rows, err := tx.Query(
+		`SELECT BIN_TO_UUID(s.id), EXISTS(SELECT 1 FROM product_sku_values sv WHERE sv.sku_refer = s.id)
+		FROM product_skus s WHERE s.product_refer = UUID_TO_BIN(?) AND s.deleted_at IS NULL FOR UPDATE`,
+		productId,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var ids []string
+	var hasOptions bool
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id, &hasOptions); err != nil {
+			return "", err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(ids) != 1 || hasOptions {
+		return "", nil
+	}
+	return ids[0], nil
+}
+
+// UpdateProductSku update the code, options, pricing, dimension or stock of a sku
+func UpdateProductSku(c *gin.Context) {
+	var request models.ProductSkuUpdate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	if request.Gtin != nil && *request.Gtin != "" && !models.ValidGtin(*request.Gtin) {
+		c.JSON(400, gin.H{"error": "invalid gtin"})
+		return
+	}
+	var productId string
+	err := database.MysqlInstance.
+		QueryRow(
+			"SELECT BIN_TO_UUID(product_refer) FROM product_skus WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL",
+			request.ID,
+		).
+		Scan(&productId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Status(404)
+			return
+		}
+		go logging.InsertLog(logging.ERROR, "1-updsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	query := "UPDATE product_skus SET updated_at = CURRENT_TIMESTAMP"
+	var args []interface{}
+	var somethingToUpdate bool
+	if request.Sku != nil {
+		query += ", sku = ?"
+		if *request.Sku == "" {
+			args = append(args, nil)
+		} else {
+			args = append(args, *request.Sku)
+		}
+		somethingToUpdate = true
+	}
+	if request.Gtin != nil {
+		query += ", gtin = ?"
+		if *request.Gtin == "" {
+			args = append(args, nil)
+		} else {
+			args = append(args, *request.Gtin)
+		}
+		somethingToUpdate = true
+	}
+	if request.Price != nil {
+		query += ", price = ?"
+		args = append(args, *request.Price)
+		somethingToUpdate = true
+	}
+	if request.Weight != nil {
+		query += ", weight = ?"
+		args = append(args, *request.Weight)
+		somethingToUpdate = true
+	}
+	if request.Length != nil {
+		query += ", length = ?"
+		args = append(args, *request.Length)
+		somethingToUpdate = true
+	}
+	if request.Width != nil {
+		query += ", width = ?"
+		args = append(args, *request.Width)
+		somethingToUpdate = true
+	}
+	if request.Height != nil {
+		query += ", height = ?"
+		args = append(args, *request.Height)
+		somethingToUpdate = true
+	}
+	if !somethingToUpdate && request.Options == nil && request.Stock == nil {
+		c.Status(400)
+		return
+	}
+	query += " WHERE id = UUID_TO_BIN(?)"
+	args = append(args, request.ID)
+
+	before := logging.Snapshot(skuAuditQuery, request.ID)
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		go logging.InsertLog(logging.ERROR, "2-updsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	defer tx.Rollback()
+	if request.Options != nil {
+		if err := checkSkuOptions(tx, productId, request.ID, request.Options); err != nil {
+			skuOptionError(c, err, "3-updsku:")
+			return
+		}
+		if err := setSkuOptions(tx, productId, request.ID, request.Options); err != nil {
+			go logging.InsertLog(logging.ERROR, "4-updsku:"+err.Error())
+			c.Status(500)
+			return
+		}
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			c.JSON(409, gin.H{"error": "SKU already exists"})
+			return
+		}
+		go logging.InsertLog(logging.ERROR, "5-updsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if request.Stock != nil {
+		_, err := tx.Exec(
+			"UPDATE inventories SET quantity = ?, updated_at = CURRENT_TIMESTAMP WHERE sku_refer = UUID_TO_BIN(?)",
+			*request.Stock, request.ID,
+		)
+		if err != nil {
+			go logging.InsertLog(logging.ERROR, "6-updsku:"+err.Error())
+			c.Status(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		go logging.InsertLog(logging.ERROR, "7-updsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	logging.Audit(
+		c, logging.ActionUpdate, logging.EntityProductSku, request.ID, before, logging.Snapshot(skuAuditQuery, request.ID),
+	)
+	go ClearFreightCache(productId)
+	go search.Sync(productId)
+	c.Status(200)
+}
+
+// DeleteProductSku remove a variant of a product, the last sku of a product can't be deleted
+func DeleteProductSku(c *gin.Context) {
+	var request models.APICommonQueryUUID
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.Status(400)
+		return
+	}
+	var productId string
+	var skuCount int
+	err := database.MysqlInstance.
+		QueryRow(
+			`SELECT BIN_TO_UUID(s.product_refer), (SELECT COUNT(*) FROM product_skus o WHERE o.product_refer = s.product_refer AND o.deleted_at IS NULL)
+			FROM product_skus s WHERE s.id = UUID_TO_BIN(?) AND s.deleted_at IS NULL`,
+			request.ID,
+		).
+		Scan(&productId, &skuCount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Status(404)
+			return
+		}
+		go logging.InsertLog(logging.ERROR, "1-delsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	if skuCount <= 1 {
+		c.JSON(409, gin.H{"error": "a product must have at least one sku"})
+		return
+	}
+	before := logging.Snapshot(skuAuditQuery, request.ID)
+	if err := deleteSkus(productId, request.ID); err != nil {
+		go logging.InsertLog(logging.ERROR, "2-delsku:"+err.Error())
+		c.Status(500)
+		return
+	}
+	logging.Audit(c, logging.ActionDelete, logging.EntityProductSku, request.ID, before, nil)
+	go ClearFreightCache(productId)
+	go search.Sync(productId)
+	c.Status(200)
+}
+
+// deleteSkus soft delete a sku of the product or every sku when skuId is empty, the stock is emptied and the sku is
+// removed from the carts so it can't be bought anymore
+func deleteSkus(productId string, skuId string) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := deleteSkusTx(tx, productId, skuId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteSkusTx is deleteSkus within the transaction of the caller
+func deleteSkusTx(tx *sql.Tx, productId string, skuId string) error {
+	filter := "product_refer = UUID_TO_BIN(?)"
+	args := []interface{}{productId}
+	if skuId != "" {
+		filter += " AND id = UUID_TO_BIN(?)"
+		args = append(args, skuId)
+	}
+	// the sku code is released so it can be reused by another sku
+	_, err := tx.Exec(
+		"UPDATE product_skus SET sku = NULL, deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL AND "+filter, args...,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"UPDATE inventories SET quantity = 0, updated_at = CURRENT_TIMESTAMP WHERE sku_refer IN (SELECT id FROM product_skus WHERE "+filter+")",
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"DELETE FROM cart_items WHERE sku_refer IN (SELECT id FROM product_skus WHERE "+filter+")", args...,
+	)
+	return err
+}
+
+// checkSkuOptions lock the product and make sure the options of a sku have the same names as the other skus of the
+// product and don't duplicate any of them
+func checkSkuOptions(tx *sql.Tx, productId string, skuId string, options map[string]string) error {
+	var exist int8
+	err := tx.QueryRow(
+		"SELECT 1 FROM products WHERE id = UUID_TO_BIN(?) AND deleted_at IS NULL FOR UPDATE", productId,
+	).Scan(&exist)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errSkuProductNotFound
+		}
+		return err
+	}
+	rows, err := tx.Query(
+		`SELECT BIN_TO_UUID(s.id), COALESCE(o.name, ''), COALESCE(v.value, '')
+		FROM product_skus s
+		         LEFT JOIN product_sku_values sv ON sv.sku_refer = s.id
+		         LEFT JOIN product_option_values v ON v.id = sv.option_value_refer
+		         LEFT JOIN product_options o ON o.id = v.option_refer
+		WHERE s.product_refer = UUID_TO_BIN(?) AND s.deleted_at IS NULL AND s.id <> UUID_TO_BIN(?)`,
+		productId, skuId,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	others := map[string]map[string]string{}
+	for rows.Next() {
+		var id, name, value string
+		if err := rows.Scan(&id, &name, &value); err != nil {
+			return err
+		}
+		if others[id] == nil {
+			others[id] = map[string]string{}
+		}
+		if name != "" {
+			others[id][name] = value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, other := range others {
+		if len(other) != len(options) {
+			return errSkuOptionMismatch
+		}
+		same := true
+		for name, value := range options {
+			otherValue, ok := other[name]
+			if !ok {
+				return errSkuOptionMismatch
+			}
+			if otherValue != value {
+				same = false
+			}
+		}
+		if same {
+			return errSkuDuplicate
+		}
+	}
+	return nil
+}
+
+// setSkuOptions replace the option values of a sku, the options and their values are created when they don't exist
+func setSkuOptions(tx *sql.Tx, productId string, skuId string, options map[string]string) error {
+	if _, err := tx.Exec("DELETE FROM product_sku_values WHERE sku_refer = UUID_TO_BIN(?)", skuId); err != nil {
+		return err
+	}
+	var position int
+	err := tx.QueryRow(
+		"SELECT COUNT(*) FROM product_options WHERE product_refer = UUID_TO_BIN(?)", productId,
+	).Scan(&position)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// LAST_INSERT_ID(id) makes LastInsertId return the existing id on duplicate
+		res, err := tx.Exec(
+			`INSERT INTO product_options (product_refer, name, position) VALUES (UUID_TO_BIN(?), ?, ?)
+			ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)`,
+			productId, name, position,
+		)
+		if err != nil {
+			return err
+		}
+		optionId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 1 {
+			position++
+		}
+		res, err = tx.Exec(
+			`INSERT INTO product_option_values (option_refer, value) VALUES (?, ?)
+			ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)`,
+			optionId, options[name],
+		)
+		if err != nil {
+			return err
+		}
+		valueId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"INSERT INTO product_sku_values (sku_refer, option_value_refer) VALUES (UUID_TO_BIN(?), ?)", skuId, valueId,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func skuOptionError(c *gin.Context, err error, code string) {
+	switch err {
+	case errSkuProductNotFound:
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errSkuOptionMismatch, errSkuDuplicate:
+		c.JSON(409, gin.H{"error": err.Error()})
+	default:
+		go logging.InsertLog(logging.ERROR, code+err.Error())
+		c.Status(500)
+	}
+}
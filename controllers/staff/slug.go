@@ -0,0 +1,112 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package staff
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// maxSlugSuffix bound the search of a free slug, a name with that many duplicates is surely a mistake
+const maxSlugSuffix = 1000
+
+// slugTable is where the slugs of an entity and its previous slugs are kept
+type slugTable struct {
+	table    string
+	history  string
+	refer    string
+	idParam  string
+	fallback string
+}
+
+var (
+	productSlugs  = slugTable{"products", "product_slug_history", "product_refer", "UUID_TO_BIN(?)", "product"}
+	categorySlugs = slugTable{"categories", "category_slug_history", "category_refer", "?", "category"}
+)
+
+// unique return the slug of the name which isn't used by another entity, either as its slug or as one of its previous
+// slugs, a number is appended when it is taken e.g. "red-shoe-2"
+func (t slugTable) unique(tx *sql.Tx, name string, id interface{}) (string, error) {
+	base := slugify(name)
+	if base == "" {
+		base = t.fallback
+	}
+	for i := 1; i <= maxSlugSuffix; i++ {
+		slug := base
+		if i > 1 {
+			slug += "-" + strconv.Itoa(i)
+		}
+		var taken bool
+		err := tx.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM "+t.table+" WHERE slug = ? AND id <> "+t.idParam+") OR "+
+				"EXISTS(SELECT 1 FROM "+t.history+" WHERE slug = ? AND "+t.refer+" <> "+t.idParam+")",
+			slug, id, slug, id,
+		).Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return slug, nil
+		}
+	}
+	return "", errors.New("no free slug for " + base)
+}
+
+// rename give the entity the slug of its new name within the transaction which renames it, the current slug is kept
+// in the history so the old urls can be redirected. Nothing changes when the new name gives the same slug
+func (t slugTable) rename(tx *sql.Tx, id interface{}, name string) error {
+	var current string
+	err := tx.QueryRow("SELECT slug FROM "+t.table+" WHERE id = "+t.idParam+" FOR UPDATE", id).Scan(&current)
+	if err != nil {
+		return err
+	}
+	base := slugify(name)
+	if base == "" {
+		base = t.fallback
+	}
+	if current == base {
+		return nil
+	}
+	if suffix, ok := strings.CutPrefix(current, base+"-"); ok {
+		if _, err := strconv.Atoi(suffix); err == nil {
+			return nil
+		}
+	}
+	slug, err := t.unique(tx, name, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO "+t.history+" (slug, "+t.refer+") VALUES (?, "+t.idParam+")", current, id,
+	)
+	if err != nil {
+		return err
+	}
+	// going back to a previous name takes its slug back from the history
+	_, err = tx.Exec("DELETE FROM "+t.history+" WHERE slug = ?", slug)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE "+t.table+" SET slug = ? WHERE id = "+t.idParam, slug, id)
+	return err
+}
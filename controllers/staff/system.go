@@ -21,15 +21,15 @@
 package staff
 
 import (
-	"bytes"
 	"database/sql"
-	"encoding/json"
 	"io"
 	"mime/multipart"
-	"net/http"
+	"strconv"
 
 	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
 	"github.com/Tus1688/openmerce-backend/models"
+	"github.com/Tus1688/openmerce-backend/service/storage"
 	"github.com/gin-gonic/gin"
 )
 
@@ -39,60 +39,29 @@ func AddHomeBanner(c *gin.Context) {
 		c.Status(400)
 		return
 	}
-	url := NginxFSBaseUrl + "/handler"
-	image, err := request.Picture.Open()
+	file, err := uploadImage(request.Picture)
 	if err != nil {
+		go logging.InsertLog(logging.ERROR, "1-addbanner:"+err.Error())
 		c.Status(500)
 		return
 	}
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("picture", request.Picture.Filename)
-	if err != nil {
-		c.Status(500)
-		return
-	}
-	if _, err := io.Copy(part, image); err != nil {
-		c.Status(500)
-		return
-	}
-	if err := writer.Close(); err != nil {
-		c.Status(500)
-		return
-	}
-	req, err := http.NewRequest("POST", url, body)
+	// insert to database
+	result, err := database.MysqlInstance.Exec(
+		"INSERT INTO homepage_banner (file_name, href) VALUES (?, ?)", file, request.Href,
+	)
 	if err != nil {
 		c.Status(500)
 		return
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", NginxFSAuthorization)
-	res, err := http.DefaultClient.Do(req)
+	id, err := result.LastInsertId()
 	if err != nil {
 		c.Status(500)
 		return
 	}
-	defer res.Body.Close()
-	if res.StatusCode != 201 {
-		c.Status(500)
-		return
-	}
-	// we are going to get "id": uuid from the response
-	var response struct {
-		File string `json:"file"`
-	}
-	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
-		c.Status(500)
-		return
-	}
-	// insert to database
-	_, err = database.MysqlInstance.Exec(
-		"INSERT INTO homepage_banner (file_name, href) VALUES (?, ?)", response.File, request.Href,
+	logging.Audit(
+		c, logging.ActionCreate, logging.EntityBanner, strconv.FormatInt(id, 10), nil,
+		gin.H{"file_name": file, "href": request.Href},
 	)
-	if err != nil {
-		c.Status(500)
-		return
-	}
 	c.Status(201)
 }
 
@@ -103,10 +72,10 @@ func DeleteHomeBanner(c *gin.Context) {
 		return
 	}
 	// get file name from database
-	var fileName string
+	var fileName, href string
 	err := database.MysqlInstance.
-		QueryRow("SELECT file_name FROM homepage_banner WHERE id = ?", request.ID).
-		Scan(&fileName)
+		QueryRow("SELECT file_name, href FROM homepage_banner WHERE id = ?", request.ID).
+		Scan(&fileName, &href)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.Status(404)
@@ -117,21 +86,8 @@ func DeleteHomeBanner(c *gin.Context) {
 	}
 
 	// delete from the image server
-	url := NginxFSBaseUrl + "/handler?file=" + fileName
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		c.Status(500)
-		return
-	}
-	req.Header.Set("Authorization", NginxFSAuthorization)
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		c.Status(500)
-		return
-	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 && res.StatusCode != 404 {
-		// 404 considered as success because the file is already deleted
+	if err := deleteImage(fileName); err != nil {
+		go logging.InsertLog(logging.ERROR, "1-delbanner:"+err.Error())
 		c.Status(500)
 		return
 	}
@@ -141,5 +97,28 @@ func DeleteHomeBanner(c *gin.Context) {
 		c.Status(500)
 		return
 	}
+	logging.Audit(
+		c, logging.ActionDelete, logging.EntityBanner, strconv.Itoa(request.ID),
+		gin.H{"file_name": fileName, "href": href}, nil,
+	)
 	c.Status(200)
 }
+
+// uploadImage send the picture to the storage and return the file name given by it
+func uploadImage(picture *multipart.FileHeader) (string, error) {
+	file, err := picture.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	return storage.DefaultStore.Upload(picture.Filename, data)
+}
+
+// deleteImage remove the file from the storage, a file which is already gone is not an error
+func deleteImage(fileName string) error {
+	return storage.DefaultStore.Delete(fileName)
+}
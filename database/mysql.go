@@ -26,6 +26,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/Tus1688/openmerce-backend/auth"
 	"golang.org/x/crypto/bcrypt"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -39,6 +40,9 @@ const (
 
 var MysqlInstance *sql.DB
 
+// SuperAdminRole is the role of the admin account which is created by InitAdminAccount
+const SuperAdminRole = "superadmin"
+
 func NewMysql() error {
 	dbHost := os.Getenv("DB_HOST")
 	dbPort := os.Getenv("DB_PORT")
@@ -77,8 +81,32 @@ func InitAdminAccount() error {
 	}
 	//	insert into staff table
 	_, err = MysqlInstance.Exec(
-		"INSERT INTO staffs (username, hashed_password, name, fin_user, inv_user, sys_admin) VALUES (?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE hashed_password=VALUES(hashed_password), name=VALUES(name), fin_user=VALUES(fin_user), inv_user=VALUES(inv_user), sys_admin=VALUES(sys_admin)",
-		username, string(bytes), "superadmin", true, true, true,
+		"INSERT INTO staffs (username, hashed_password, name) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE hashed_password=VALUES(hashed_password), name=VALUES(name)",
+		username, string(bytes), "superadmin",
+	)
+	if err != nil {
+		return err
+	}
+	// the super admin role always has every permission, including the ones added after the role is created
+	_, err = MysqlInstance.Exec(
+		"INSERT INTO roles (name, description) VALUES (?, ?) ON DUPLICATE KEY UPDATE description=VALUES(description)",
+		SuperAdminRole, "every permission",
+	)
+	if err != nil {
+		return err
+	}
+	for _, permission := range auth.Permissions {
+		_, err = MysqlInstance.Exec(
+			"INSERT IGNORE INTO role_permissions (role_refer, permission) SELECT id, ? FROM roles WHERE name = ?",
+			permission, SuperAdminRole,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = MysqlInstance.Exec(
+		"INSERT IGNORE INTO staff_roles (staff_refer, role_refer) SELECT s.id, r.id FROM staffs s, roles r WHERE s.username = ? AND r.name = ?",
+		username, SuperAdminRole,
 	)
 	if err != nil {
 		return err
@@ -30,17 +30,28 @@ import (
 /*
 0 for awaiting email verification (email, verification code) (ttl: 15 minutes)
 1 for refresh token customer key: refresh_token  value: JSON of user-agent and id (ttl: 14 day??)
-2 for refresh token staff key: refresh_token  value: JSON of user-agent, id, username, fin_user, inv_user, sys_admin (ttl: 14 day??)
+1 also for the sessions of a customer (ttl: the refresh token lifetime): key: sessions:customer_id value: set of refresh_token
+2 for refresh token staff key: refresh_token  value: JSON of user-agent, id, username (ttl: 14 day??)
 3 for customer_cart counts (ttl: 14 day) key: customer_id value: counts
 4 for area suggestion result for global (ttl: 30 day) key: area_id value: JSON of area response
 5 for get rates by product result for global (ttl: 10 day): key: product_id_area_id value: JSON of freight response
 6 for total sold by product for global (ttl: 10 day): key: product_id value: total sold
+7 for blacklisted email domain lookup (ttl: 1 day): key: domain value: 1 (blacklisted) or 0 (allowed)
+8 for oidc authorization state (ttl: 10 minutes): key: state value: JSON of provider, nonce, customer_id, remember_me
+9 for customer email change and phone verification (ttl: 10 minutes): key: email:customer_id or phone:customer_id value: JSON of target, code
+9 also for the checks of a pending verification (ttl: 10 minutes): key: email:customer_id:attempts or phone:customer_id:attempts value: count
+10 for rate limit (ttl: the window of the rule): key: rule:subject (e.g. freight:ip:1.2.3.4, api_key:1) value: sorted set of request timestamps
+10 also for captcha challenge thresholds (ttl: the window of the rule): key: challenge:rule[:ip] value: attempt count
+11 for product search suggestion (ttl: 1 hour): key: suggest:query value: JSON of suggestion response
+11 also for popular search queries: key: popular_queries value: sorted set of query by search count
+12 for generated sitemap and product feeds (no ttl, regenerated every FEED_INTERVAL): key: file name e.g. google.xml value: content
+12 also for the generation time of the feeds: key: generated_at value: RFC3339 time
 */
 var RedisInstance []*redis.Client
 var ctx = context.Background()
 
 func NewRedis() error {
-	for i := 0; i < 7; i++ {
+	for i := 0; i < 13; i++ {
 		// create new redis client
 		addr := os.Getenv("REDIS_HOST") + ":" + os.Getenv("REDIS_PORT")
 		client := redis.NewClient(
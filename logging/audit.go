@@ -0,0 +1,177 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package logging
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"reflect"
+
+	"github.com/Tus1688/openmerce-backend/auth"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+const (
+	EntityProduct           = "product"
+	EntityProductImage      = "product_image"
+	EntityProductSku        = "product_sku"
+	EntityCategoryAttribute = "category_attribute"
+	EntityCategory          = "category"
+	EntityBrand             = "brand"
+	EntityOrder             = "order"
+	EntityBanner            = "banner"
+	EntityBlacklistDomain   = "blacklist_domain"
+	EntityStaff             = "staff"
+	EntityRole              = "role"
+	EntityApiKey            = "api_key"
+)
+
+// redactedFields are never written to the audit trail, only the fact that they changed
+var redactedFields = map[string]bool{"password": true, "hashed_password": true}
+
+// Audit record a staff mutation into audit_logs, before and after are reduced to the fields that changed.
+// before is nil on create and after is nil on delete
+func Audit(c *gin.Context, action string, entity string, entityId string, before interface{}, after interface{}) {
+	var staffId uint
+	var apiKeyId sql.NullInt64
+	if claims := auth.StaffClaims(c); claims != nil {
+		staffId = claims.Id
+		apiKeyId = sql.NullInt64{Int64: int64(claims.ApiKey), Valid: claims.ApiKey != 0}
+	}
+	beforeMap, afterMap := diff(toMap(before), toMap(after))
+	beforeJson, err := marshalNullable(beforeMap)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	afterJson, err := marshalNullable(afterMap)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	_, err = database.MysqlInstance.Exec(
+		`INSERT INTO audit_logs (staff_refer, api_key_refer, action, entity, entity_id, before_value, after_value, ip_address)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		staffId, apiKeyId, action, entity, entityId, beforeJson, afterJson, c.ClientIP(),
+	)
+	if err != nil {
+		go InsertLog(ERROR, "audit:"+err.Error())
+	}
+}
+
+// Snapshot return the first row of the query as a map of column name to value, it is used to capture the state of
+// an entity before and after a mutation
+func Snapshot(query string, args ...interface{}) map[string]interface{} {
+	rows, err := database.MysqlInstance.Query(query, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil || !rows.Next() {
+		return nil
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil
+	}
+	snapshot := map[string]interface{}{}
+	for i, column := range columns {
+		if b, ok := values[i].([]byte); ok {
+			snapshot[column] = string(b)
+			continue
+		}
+		snapshot[column] = values[i]
+	}
+	return snapshot
+}
+
+// toMap convert a struct or map into a generic map by its json representation
+func toMap(value interface{}) map[string]interface{} {
+	if value == nil || reflect.ValueOf(value).Kind() == reflect.Map && reflect.ValueOf(value).IsNil() {
+		return nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil
+	}
+	return result
+}
+
+// diff keep only the fields which are different between before and after, when one of them is nil the other is
+// returned whole
+func diff(before map[string]interface{}, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	if before == nil || after == nil {
+		return redact(before), redact(after)
+	}
+	changedBefore := map[string]interface{}{}
+	changedAfter := map[string]interface{}{}
+	for key, value := range before {
+		if afterValue, ok := after[key]; !ok || !reflect.DeepEqual(value, afterValue) {
+			changedBefore[key] = value
+			if ok {
+				changedAfter[key] = afterValue
+			}
+		}
+	}
+	for key, value := range after {
+		if _, ok := before[key]; !ok {
+			changedAfter[key] = value
+		}
+	}
+	return redact(changedBefore), redact(changedAfter)
+}
+
+func redact(value map[string]interface{}) map[string]interface{} {
+	for key := range value {
+		if redactedFields[key] {
+			value[key] = "[redacted]"
+		}
+	}
+	return value
+}
+
+func marshalNullable(value map[string]interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
@@ -22,8 +22,12 @@ package main
 
 import (
 	"encoding/base64"
+	"flag"
+	"io"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/Tus1688/openmerce-backend/auth"
 	authControllers "github.com/Tus1688/openmerce-backend/controllers/auth"
@@ -32,14 +36,31 @@ import (
 	staffControllers "github.com/Tus1688/openmerce-backend/controllers/staff"
 	"github.com/Tus1688/openmerce-backend/database"
 	"github.com/Tus1688/openmerce-backend/middlewares"
+	"github.com/Tus1688/openmerce-backend/service/captcha"
 	"github.com/Tus1688/openmerce-backend/service/freight"
 	"github.com/Tus1688/openmerce-backend/service/mailgun"
 	"github.com/Tus1688/openmerce-backend/service/midtrans"
+	"github.com/Tus1688/openmerce-backend/service/oidc"
+	"github.com/Tus1688/openmerce-backend/service/otp"
+	"github.com/Tus1688/openmerce-backend/service/search"
+	"github.com/Tus1688/openmerce-backend/service/sheet"
+	"github.com/Tus1688/openmerce-backend/service/storage"
 	"github.com/gin-gonic/contrib/gzip"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// ./app rotate-keys -purpose customer -alg EdDSA -keep 2
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		rotateKeys(os.Args[2:])
+		return
+	}
+	// ./app import-products -file products.xlsx -dry-run
+	// ./app export-products -format xlsx -out products.xlsx
+	if len(os.Args) > 1 && (os.Args[1] == "import-products" || os.Args[1] == "export-products") {
+		productFile(os.Args[1], os.Args[2:])
+		return
+	}
 	loadEnv()
 	err := database.NewMysql()
 	if err != nil {
@@ -55,6 +76,22 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	go staffControllers.WatchProductSchedule(time.Minute)
+	feedInterval, err := time.ParseDuration(os.Getenv("FEED_INTERVAL"))
+	if err != nil || feedInterval <= 0 {
+		feedInterval = time.Hour
+	}
+	go globalControllers.WatchFeeds(feedInterval)
+	go func() {
+		if err := search.Rebuild(); err != nil {
+			log.Print("unable to build the search index: ", err)
+		}
+	}()
+	searchInterval, err := time.ParseDuration(os.Getenv("SEARCH_RESYNC_INTERVAL"))
+	if err != nil || searchInterval <= 0 {
+		searchInterval = 5 * time.Minute
+	}
+	go search.WatchIndex(searchInterval)
 	router := initRouter()
 	err = router.Run(":6000")
 	if err != nil {
@@ -63,11 +100,47 @@ func main() {
 }
 
 func loadEnv() {
-	auth.JwtKeyCustomer = []byte(os.Getenv("JWT_KEY_CUSTOMER"))
-	auth.JwtKeyStaff = []byte(os.Getenv("JWT_KEY_STAFF"))
+	if err := auth.ReadCookieEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if err := middlewares.ReadRateLimitEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if err := middlewares.ReadChallengeEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if err := auth.LoadKeys(); err != nil {
+		log.Fatal(err)
+	}
+	go auth.WatchKeys(time.Minute)
 	mailgun.ReadEnv()
-	staffControllers.NginxFSBaseUrl = os.Getenv("NGINX_FS_BASE_URL")
-	staffControllers.NginxFSAuthorization = os.Getenv("NGINX_FS_AUTHORIZATION")
+	oidc.ReadEnv()
+	otp.ReadEnv()
+	captcha.ReadEnv()
+	search.ReadEnv()
+	storage.ReadEnv()
+	// comma separated, e.g. https://openmerce.com,https://admin.openmerce.com
+	for _, origin := range strings.Split(os.Getenv("CSRF_TRUSTED_ORIGINS"), ",") {
+		if origin = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/"); origin != "" {
+			middlewares.TrustedOrigins = append(middlewares.TrustedOrigins, origin)
+		}
+	}
+	globalControllers.StorefrontUrl = strings.TrimSuffix(os.Getenv("STOREFRONT_URL"), "/")
+	if title := os.Getenv("FEED_TITLE"); title != "" {
+		globalControllers.FeedTitle = title
+	}
+	if currency := os.Getenv("FEED_CURRENCY"); currency != "" {
+		globalControllers.FeedCurrency = strings.ToUpper(currency)
+	}
+	// comma separated storefront paths, e.g. /,/about,/contact
+	if pages := os.Getenv("SITEMAP_STATIC_PAGES"); pages != "" {
+		globalControllers.StaticPages = nil
+		for _, page := range strings.Split(pages, ",") {
+			if page = strings.TrimSpace(page); page != "" {
+				globalControllers.StaticPages = append(globalControllers.StaticPages, page)
+			}
+		}
+	}
 	freight.BaseUrl = os.Getenv("FREIGHT_BASE_URL")
 	freight.Authorization = os.Getenv("FREIGHT_AUTHORIZATION")
 	midtrans.ServerKey = os.Getenv("MIDTRANS_SERVER_KEY")
@@ -78,15 +151,51 @@ func loadEnv() {
 	log.Print("Loaded env!")
 }
 
+// rotateKeys generate a new signing key for the given purpose in JWT_KEYS_DIR, running instances pick it up within a
+// minute while the previous keys are still accepted for verification
+func rotateKeys(args []string) {
+	flags := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	purpose := flags.String("purpose", "all", "customer, staff, email or all")
+	alg := flags.String("alg", "EdDSA", "RS256 or EdDSA")
+	keep := flags.Int("keep", 2, "number of keys to keep for verification, including the new one")
+	_ = flags.Parse(args)
+	auth.KeysDir = os.Getenv("JWT_KEYS_DIR")
+	purposes := []string{*purpose}
+	if *purpose == "all" {
+		purposes = []string{auth.PurposeCustomer, auth.PurposeStaff, auth.PurposeEmail}
+	}
+	for _, p := range purposes {
+		kid, err := auth.RotateKey(p, *alg, *keep)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Rotated %s key, new kid: %s", p, kid)
+	}
+}
+
 func initRouter() *gin.Engine {
 	router := gin.Default()
+	// X-Forwarded-For is only honored when it comes from TRUSTED_PROXIES (comma separated ips or cidrs), otherwise any
+	// client could pick the ip which the rate limits, the captcha challenges and the audit trail are keyed on
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("invalid TRUSTED_PROXIES: ", err)
+	}
 	router.Use(gzip.Gzip(gzip.DefaultCompression))
+	router.Use(middlewares.CheckOrigin()) // csrf protection for every state-changing request made with a cookie
+	router.Use(middlewares.RateLimit("global", middlewares.ByIP))
 
 	customerAuth := router.Group("/api/v1/auth") // customer authentication are unprotected by any middleware
 	{
 		// user is unauthenticated
 		customerAuth.POST(
-			"/register-1", authControllers.RegisterEmail,
+			"/register-1", middlewares.RateLimit("register", middlewares.ByIP), middlewares.Challenge("register"),
+			authControllers.RegisterEmail,
 		) // user get a verification code and retrieve httpOnly cookie with jwt token of the inputted email
 		customerAuth.POST(
 			"/register-2", authControllers.RegisterEmailConfirm,
@@ -94,14 +203,26 @@ func initRouter() *gin.Engine {
 		customerAuth.POST(
 			"/register-3", authControllers.CreateAccount,
 		) // user input everything else to create an account
-		customerAuth.POST("/login", authControllers.LoginCustomer)         // user login with email and password
+		customerAuth.POST(
+			"/login", middlewares.RateLimit("login", middlewares.ByIP), middlewares.Challenge("login"),
+			authControllers.LoginCustomer,
+		) // user login with email and password
 		customerAuth.GET("/refresh", authControllers.RefreshTokenCustomer) // user refresh the token
 		customerAuth.POST("/logout", authControllers.LogoutCustomer)       // user logout
+		customerAuth.GET("/jwks.json", authControllers.GetJwks)            // public keys to verify customer tokens
+		customerAuth.GET("/captcha", authControllers.GetCaptcha)           // the challenge widget to render
+
+		customerAuth.GET("/oidc", authControllers.GetOidcProviders)                // list configured oidc providers
+		customerAuth.GET("/oidc/:provider", authControllers.OidcLogin)             // get the provider authorization url
+		customerAuth.GET("/oidc/:provider/callback", authControllers.OidcCallback) // handle the provider redirect
 	}
 
 	staffAuth := router.Group("/api/v1/staff/auth")
 	{
-		staffAuth.POST("/login", authControllers.LoginStaff)
+		staffAuth.POST(
+			"/login", middlewares.RateLimit("staff_login", middlewares.ByIP), middlewares.Challenge("staff_login"),
+			authControllers.LoginStaff,
+		)
 		staffAuth.GET("/refresh", authControllers.RefreshTokenStaff)
 		staffAuth.POST("/logout", authControllers.LogoutStaff)
 	}
@@ -109,51 +230,96 @@ func initRouter() *gin.Engine {
 	// handle internal staff issue which won't be exposed to the public
 	staffConsole := router.Group("/api/v1/staff/console").
 		Use(middlewares.TokenExpiredStaff(1)).
-		Use(middlewares.TokenIsSysAdmin())
+		Use(middlewares.RequirePermission(auth.PermissionStaffManage))
 	{
 		staffConsole.GET("/staff", authControllers.GetStaff)
 		staffConsole.POST("/staff", authControllers.AddNewStaff)
 		staffConsole.PATCH("/staff", authControllers.UpdateStaff)
 		staffConsole.DELETE("/staff", authControllers.DeleteStaff)
+
+		staffConsole.GET("/permission", authControllers.GetPermissions) // list every permission a role can have
+		staffConsole.GET("/role", authControllers.GetRoles)
+		staffConsole.POST("/role", authControllers.AddNewRole)
+		staffConsole.PATCH("/role", authControllers.UpdateRole)
+		staffConsole.DELETE("/role", authControllers.DeleteRole)
+
+		staffConsole.GET("/api-key", authControllers.GetApiKeys)
+		staffConsole.POST("/api-key", authControllers.AddNewApiKey)   // the key is only shown in this response
+		staffConsole.DELETE("/api-key", authControllers.RevokeApiKey) // revoke immediately
 	}
 
-	// staff dashboard is protected by token expired middleware with 3 minutes (default)
-	// every staff can access the dashboard
+	// staff dashboard is protected by token expired middleware with the access token lifetime (3 minutes default)
+	// every staff can access the dashboard, each route is guarded by the permission it needs
+	// server-to-server clients use an api key (X-API-Key header) which is guarded by the same permissions
 	staffDashboard := router.Group("/api/v1/staff/dashboard")
-	staffDashboard.Use(middlewares.TokenExpiredStaff(3))
+	staffDashboard.Use(middlewares.StaffOrApiKey(auth.Cookie.AccessMaxAgeMinutes()))
 	{
-		// inventory only accessible by inventory user
 		inventory := staffDashboard.Group("/inventory")
-		inventory.Use(middlewares.TokenIsInvUser())
 		{
-			inventory.GET("/category", staffControllers.GetCategories)
-			inventory.POST("/category", staffControllers.AddNewCategory)
-			inventory.DELETE("/category", staffControllers.DeleteCategory)
-			inventory.PATCH("/category", staffControllers.UpdateCategory)
-
-			inventory.GET("/product", staffControllers.GetProduct)
-			inventory.POST("/product-1", staffControllers.AddNewProduct)  // handle product meta creation
-			inventory.POST("/product-2", staffControllers.AddImage)       // handle image upload
-			inventory.DELETE("/product", staffControllers.DeleteProduct)  // delete product and its images
-			inventory.DELETE("/product-2", staffControllers.DeleteImage)  // delete image only
-			inventory.PATCH("/product-1", staffControllers.UpdateProduct) // update product (without image)
-
-			inventory.GET("/order", staffControllers.GetOrder)
-			inventory.POST("/ship", staffControllers.ShipOrder)
+			productRead := inventory.Group("", middlewares.RequirePermission(auth.PermissionProductRead))
+			productRead.GET("/category", staffControllers.GetCategories)
+			productRead.GET("/brand", staffControllers.GetBrands)
+			productRead.GET("/category-attribute", staffControllers.GetCategoryAttributes)
+			productRead.GET("/product", staffControllers.GetProduct)
+			productRead.GET("/product-sku", staffControllers.GetProductSku)
+			productRead.GET("/product-export", staffControllers.ExportProducts) // csv (default) or xlsx with the stock
+
+			productWrite := inventory.Group("", middlewares.RequirePermission(auth.PermissionProductWrite))
+			productWrite.POST("/category", staffControllers.AddNewCategory)
+			productWrite.DELETE("/category", staffControllers.DeleteCategory)
+			productWrite.PATCH("/category", staffControllers.UpdateCategory)
+			productWrite.PATCH("/category-move", staffControllers.MoveCategory)               // change the parent or position
+			productWrite.PUT("/category-order", staffControllers.ReorderCategories)           // order the children of a parent
+			productWrite.PUT("/category-homepage", staffControllers.UpdateHomepageCategories) // homepage categories in order
+			productWrite.POST("/brand", staffControllers.AddBrand)                            // multipart form with the logo
+			productWrite.PATCH("/brand", staffControllers.UpdateBrand)                        // replace the logo when given
+			productWrite.DELETE("/brand", staffControllers.DeleteBrand)
+			productWrite.POST("/category-attribute", staffControllers.AddCategoryAttribute)
+			productWrite.PATCH("/category-attribute", staffControllers.UpdateCategoryAttribute)
+			productWrite.DELETE("/category-attribute", staffControllers.DeleteCategoryAttribute)
+			productWrite.PATCH("/product-status", staffControllers.UpdateProductStatus)
+			productWrite.POST("/feed-regenerate", staffControllers.RegenerateFeeds)
+			productWrite.POST("/product-1", staffControllers.AddNewProduct)        // handle product meta creation
+			productWrite.POST("/product-2", staffControllers.AddImage)             // handle image upload (up to 10 files)
+			productWrite.DELETE("/product", staffControllers.DeleteProduct)        // delete product and its images
+			productWrite.DELETE("/product-2", staffControllers.DeleteImage)        // delete image only
+			productWrite.PATCH("/product-1", staffControllers.UpdateProduct)       // update product (without image)
+			productWrite.POST("/product-sku", staffControllers.AddProductSku)      // add a variant to a product
+			productWrite.PATCH("/product-sku", staffControllers.UpdateProductSku)  // update a variant
+			productWrite.DELETE("/product-sku", staffControllers.DeleteProductSku) // delete a variant
+			productWrite.PATCH("/product-image", staffControllers.UpdateImage)     // alt text or primary image
+			productWrite.PUT("/product-image", staffControllers.ReorderImages)     // order every image of a product
+			productWrite.POST("/product-import", staffControllers.ImportProducts)  // csv or xlsx, dry_run only validates
+
+			inventory.GET("/order", middlewares.RequirePermission(auth.PermissionOrderRead), staffControllers.GetOrder)
+			inventory.POST("/ship", middlewares.RequirePermission(auth.PermissionOrderShip), staffControllers.ShipOrder)
 		}
 		// everything that related to global wide system settings
 		system := staffDashboard.Group("/system")
-		system.Use(middlewares.TokenIsSysAdmin())
 		{
-			system.POST("/home-banner", staffControllers.AddHomeBanner)
-			system.DELETE("/home-banner", staffControllers.DeleteHomeBanner)
+			banner := system.Group("", middlewares.RequirePermission(auth.PermissionBannerManage))
+			banner.POST("/home-banner", staffControllers.AddHomeBanner)
+			banner.DELETE("/home-banner", staffControllers.DeleteHomeBanner)
+
+			blacklist := system.Group("", middlewares.RequirePermission(auth.PermissionBlacklistManage))
+			blacklist.GET("/blacklist-domain", staffControllers.GetBlacklistDomain)
+			blacklist.POST("/blacklist-domain", staffControllers.AddBlacklistDomain)
+			blacklist.DELETE("/blacklist-domain", staffControllers.DeleteBlacklistDomain)
+			blacklist.POST(
+				"/blacklist-domain-import", staffControllers.ImportBlacklistDomain,
+			) // bulk import from a text file of disposable email providers
+
+			system.GET(
+				"/audit", middlewares.RequirePermission(auth.PermissionAuditRead), staffControllers.GetAudit,
+			) // query the audit trail of staff mutations
 		}
 	}
 
-	// customer dashboard is protected by token expired middleware with 3 minutes (default)
+	// customer dashboard is protected by token expired middleware with the access token lifetime (3 minutes default)
 	// every customer can access the dashboard
 	customerDashboard := router.Group("/api/v1/customer")
-	customerDashboard.Use(middlewares.TokenExpiredCustomer(3))
+	customerDashboard.Use(middlewares.TokenExpiredCustomer(auth.Cookie.AccessMaxAgeMinutes()))
+	customerDashboard.Use(middlewares.RateLimit("customer", middlewares.ByCustomer))
 	{
 		customerDashboard.GET("/cart", customerControllers.GetCart)
 		customerDashboard.POST("/cart", customerControllers.AddToCart) // also handle update cart
@@ -200,18 +366,126 @@ func initRouter() *gin.Engine {
 		) // handle update profile (without password)
 
 		customerDashboard.PATCH("/creds", customerControllers.UpdatePassword) // handle update password
+
+		customerDashboard.POST(
+			"/email-change", customerControllers.RequestEmailChange,
+		) // send a verification code to the new email
+		customerDashboard.POST(
+			"/email-change-confirm", customerControllers.ConfirmEmailChange,
+		) // confirm the code and change the email, the old email is notified
+		customerDashboard.POST(
+			"/phone", customerControllers.RequestPhoneVerification,
+		) // send an otp to the phone number
+		customerDashboard.POST(
+			"/phone-confirm", customerControllers.ConfirmPhoneVerification,
+		) // confirm the otp and save the phone number as verified
+
+		customerDashboard.GET("/oidc", customerControllers.GetLinkedProviders) // get all linked oidc providers
+		customerDashboard.POST("/oidc", customerControllers.LinkProvider)      // handle link oidc provider
+		customerDashboard.DELETE("/oidc", customerControllers.UnlinkProvider)  // handle unlink oidc provider
+
+		customerDashboard.GET("/data-export", customerControllers.ExportData) // export personal data as json or zip
+		customerDashboard.DELETE(
+			"/account", customerControllers.DeleteAccount,
+		) // anonymize the personal data and revoke every session, orders are kept
 	}
 
 	// global unprotected routes for public access
 	router.GET("/api/v1/product", globalControllers.GetProduct)
+	router.GET(
+		"/api/v1/product/suggest", middlewares.RateLimit("suggest", middlewares.ByIP),
+		globalControllers.GetSuggestProduct,
+	)
 	router.GET("/api/v1/product-sold", globalControllers.GetProductSold)
 	router.GET("/api/v1/product-review", globalControllers.GetReviewGlobal)
 	router.GET("/api/v1/category", globalControllers.GetCategory)
+	router.GET("/api/v1/category-attribute", globalControllers.GetCategoryAttribute)
+	// the local storage is served by the api itself, the other storages are served by their own server
+	if local, ok := storage.DefaultStore.(*storage.Local); ok {
+		router.Static("/media", local.Dir)
+	}
+	router.GET("/api/v1/brand", globalControllers.GetBrand) // brand list, or the brand page when the slug is given
 	router.GET("/api/v1/home-banner", globalControllers.GetHomeBanner)
-	router.GET("/api/v1/area/suggest", globalControllers.GetSuggestArea)
-	router.GET("/api/v1/freight-rates", globalControllers.GetRatesProduct)
+	router.GET("/sitemap.xml", globalControllers.GetSitemap)
+	router.GET("/api/v1/feed/:file", globalControllers.GetFeed)
+	router.GET("/api/v1/area/suggest", middlewares.RateLimit("area", middlewares.ByIP), globalControllers.GetSuggestArea)
+	router.GET(
+		"/api/v1/freight-rates", middlewares.RateLimit("freight", middlewares.ByIP), globalControllers.GetRatesProduct,
+	) // a cache miss calls the paid freight service
 
 	// webhook
 	router.POST("/api/v1/webhook/midtrans", midtrans.HandleNotifications)
 	return router
 }
+
+// productFile run the product import or export against the database of the environment. The import reports the same
+// row errors as the endpoint and creates nothing unless every row is valid
+func productFile(command string, args []string) {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	file := flags.String("file", "", "csv or xlsx file to import")
+	dryRun := flags.Bool("dry-run", false, "only validate the rows of the import")
+	format := flags.String("format", "csv", "csv or xlsx export format")
+	out := flags.String("out", "", "export destination, the standard output when empty")
+	_ = flags.Parse(args)
+	loadEnv()
+	if err := database.NewMysql(); err != nil {
+		log.Fatal(err)
+	}
+	if err := database.NewRedis(); err != nil {
+		log.Fatal(err)
+	}
+
+	if command == "export-products" {
+		var w io.Writer = os.Stdout
+		if *out != "" {
+			f, err := os.Create(*out)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := staffControllers.WriteProductExport(w, *format); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows, err := sheet.Read(*file, f, info.Size())
+	if err != nil {
+		log.Fatal(err)
+	}
+	result, err := staffControllers.RunProductImport(rows, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, rowError := range result.Errors {
+		if rowError.Column != "" {
+			log.Printf("row %d, %s: %s", rowError.Row, rowError.Column, rowError.Error)
+		} else {
+			log.Printf("row %d: %s", rowError.Row, rowError.Error)
+		}
+	}
+	switch {
+	case len(result.Errors) > 0:
+		log.Fatalf("%d of %d rows have an error, nothing is imported", len(result.Errors), result.Rows)
+	case *dryRun:
+		log.Printf("All %d rows are valid", result.Rows)
+	default:
+		// a shared search engine is synced here, the running instances using the memory engine pick the products up
+		// on their next resync (SEARCH_RESYNC_INTERVAL)
+		for _, id := range result.Created {
+			search.Sync(id)
+		}
+		log.Printf("Imported %d products", len(result.Created))
+	}
+}
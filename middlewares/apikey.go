@@ -0,0 +1,95 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package middlewares
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/auth"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// StaffOrApiKey authenticate the request with the api key header when it is sent, otherwise it is TokenExpiredStaff.
+// The permissions of the key are checked by RequirePermission the same way as the staff permissions
+func StaffOrApiKey(expiredIn int) gin.HandlerFunc {
+	tokenExpired := TokenExpiredStaff(expiredIn)
+	return func(c *gin.Context) {
+		key := c.GetHeader(auth.ApiKeyHeader)
+		if key == "" {
+			tokenExpired(c)
+			return
+		}
+		lookup, ok := auth.ParseApiKey(key)
+		if !ok {
+			c.AbortWithStatus(401)
+			return
+		}
+		var id, createdBy, rateLimit uint
+		var hashedKey []byte
+		var permissions string
+		err := database.MysqlInstance.QueryRow(
+			`SELECT k.id, k.hashed_key, k.created_by, k.rate_limit,
+			       COALESCE(GROUP_CONCAT(kp.permission), '')
+			FROM api_keys k
+			         INNER JOIN staffs s ON k.created_by = s.id AND s.deleted_at IS NULL
+			         LEFT JOIN api_key_permissions kp ON kp.api_key_refer = k.id
+			WHERE k.lookup = ? AND k.revoked_at IS NULL AND k.expires_at > UTC_TIMESTAMP()
+			GROUP BY k.id`, lookup,
+		).Scan(&id, &hashedKey, &createdBy, &rateLimit, &permissions)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				go logging.InsertLog(logging.ERROR, "StaffOrApiKey: "+err.Error())
+				c.AbortWithStatus(500)
+				return
+			}
+			c.AbortWithStatus(401)
+			return
+		}
+		if subtle.ConstantTimeCompare(hashedKey, auth.HashApiKey(key)) != 1 {
+			c.AbortWithStatus(401)
+			return
+		}
+		rule := RateLimitRule{Limit: int(rateLimit), Window: time.Minute}
+		if rateLimit > 0 && !allow(c, "api_key:"+strconv.FormatUint(uint64(id), 10), rule) {
+			return
+		}
+		// last_used_at is only updated once a minute to keep the key lookup cheap
+		go func(ip string) {
+			_, _ = database.MysqlInstance.Exec(
+				`UPDATE api_keys SET last_used_at = UTC_TIMESTAMP(), last_used_ip = ?
+				WHERE id = ? AND (last_used_at IS NULL OR last_used_at < UTC_TIMESTAMP() - INTERVAL 1 MINUTE)`,
+				ip, id,
+			)
+		}(c.ClientIP())
+		claims := &auth.JWTClaimAccessTokenStaff{Id: createdBy, ApiKey: id, Permissions: []string{}}
+		if permissions != "" {
+			claims.Permissions = strings.Split(permissions, ",")
+		}
+		auth.SetStaffClaims(c, claims)
+		c.Next()
+	}
+}
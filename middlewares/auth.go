@@ -27,10 +27,12 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// TokenExpiredStaff verify the access token from the ac_stf cookie or the Authorization header and store its claims
+// for auth.StaffClaims
 func TokenExpiredStaff(expiredIn int) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString, err := c.Cookie("ac_stf")
-		if err != nil {
+		tokenString, ok := auth.RequestToken(c, "ac_stf")
+		if !ok {
 			c.AbortWithStatus(401)
 			return
 		}
@@ -43,14 +45,17 @@ func TokenExpiredStaff(expiredIn int) gin.HandlerFunc {
 			c.AbortWithStatus(401)
 			return
 		}
+		auth.SetStaffClaims(c, claims)
 		c.Next()
 	}
 }
 
+// TokenExpiredCustomer verify the access token from the ac_cus cookie or the Authorization header and store its
+// claims for auth.CustomerClaims
 func TokenExpiredCustomer(expiredIn int) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString, err := c.Cookie("ac_cus")
-		if err != nil {
+		tokenString, ok := auth.RequestToken(c, "ac_cus")
+		if !ok {
 			c.AbortWithStatus(401)
 			return
 		}
@@ -64,6 +69,7 @@ func TokenExpiredCustomer(expiredIn int) gin.HandlerFunc {
 			c.AbortWithStatus(401)
 			return
 		}
+		auth.SetCustomerClaims(c, claims)
 		c.Next()
 	}
 }
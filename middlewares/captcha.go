@@ -0,0 +1,147 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+	"github.com/Tus1688/openmerce-backend/service/captcha"
+	"github.com/gin-gonic/gin"
+)
+
+// CaptchaHeader is the header the client send the token of the challenge widget with
+const CaptchaHeader = "X-Captcha-Token"
+
+// ChallengeRule require the bot challenge once an ip reach Threshold attempts in Window, or once every ip together
+// reach GlobalThreshold (an attack spread over many ips). A threshold of 0 is not checked
+type ChallengeRule struct {
+	Threshold       int
+	GlobalThreshold int
+	Window          time.Duration
+	// FailuresOnly only count the attempts which didn't succeed (e.g. wrong password)
+	FailuresOnly bool
+}
+
+// ChallengeRules can be overridden with CAPTCHA_<NAME>=<threshold>/<global threshold>/<window> (e.g. 3/100/1h),
+// 0/0/1h require the challenge on every request. Every endpoint has its own rule so the failures on one of them don't
+// challenge the other
+var ChallengeRules = map[string]ChallengeRule{
+	"register":    {Threshold: 3, GlobalThreshold: 200, Window: time.Hour},
+	"login":       {Threshold: 5, GlobalThreshold: 500, Window: 15 * time.Minute, FailuresOnly: true},
+	"staff_login": {Threshold: 5, GlobalThreshold: 50, Window: 15 * time.Minute, FailuresOnly: true},
+}
+
+func ReadChallengeEnv() error {
+	for name, rule := range ChallengeRules {
+		value := os.Getenv("CAPTCHA_" + strings.ToUpper(name))
+		if value == "" {
+			continue
+		}
+		parts := strings.Split(value, "/")
+		if len(parts) != 3 {
+			return fmt.Errorf("invalid CAPTCHA_%s: %s", strings.ToUpper(name), value)
+		}
+		var err error
+		if rule.Threshold, err = strconv.Atoi(parts[0]); err != nil || rule.Threshold < 0 {
+			return fmt.Errorf("invalid CAPTCHA_%s: %s", strings.ToUpper(name), value)
+		}
+		if rule.GlobalThreshold, err = strconv.Atoi(parts[1]); err != nil || rule.GlobalThreshold < 0 {
+			return fmt.Errorf("invalid CAPTCHA_%s: %s", strings.ToUpper(name), value)
+		}
+		if rule.Window, err = time.ParseDuration(parts[2]); err != nil || rule.Window < time.Second {
+			return fmt.Errorf("invalid CAPTCHA_%s: %s", strings.ToUpper(name), value)
+		}
+		ChallengeRules[name] = rule
+	}
+	return nil
+}
+
+// Challenge require a valid captcha token in the X-Captcha-Token header once the activity of the rule looks
+// suspicious, the attempts are counted on redisInstance[10]. It does nothing when no captcha provider is configured
+func Challenge(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		verifier := captcha.DefaultVerifier
+		rule, ok := ChallengeRules[name]
+		if verifier == nil || !ok {
+			c.Next()
+			return
+		}
+		ipKey := "challenge:" + name + ":" + c.ClientIP()
+		globalKey := "challenge:" + name
+		if isSuspicious(rule, ipKey, globalKey) {
+			valid, err := verifier.Verify(c.GetHeader(CaptchaHeader), c.ClientIP())
+			if err != nil {
+				go logging.InsertLog(logging.ERROR, "captcha: "+err.Error())
+				c.AbortWithStatus(500)
+				return
+			}
+			if !valid {
+				c.AbortWithStatusJSON(
+					403, gin.H{
+						"error":    "Captcha verification is required",
+						"provider": verifier.Provider(),
+						"site_key": captcha.SiteKey,
+					},
+				)
+				return
+			}
+		}
+		c.Next()
+		if rule.FailuresOnly && c.Writer.Status() < 400 {
+			return
+		}
+		countAttempt(rule, ipKey, globalKey)
+	}
+}
+
+func isSuspicious(rule ChallengeRule, ipKey string, globalKey string) bool {
+	if rule.Threshold == 0 && rule.GlobalThreshold == 0 {
+		return true
+	}
+	counts, err := database.RedisInstance[10].MGet(context.Background(), ipKey, globalKey).Result()
+	if err != nil {
+		// fail open like the rate limiter, the rate limit still applies when redis is down
+		return false
+	}
+	exceeded := func(value interface{}, threshold int) bool {
+		count, _ := strconv.Atoi(fmt.Sprint(value))
+		return threshold > 0 && count >= threshold
+	}
+	return exceeded(counts[0], rule.Threshold) || exceeded(counts[1], rule.GlobalThreshold)
+}
+
+// countAttempt increase the counters, the window start on the first attempt and is not extended by the next ones
+func countAttempt(rule ChallengeRule, keys ...string) {
+	ctx := context.Background()
+	for _, key := range keys {
+		count, err := database.RedisInstance[10].Incr(ctx, key).Result()
+		if err == nil && count == 1 {
+			_ = database.RedisInstance[10].Expire(ctx, key, rule.Window).Err()
+		}
+	}
+}
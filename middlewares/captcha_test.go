@@ -0,0 +1,259 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package middlewares
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/service/captcha"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is a tiny RESP2 server implementing the few commands the challenge counters need
+type fakeRedis struct {
+	mu     sync.Mutex
+	values map[string]int
+}
+
+func startFakeRedis(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	server := &fakeRedis{values: map[string]int{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, f.handle(args)); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		if _, err := reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) handle(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "HELLO":
+		// make the client fall back to RESP2
+		return "-ERR unknown command 'HELLO'\r\n"
+	case "PING":
+		return "+PONG\r\n"
+	case "INCR":
+		f.values[args[1]]++
+		return fmt.Sprintf(":%d\r\n", f.values[args[1]])
+	case "EXPIRE":
+		return ":1\r\n"
+	case "MGET":
+		reply := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			value, ok := f.values[key]
+			if !ok {
+				reply += "$-1\r\n"
+				continue
+			}
+			count := strconv.Itoa(value)
+			reply += fmt.Sprintf("$%d\r\n%s\r\n", len(count), count)
+		}
+		return reply
+	}
+	return "+OK\r\n"
+}
+
+// setupChallenge point redisInstance[10] at a fresh fake redis and install the verifier
+func setupChallenge(t *testing.T, verifier captcha.Verifier) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	addr := startFakeRedis(t)
+	instances := make([]*redis.Client, 13)
+	instances[10] = redis.NewClient(&redis.Options{Addr: addr, DB: 10, MaxRetries: -1})
+	t.Cleanup(func() { _ = instances[10].Close() })
+
+	previousInstances, previousVerifier := database.RedisInstance, captcha.DefaultVerifier
+	database.RedisInstance, captcha.DefaultVerifier = instances, verifier
+	t.Cleanup(
+		func() {
+			database.RedisInstance, captcha.DefaultVerifier = previousInstances, previousVerifier
+		},
+	)
+}
+
+// withRule register a temporary challenge rule under name
+func withRule(t *testing.T, name string, rule ChallengeRule) {
+	t.Helper()
+	ChallengeRules[name] = rule
+	t.Cleanup(func() { delete(ChallengeRules, name) })
+}
+
+func challengeRouter(name string, status int) *gin.Engine {
+	router := gin.New()
+	router.POST(
+		"/", Challenge(name), func(c *gin.Context) {
+			c.Status(status)
+		},
+	)
+	return router
+}
+
+func doChallenge(router *gin.Engine, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", nil)
+	req.RemoteAddr = ip + ":1234"
+	req.Header.Set(CaptchaHeader, "token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestChallengeWithoutVerifier(t *testing.T) {
+	setupChallenge(t, nil)
+	withRule(t, "test", ChallengeRule{Window: time.Minute})
+	if w := doChallenge(challengeRouter("test", 200), "192.0.2.1"); w.Code != 200 {
+		t.Fatalf("expected the challenge to be skipped, got %d", w.Code)
+	}
+}
+
+func TestChallengeAlwaysRequired(t *testing.T) {
+	setupChallenge(t, &captcha.Fake{Pass: false})
+	withRule(t, "test", ChallengeRule{Window: time.Minute})
+	w := doChallenge(challengeRouter("test", 200), "192.0.2.1")
+	if w.Code != 403 {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"provider":"fake"`) {
+		t.Fatalf("expected the provider in the response, got %s", w.Body.String())
+	}
+}
+
+func TestChallengeIpThreshold(t *testing.T) {
+	setupChallenge(t, &captcha.Fake{Pass: false})
+	withRule(t, "test", ChallengeRule{Threshold: 2, Window: time.Minute})
+	router := challengeRouter("test", 200)
+	for i := 0; i < 2; i++ {
+		if w := doChallenge(router, "192.0.2.1"); w.Code != 200 {
+			t.Fatalf("attempt %d: expected 200, got %d", i+1, w.Code)
+		}
+	}
+	if w := doChallenge(router, "192.0.2.1"); w.Code != 403 {
+		t.Fatalf("expected the challenge after the threshold, got %d", w.Code)
+	}
+	if w := doChallenge(router, "192.0.2.2"); w.Code != 200 {
+		t.Fatalf("expected another ip to be unaffected, got %d", w.Code)
+	}
+}
+
+func TestChallengeGlobalThreshold(t *testing.T) {
+	setupChallenge(t, &captcha.Fake{Pass: false})
+	withRule(t, "test", ChallengeRule{Threshold: 10, GlobalThreshold: 3, Window: time.Minute})
+	router := challengeRouter("test", 200)
+	for i := 1; i <= 3; i++ {
+		if w := doChallenge(router, fmt.Sprintf("192.0.2.%d", i)); w.Code != 200 {
+			t.Fatalf("attempt %d: expected 200, got %d", i, w.Code)
+		}
+	}
+	if w := doChallenge(router, "192.0.2.4"); w.Code != 403 {
+		t.Fatalf("expected the challenge once the global threshold is reached, got %d", w.Code)
+	}
+}
+
+func TestChallengePassingVerifier(t *testing.T) {
+	setupChallenge(t, &captcha.Fake{Pass: true})
+	withRule(t, "test", ChallengeRule{Threshold: 1, Window: time.Minute})
+	router := challengeRouter("test", 200)
+	for i := 0; i < 3; i++ {
+		if w := doChallenge(router, "192.0.2.1"); w.Code != 200 {
+			t.Fatalf("attempt %d: expected a solved challenge to pass, got %d", i+1, w.Code)
+		}
+	}
+}
+
+func TestChallengeFailuresOnly(t *testing.T) {
+	setupChallenge(t, &captcha.Fake{Pass: false})
+	withRule(t, "success", ChallengeRule{Threshold: 1, Window: time.Minute, FailuresOnly: true})
+	withRule(t, "failure", ChallengeRule{Threshold: 1, Window: time.Minute, FailuresOnly: true})
+
+	success := challengeRouter("success", 200)
+	for i := 0; i < 3; i++ {
+		if w := doChallenge(success, "192.0.2.1"); w.Code != 200 {
+			t.Fatalf("attempt %d: expected successful attempts not to count, got %d", i+1, w.Code)
+		}
+	}
+
+	failure := challengeRouter("failure", 401)
+	if w := doChallenge(failure, "192.0.2.1"); w.Code != 401 {
+		t.Fatalf("expected the first failure to reach the handler, got %d", w.Code)
+	}
+	if w := doChallenge(failure, "192.0.2.1"); w.Code != 403 {
+		t.Fatalf("expected the challenge after a failure, got %d", w.Code)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package middlewares
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrustedOrigins are the origins (scheme://host[:port]) of the frontends allowed to send state-changing requests,
+// the request host itself is trusted when it is empty
+var TrustedOrigins []string
+
+// authCookies are the cookies a cross-site request could ride on
+var authCookies = []string{"ac_cus", "ref_cus", "ac_stf", "ref_stf", "email"}
+
+// CheckOrigin reject a state-changing request carrying an auth cookie when its Origin (or Referer when the browser
+// doesn't send Origin) is not trusted
+func CheckOrigin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		// webhooks and bearer token clients don't carry cookies so they can't be forged by another site
+		if !hasAuthCookie(c) {
+			c.Next()
+			return
+		}
+		origin := c.GetHeader("Origin")
+		if origin == "" || origin == "null" {
+			referer, err := url.Parse(c.GetHeader("Referer"))
+			if err != nil || referer.Host == "" {
+				c.AbortWithStatusJSON(403, gin.H{"error": "Missing Origin header"})
+				return
+			}
+			origin = referer.Scheme + "://" + referer.Host
+		}
+		if !isTrustedOrigin(c, origin) {
+			c.AbortWithStatusJSON(403, gin.H{"error": "Untrusted origin"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func hasAuthCookie(c *gin.Context) bool {
+	for _, name := range authCookies {
+		if _, err := c.Cookie(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isTrustedOrigin(c *gin.Context, origin string) bool {
+	origin = strings.TrimSuffix(strings.ToLower(origin), "/")
+	if len(TrustedOrigins) == 0 {
+		parsed, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(parsed.Host, c.Request.Host)
+	}
+	for _, trusted := range TrustedOrigins {
+		if origin == trusted {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/auth"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitRule allow Limit requests in any Window for the same subject
+type RateLimitRule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// RateLimitRules are the rules of each route group, each one can be overridden with RATE_LIMIT_<NAME>=<limit>/<window>
+// (e.g. RATE_LIMIT_FREIGHT=30/1m), a limit of 0 disables the rule
+var RateLimitRules = map[string]RateLimitRule{
+	"global":      {Limit: 300, Window: time.Minute},
+	"login":       {Limit: 10, Window: time.Minute},
+	"staff_login": {Limit: 10, Window: time.Minute},
+	"register":    {Limit: 5, Window: 10 * time.Minute},
+	"area":        {Limit: 60, Window: time.Minute},
+	"suggest":     {Limit: 120, Window: time.Minute},
+	"freight":     {Limit: 30, Window: time.Minute},
+	"customer":    {Limit: 120, Window: time.Minute},
+}
+
+// RateLimitAllowlist are the networks which are never rate limited (e.g. the payment gateway webhook, monitoring)
+var RateLimitAllowlist []*net.IPNet
+
+// slidingWindowScript remove the requests older than the window, add the current one when the limit isn't reached and
+// return {allowed, count, oldest request time}
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {allowed, count, tonumber(oldest[2] or now)}
+`)
+
+// ReadRateLimitEnv read RATE_LIMIT_<NAME> for every rule and RATE_LIMIT_ALLOWLIST (comma separated ip or cidr)
+func ReadRateLimitEnv() error {
+	for name := range RateLimitRules {
+		value := os.Getenv("RATE_LIMIT_" + strings.ToUpper(name))
+		if value == "" {
+			continue
+		}
+		limit, window, found := strings.Cut(value, "/")
+		if !found {
+			return fmt.Errorf("invalid RATE_LIMIT_%s: %s", strings.ToUpper(name), value)
+		}
+		rule := RateLimitRule{}
+		var err error
+		if rule.Limit, err = strconv.Atoi(limit); err != nil || rule.Limit < 0 {
+			return fmt.Errorf("invalid RATE_LIMIT_%s: %s", strings.ToUpper(name), value)
+		}
+		if rule.Window, err = time.ParseDuration(window); err != nil || rule.Window < time.Second {
+			return fmt.Errorf("invalid RATE_LIMIT_%s: %s", strings.ToUpper(name), value)
+		}
+		RateLimitRules[name] = rule
+	}
+	for _, value := range strings.Split(os.Getenv("RATE_LIMIT_ALLOWLIST"), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			if strings.Contains(value, ":") {
+				value += "/128"
+			} else {
+				value += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return fmt.Errorf("invalid RATE_LIMIT_ALLOWLIST entry: %s", value)
+		}
+		RateLimitAllowlist = append(RateLimitAllowlist, network)
+	}
+	return nil
+}
+
+// RateLimitSubject return who the request is counted for
+type RateLimitSubject func(c *gin.Context) string
+
+// ByIP count the requests per client ip
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByCustomer count the requests per customer, it has to be used after TokenExpiredCustomer otherwise it is ByIP
+func ByCustomer(c *gin.Context) string {
+	if claims := auth.CustomerClaims(c); claims != nil {
+		return "cus:" + claims.Uid
+	}
+	return ByIP(c)
+}
+
+// RateLimit limit the requests of the subject with the rule of the given name using a sliding window on
+// redisInstance[10], the RateLimit-* headers tell the client its remaining quota
+func RateLimit(name string, subject RateLimitSubject) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rule := RateLimitRules[name]
+		if rule.Limit == 0 || isAllowlisted(c.ClientIP()) {
+			c.Next()
+			return
+		}
+		if !allow(c, name+":"+subject(c), rule) {
+			return
+		}
+		c.Next()
+	}
+}
+
+func isAllowlisted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range RateLimitAllowlist {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// allow count the request on the sliding window of the key, it set the RateLimit-* headers and abort with 429 once the
+// limit is reached
+func allow(c *gin.Context, key string, rule RateLimitRule) bool {
+	now := time.Now().UnixMilli()
+	window := rule.Window.Milliseconds()
+	result, err := slidingWindowScript.Run(
+		context.Background(), database.RedisInstance[10], []string{key}, now, window, rule.Limit,
+		strconv.FormatInt(now, 10)+"-"+auth.GenerateRandomString(6),
+	).Int64Slice()
+	if err != nil || len(result) != 3 {
+		// the api should stay available when redis is down
+		return true
+	}
+	reset := (result[2] + window - now + 999) / 1000
+	c.Header("RateLimit-Limit", strconv.Itoa(rule.Limit))
+	c.Header("RateLimit-Remaining", strconv.FormatInt(int64(rule.Limit)-result[1], 10))
+	c.Header("RateLimit-Reset", strconv.FormatInt(reset, 10))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rule.Limit, window/1000))
+	if result[0] == 0 {
+		c.Header("Retry-After", strconv.FormatInt(reset, 10))
+		c.AbortWithStatusJSON(429, gin.H{"error": "Rate limit exceeded"})
+		return false
+	}
+	return true
+}
@@ -25,39 +25,16 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func TokenIsSysAdmin() gin.HandlerFunc {
+// RequirePermission only let the staff through if one of their roles grants the permission, it has to be used after
+// TokenExpiredStaff
+func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString, err := c.Cookie("ac_stf")
-		if err != nil {
+		claims := auth.StaffClaims(c)
+		if claims == nil {
 			c.AbortWithStatus(401)
 			return
 		}
-		claims, err := auth.ExtractClaimAccessTokenStaff(tokenString)
-		if err != nil {
-			c.AbortWithStatus(401)
-			return
-		}
-		if !claims.SysAdmin {
-			c.AbortWithStatus(403)
-			return
-		}
-		c.Next()
-	}
-}
-
-func TokenIsInvUser() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		tokenString, err := c.Cookie("ac_stf")
-		if err != nil {
-			c.AbortWithStatus(401)
-			return
-		}
-		claims, err := auth.ExtractClaimAccessTokenStaff(tokenString)
-		if err != nil {
-			c.AbortWithStatus(401)
-			return
-		}
-		if !claims.InvUser {
+		if !claims.HasPermission(permission) {
 			c.AbortWithStatus(403)
 			return
 		}
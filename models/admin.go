@@ -21,27 +21,51 @@
 package models
 
 import (
+	"time"
 	"unicode"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 type ListStaff struct {
-	ID       uint   `json:"id"`
-	Username string `json:"username"`
-	Name     string `json:"name"`
-	FinUser  bool   `json:"fin_user"`
-	InvUser  bool   `json:"inv_user"`
-	SysAdmin bool   `json:"sys_admin"`
+	ID       uint          `json:"id"`
+	Username string        `json:"username"`
+	Name     string        `json:"name"`
+	Roles    []RoleCompact `json:"roles"`
 }
 
+// UpdateStaff replace the roles of the staff only when Roles is not nil
 type UpdateStaff struct {
-	ID       uint   `json:"id" binding:"required"`
-	Password string `json:"password"`
-	Name     string `json:"name"`
-	FinUser  *bool  `json:"fin_user" binding:"required"`
-	InvUser  *bool  `json:"inv_user" binding:"required"`
-	SysAdmin *bool  `json:"sys_admin" binding:"required"`
+	ID       uint    `json:"id" binding:"required"`
+	Password string  `json:"password"`
+	Name     string  `json:"name"`
+	Roles    *[]uint `json:"roles"`
+}
+
+type Role struct {
+	ID          uint     `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleCompact struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+type RoleCreate struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// RoleUpdate replace the permissions of the role only when Permissions is not nil
+type RoleUpdate struct {
+	ID          uint      `json:"id" binding:"required"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions *[]string `json:"permissions"`
 }
 
 func (s *UpdateStaff) PasswordIsValid() bool {
@@ -82,3 +106,24 @@ func (s *UpdateStaff) HashPassword() error {
 	s.Password = string(bytes)
 	return nil
 }
+
+type ApiKey struct {
+	ID          uint       `json:"id"`
+	Name        string     `json:"name"`
+	Lookup      string     `json:"lookup"`
+	Permissions []string   `json:"permissions"`
+	RateLimit   uint       `json:"rate_limit"`
+	CreatedBy   string     `json:"created_by"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	LastUsedIp  string     `json:"last_used_ip"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+}
+
+type ApiKeyCreate struct {
+	Name        string    `json:"name" binding:"required"`
+	Permissions []string  `json:"permissions" binding:"required"`
+	RateLimit   uint      `json:"rate_limit"` // requests per minute, 0 is unlimited
+	ExpiresAt   time.Time `json:"expires_at" binding:"required"`
+}
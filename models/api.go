@@ -31,3 +31,64 @@ type APICommonQueryUUID struct {
 type APICommonQuerySearch struct {
 	Search string `form:"search" binding:"required"`
 }
+
+// PageQuery is the common offset pagination query, Page starts from 1
+type PageQuery struct {
+	Page     uint `form:"page"`
+	PageSize uint `form:"page_size" binding:"omitempty,max=100"`
+}
+
+const defaultPageSize = 24
+
+// MaxPageSize is the largest page_size a client can ask for
+const MaxPageSize = 100
+
+// Limit return the page size, falling back to the default when it is not set
+func (q PageQuery) Limit() uint {
+	if q.PageSize == 0 {
+		return defaultPageSize
+	}
+	return q.PageSize
+}
+
+// Offset return the number of rows to be skipped for the requested page
+func (q PageQuery) Offset() uint {
+	if q.Page <= 1 {
+		return 0
+	}
+	return (q.Page - 1) * q.Limit()
+}
+
+// Pagination is the pagination metadata of a paginated response
+type Pagination struct {
+	Page       uint   `json:"page"`
+	PageSize   uint   `json:"page_size"`
+	Total      uint64 `json:"total"`
+	TotalPages uint64 `json:"total_pages"`
+}
+
+// PaginatedResponse is the envelope of every paginated listing
+type PaginatedResponse struct {
+	Data       interface{} `json:"data"`
+	Pagination Pagination  `json:"pagination"`
+	// Facets is only set by the listing which supports it e.g. the product search
+	Facets interface{} `json:"facets,omitempty"`
+}
+
+// NewPaginatedResponse wrap the data of the requested page with its pagination metadata
+func NewPaginatedResponse(data interface{}, query PageQuery, total uint64) PaginatedResponse {
+	page := query.Page
+	if page == 0 {
+		page = 1
+	}
+	limit := uint64(query.Limit())
+	return PaginatedResponse{
+		Data: data,
+		Pagination: Pagination{
+			Page:       page,
+			PageSize:   query.Limit(),
+			Total:      total,
+			TotalPages: (total + limit - 1) / limit,
+		},
+	}
+}
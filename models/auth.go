@@ -51,6 +51,16 @@ type ReqLoginCustomer struct {
 	Email      string `json:"email" binding:"required"`
 	Password   string `json:"password" binding:"required"`
 	RememberMe bool   `json:"remember_me"`
+	// Bearer return the tokens in the response body instead of cookies, it is meant for the mobile app
+	Bearer bool `json:"bearer"`
+}
+
+// BearerTokenResponse is returned to the client which authenticates with the "Authorization: Bearer" header
+type BearerTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
 }
 
 type ReqLoginStaff struct {
@@ -70,18 +80,13 @@ type StaffAuth struct {
 	ID             uint   `json:"id"`
 	Username       string `json:"username"`
 	HashedPassword string
-	FinUser        bool `json:"fin_user"`
-	InvUser        bool `json:"inv_user"`
-	SysAdmin       bool `json:"sys_admin"`
 }
 
 type NewStaff struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 	Name     string `json:"name" binding:"required"`
-	FinUser  bool   `json:"fin_user"`
-	InvUser  bool   `json:"inv_user"`
-	SysAdmin bool   `json:"sys_admin"`
+	Roles    []uint `json:"roles"`
 }
 
 // PasswordIsValid validate password is at least 8 characters long and contains at least one uppercase letter, one lowercase letter, and one number
@@ -174,3 +179,18 @@ func (s *StaffAuth) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(s.HashedPassword), []byte(password))
 	return err == nil
 }
+
+// CustomerIdentity is an external OpenID Connect identity linked to a customer
+type CustomerIdentity struct {
+	Provider  string `json:"provider"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ReqOidcLink struct {
+	Provider string `json:"provider" binding:"required"`
+}
+
+type OidcProviderQuery struct {
+	Provider string `form:"provider" binding:"required"`
+}
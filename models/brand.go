@@ -0,0 +1,67 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package models
+
+import "mime/multipart"
+
+type Brand struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	Logo        string `json:"logo"`
+}
+
+type BrandResponseCompact struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// BrandCreate is sent as a multipart form as the logo is uploaded along, the slug is made from the name when empty
+type BrandCreate struct {
+	Name        string                `form:"name" binding:"required,max=50"`
+	Slug        string                `form:"slug" binding:"omitempty,max=60"`
+	Description string                `form:"description" binding:"max=1000"`
+	Logo        *multipart.FileHeader `form:"logo"`
+}
+
+// BrandUpdate replace the logo when a new one is uploaded
+type BrandUpdate struct {
+	ID          uint                  `form:"id" binding:"required"`
+	Name        string                `form:"name" binding:"omitempty,max=50"`
+	Slug        string                `form:"slug" binding:"omitempty,max=60"`
+	Description *string               `form:"description" binding:"omitempty,max=1000"`
+	Logo        *multipart.FileHeader `form:"logo"`
+}
+
+// BrandQuery select a single brand page by its slug, the products of the brand are paginated
+type BrandQuery struct {
+	PageQuery
+	Slug string `form:"slug"`
+	Sort string `form:"sort" binding:"omitempty,oneof=newest price_asc price_desc best_selling rating"`
+}
+
+// BrandPage is the brand page response
+type BrandPage struct {
+	Brand    Brand             `json:"brand"`
+	Products PaginatedResponse `json:"products"`
+}
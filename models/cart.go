@@ -22,7 +22,9 @@ package models
 
 type CartItemResponse struct {
 	ProductId    string `json:"id"`
+	SkuId        string `json:"sku_id"`
 	ProductName  string `json:"name"`
+	Variant      string `json:"variant"`
 	ProductPrice uint   `json:"price"`
 	ProductImage string `json:"image"`
 	Quantity     uint16 `json:"quantity"`
@@ -30,17 +32,27 @@ type CartItemResponse struct {
 	Checked      bool   `json:"checked"`
 }
 
+// CartInsert add a product into the cart, SkuId can be omitted when the product has no variant
 type CartInsert struct {
 	ProductId string `json:"id" binding:"required,uuid"`
+	SkuId     string `json:"sku_id" binding:"omitempty,uuid"`
 	Quantity  uint16 `json:"quantity" binding:"required"`
 }
 
-// CartCheck is used to tick or un-tick a product in cart (to be purchased)
+// CartCheck is used to tick or un-tick a product in cart (to be purchased), every variant of the product is affected
+// when SkuId is omitted
 type CartCheck struct {
 	ProductID string `json:"id" binding:"required,uuid"`
+	SkuId     string `json:"sku_id" binding:"omitempty,uuid"`
 	State     *bool  `json:"state" binding:"required"`
 }
 
+// CartDelete remove a product from the cart, every variant of the product is removed when SkuId is omitted
+type CartDelete struct {
+	ID    string `form:"id" binding:"required,uuid"`
+	SkuId string `form:"sku_id" binding:"omitempty,uuid"`
+}
+
 type CheckAll struct {
 	State *bool `json:"state" binding:"required"`
 }
@@ -27,7 +27,9 @@ type PreCheckoutFreightItems struct {
 
 type PreCheckoutItem struct {
 	ProductId    string `json:"id"`
+	SkuId        string `json:"sku_id"`
 	ProductName  string `json:"name"`
+	Variant      string `json:"variant"`
 	ProductPrice uint   `json:"price"`
 	ProductImage string `json:"image"`
 	Quantity     uint16 `json:"quantity"`
@@ -44,6 +46,9 @@ type PreCheckoutFreight struct {
 // CheckoutItemInternal is used to store the product information that also need to put in the db
 type CheckoutItemInternal struct {
 	CheckoutItem
+	// CheckoutItem.Id is the sku id, as the same product may be bought in several variants
+	ProductId   string  `json:"product_id"`
+	Variant     string  `json:"variant"`
 	Description string  `json:"description"`
 	Weight      float64 `json:"weight"`
 }
@@ -22,6 +22,7 @@ package models
 
 import (
 	"mime/multipart"
+	"time"
 )
 
 // ProductCreate is the model for creating a new product on step 1
@@ -35,6 +36,14 @@ type ProductCreate struct {
 	Length       uint16  `json:"length" binding:"required"`
 	Width        uint16  `json:"width" binding:"required"`
 	Height       uint16  `json:"height" binding:"required"`
+	// BrandID is optional, 0 means the product has no brand
+	BrandID uint `json:"brand_id"`
+	// Sku is the optional stock keeping unit code of the default sku
+	Sku string `json:"sku" binding:"omitempty,max=64"`
+	// Gtin is the optional barcode of the default sku, see ValidGtin
+	Gtin string `json:"gtin"`
+	// Attributes map the attribute code of the category into its value
+	Attributes map[string]interface{} `json:"attributes"`
 }
 
 // ProductUpdate is the model for updating a product (also considered as step 1)
@@ -50,11 +59,18 @@ type ProductUpdate struct {
 	Length      uint16  `json:"length"`
 	Width       uint16  `json:"width"`
 	Height      uint16  `json:"height"`
+	// BrandID of 0 removes the brand of the product
+	BrandID *uint `json:"brand_id"`
+	// Attributes only update the given codes, a null value removes the value of the attribute
+	Attributes map[string]interface{} `json:"attributes"`
 }
 
+// ProductImage upload one or more pictures in a single request, AltTexts is matched to the pictures by their order
 type ProductImage struct {
-	ProductID string                `form:"product_id" binding:"required,uuid"`
-	Picture   *multipart.FileHeader `form:"picture" binding:"required"`
+	ProductID string                  `form:"product_id" binding:"required,uuid"`
+	SkuID     string                  `form:"sku_id" binding:"omitempty,uuid"`
+	Pictures  []*multipart.FileHeader `form:"picture" binding:"required,min=1,max=10"`
+	AltTexts  []string                `form:"alt_text" binding:"omitempty,dive,max=255"`
 }
 
 type ProductImageDelete struct {
@@ -62,7 +78,32 @@ type ProductImageDelete struct {
 	FileName  string `json:"file_name" binding:"required"`
 }
 
+// ProductImageUpdate change the alt text of the image or make it the primary image of the product
+type ProductImageUpdate struct {
+	ProductID string  `json:"product_id" binding:"required,uuid"`
+	FileName  string  `json:"file_name" binding:"required"`
+	AltText   *string `json:"alt_text" binding:"omitempty,max=255"`
+	Primary   bool    `json:"primary"`
+}
+
+// ProductImageReorder order every image of the product as listed
+type ProductImageReorder struct {
+	ProductID string   `json:"product_id" binding:"required,uuid"`
+	FileNames []string `json:"file_names" binding:"required,min=1,dive,required"`
+}
+
+type ProductImageResponse struct {
+	File string `json:"file"`
+	// Thumbnail is empty when the storage doesn't make thumbnails
+	Thumbnail string `json:"thumbnail,omitempty"`
+	AltText   string `json:"alt_text"`
+	Primary   bool   `json:"primary"`
+	SkuID     string `json:"sku_id,omitempty"`
+}
+
 type CategoryCreate struct {
+	// ParentID is nil for a root category
+	ParentID           *uint  `json:"parent_id"`
 	Name               string `json:"name" binding:"required"`
 	Description        string `json:"description" binding:"required"`
 	HomePageVisibility *bool  `json:"homepage_visibility" binding:"required"`
@@ -70,14 +111,50 @@ type CategoryCreate struct {
 
 type CategoryResponse struct {
 	ID                 uint   `json:"id"`
+	ParentID           *uint  `json:"parent_id"`
 	Name               string `json:"name"`
+	Slug               string `json:"slug"`
 	Description        string `json:"description"`
+	Position           uint   `json:"position"`
 	HomePageVisibility bool   `json:"homepage_visibility"`
+	HomePagePosition   uint   `json:"homepage_position"`
 }
 
 type CategoryResponseCompact struct {
-	ID   uint   `json:"id"`
-	Name string `json:"name"`
+	ID       uint   `json:"id"`
+	ParentID *uint  `json:"parent_id,omitempty"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+}
+
+// CategoryDetail is the category found by its slug
+type CategoryDetail struct {
+	ID           uint                      `json:"id"`
+	ParentID     *uint                     `json:"parent_id"`
+	Name         string                    `json:"name"`
+	Description  string                    `json:"description"`
+	Slug         string                    `json:"slug"`
+	CanonicalUrl string                    `json:"canonical_url"`
+	Breadcrumbs  []CategoryResponseCompact `json:"breadcrumbs"`
+}
+
+// CategoryMove put the category under another parent (nil for the root) at the position among its new siblings, the
+// category is put last when the position is not given
+type CategoryMove struct {
+	ID       uint  `json:"id" binding:"required"`
+	ParentID *uint `json:"parent_id"`
+	Position *uint `json:"position"`
+}
+
+// CategoryReorder order every child of the parent (nil for the root categories) as listed
+type CategoryReorder struct {
+	ParentID *uint  `json:"parent_id"`
+	IDs      []uint `json:"ids" binding:"required,min=1,dive,required"`
+}
+
+// CategoryHomepage is the ordered list of the categories shown on the homepage, the others are hidden
+type CategoryHomepage struct {
+	IDs []uint `json:"ids" binding:"required,dive,required"`
 }
 
 type CategoryUpdate struct {
@@ -99,22 +176,234 @@ type HomepageProductResponse struct {
 type HomepageProduct struct {
 	ID       string  `json:"id"`
 	Name     string  `json:"name"`
+	Slug     string  `json:"slug"`
 	Price    uint    `json:"price"`
 	ImageUrl string  `json:"image"`
 	Rating   float64 `json:"rating"`
 	Sold     uint    `json:"sold"`
 }
 
+// CatalogQuery is the query of a product listing, every filter is optional. LegacyLimit is kept for the clients
+// which still send the raw limit instead of page_size
+type CatalogQuery struct {
+	PageQuery
+	Search      string  `form:"search"`
+	Category    uint    `form:"category"`
+	Brand       uint    `form:"brand"`
+	PriceFrom   uint    `form:"price_from"`
+	PriceTo     uint    `form:"price_to"`
+	MinRating   float64 `form:"min_rating" binding:"omitempty,min=0,max=5"`
+	InStock     bool    `form:"in_stock"`
+	Sort        string  `form:"sort" binding:"omitempty,oneof=relevance newest price_asc price_desc best_selling rating"`
+	LegacyLimit uint    `form:"limit" binding:"omitempty,max=100"`
+	// Attributes map the attribute code into the wanted values, it is read from attr[code]=value by the handler. The
+	// values are separated by comma and a number range is written as min..max where either side can be empty
+	Attributes map[string]string `form:"-"`
+	// Status limit the listing to the products of the status, the public listing only shows the published products
+	Status string `form:"-"`
+}
+
+// StaffProductResponse is a row of the staff product table
+type StaffProductResponse struct {
+	HomepageProduct
+	Stock  uint   `json:"stock"`
+	Status string `json:"status"`
+}
+
+// ProductSuggestion is the as-you-type suggestion of the search box
+type ProductSuggestion struct {
+	Products   []ProductSuggestionItem   `json:"products"`
+	Categories []CategoryResponseCompact `json:"categories"`
+	Queries    []string                  `json:"queries"`
+}
+
+type ProductSuggestionItem struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Image string `json:"image"`
+}
+
 // ProductDetail is the model for product detail response (query by id)
 type ProductDetail struct {
-	ID               string   `json:"id"`
-	Name             string   `json:"name"`
-	Description      string   `json:"description"`
-	Price            uint     `json:"price"`
-	Weight           float64  `json:"weight"`
-	CategoryName     string   `json:"category_name"`
-	CumulativeReview float64  `json:"cumulative_review"`
-	ImageUrls        []string `json:"image_urls"`
-	Dimension        string   `json:"dimension"`
-	Stock            uint     `json:"stock"`
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Slug         string  `json:"slug"`
+	CanonicalUrl string  `json:"canonical_url"`
+	Description  string  `json:"description"`
+	Price        uint    `json:"price"`
+	Weight       float64 `json:"weight"`
+	CategoryName string  `json:"category_name"`
+	// Brand is null when the product has no brand
+	Brand *BrandResponseCompact `json:"brand"`
+	// Breadcrumbs is the path from the root category down to the category of the product
+	Breadcrumbs      []CategoryResponseCompact `json:"breadcrumbs"`
+	CumulativeReview float64                   `json:"cumulative_review"`
+	ImageUrls        []string                  `json:"image_urls"`
+	Images           []ProductImageResponse    `json:"images"`
+	Dimension        string                    `json:"dimension"`
+	Stock            uint                      `json:"stock"`
+	Attributes       []ProductAttributeValue   `json:"attributes"`
+	// Options and Skus form the variant matrix, a product without variant has no options and a single sku
+	Options []ProductOption `json:"options"`
+	Skus    []ProductSku    `json:"skus"`
+	// Status and the schedule are only given to the staff
+	Status      string     `json:"status,omitempty"`
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+	UnpublishAt *time.Time `json:"unpublish_at,omitempty"`
+}
+
+// CategoryAttribute is an attribute of the specification schema of a category
+type CategoryAttribute struct {
+	ID         uint     `json:"id"`
+	CategoryID uint     `json:"category_id"`
+	Code       string   `json:"code"`
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Unit       string   `json:"unit"`
+	Options    []string `json:"options"`
+	Required   bool     `json:"required"`
+	Filterable bool     `json:"filterable"`
+	Position   uint8    `json:"position"`
+}
+
+type CategoryAttributeCreate struct {
+	CategoryID uint     `json:"category_id" binding:"required"`
+	Code       string   `json:"code" binding:"required,max=32"`
+	Name       string   `json:"name" binding:"required,max=64"`
+	Type       string   `json:"type" binding:"required,oneof=text number enum boolean"`
+	Unit       string   `json:"unit" binding:"max=16"`
+	Options    []string `json:"options" binding:"required_if=Type enum,dive,required,max=255"`
+	Required   bool     `json:"required"`
+	Filterable *bool    `json:"filterable"`
+	Position   uint8    `json:"position"`
+}
+
+// CategoryAttributeUpdate can't change the code and the type as the stored values depend on them
+type CategoryAttributeUpdate struct {
+	ID         uint     `json:"id" binding:"required"`
+	Name       string   `json:"name" binding:"max=64"`
+	Unit       *string  `json:"unit" binding:"omitempty,max=16"`
+	Options    []string `json:"options" binding:"omitempty,dive,required,max=255"`
+	Required   *bool    `json:"required"`
+	Filterable *bool    `json:"filterable"`
+	Position   *uint8   `json:"position"`
+}
+
+type CategoryAttributeQuery struct {
+	CategoryID uint `form:"category_id" binding:"required"`
+}
+
+// ProductAttributeValue is the value of an attribute on the product detail, Value is a string, a number or a bool
+// depending on the type
+type ProductAttributeValue struct {
+	Code  string      `json:"code"`
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Unit  string      `json:"unit"`
+	Value interface{} `json:"value"`
+}
+
+// ProductOption is a variant dimension of a product e.g. size with its values
+type ProductOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ProductSku is a purchasable variant of a product, Options map the option name into its value
+type ProductSku struct {
+	ID        string            `json:"id"`
+	Sku       string            `json:"sku"`
+	Gtin      string            `json:"gtin"`
+	Price     uint              `json:"price"`
+	Weight    float64           `json:"weight"`
+	Dimension string            `json:"dimension"`
+	Stock     uint              `json:"stock"`
+	Options   map[string]string `json:"options"`
+	ImageUrls []string          `json:"image_urls"`
+}
+
+// ProductSkuCreate is the model for adding a variant into an existing product, nil price, weight and dimension are
+// inherited from the product
+type ProductSkuCreate struct {
+	ProductID string            `json:"product_id" binding:"required,uuid"`
+	Sku       string            `json:"sku" binding:"omitempty,max=64"`
+	Gtin      string            `json:"gtin"`
+	Options   map[string]string `json:"options" binding:"required,min=1,dive,keys,required,max=32,endkeys,required,max=32"`
+	Price     *uint             `json:"price"`
+	Weight    *float64          `json:"weight"`
+	Length    *uint16           `json:"length"`
+	Width     *uint16           `json:"width"`
+	Height    *uint16           `json:"height"`
+	Stock     uint              `json:"stock"`
+}
+
+// ProductSkuUpdate is the model for updating a sku, the options replace the current option values when it is given.
+// An empty sku or gtin removes it
+type ProductSkuUpdate struct {
+	ID      string            `json:"id" binding:"required,uuid"`
+	Sku     *string           `json:"sku" binding:"omitempty,max=64"`
+	Gtin    *string           `json:"gtin"`
+	Options map[string]string `json:"options" binding:"omitempty,dive,keys,required,max=32,endkeys,required,max=32"`
+	Price   *uint             `json:"price"`
+	Weight  *float64          `json:"weight"`
+	Length  *uint16           `json:"length"`
+	Width   *uint16           `json:"width"`
+	Height  *uint16           `json:"height"`
+	Stock   *uint             `json:"stock"`
+}
+
+// ProductImport is a csv or xlsx file with a header row, see the import columns in controllers/staff/import.go.
+// Nothing is written on a dry run
+type ProductImport struct {
+	File   *multipart.FileHeader `form:"file" binding:"required"`
+	DryRun bool                  `form:"dry_run"`
+}
+
+// ProductImportError is a problem of a row, Row is the row number as shown by the spreadsheet application
+type ProductImportError struct {
+	Row    int    `json:"row"`
+	Column string `json:"column,omitempty"`
+	Error  string `json:"error"`
+}
+
+// ProductImportResult list every row error, the products are only created when there is none
+type ProductImportResult struct {
+	DryRun  bool                 `json:"dry_run"`
+	Rows    int                  `json:"rows"`
+	Created []string             `json:"created"`
+	Errors  []ProductImportError `json:"errors"`
+}
+
+type ProductExportQuery struct {
+	Format string `form:"format" binding:"omitempty,oneof=csv xlsx"`
+}
+
+// ProductStatusUpdate set the status of a product along with its schedule, a null time clears it. PublishAt publish a
+// draft at the given time and UnpublishAt archive the product at the given time
+type ProductStatusUpdate struct {
+	ID          string     `json:"id" binding:"required,uuid"`
+	Status      string     `json:"status" binding:"required,oneof=draft published archived"`
+	PublishAt   *time.Time `json:"publish_at"`
+	UnpublishAt *time.Time `json:"unpublish_at"`
+}
+
+// ValidGtin report whether the gtin is a GTIN-8, GTIN-12 (UPC), GTIN-13 (EAN, ISBN) or GTIN-14 with a valid check digit
+func ValidGtin(gtin string) bool {
+	if len(gtin) != 8 && len(gtin) != 12 && len(gtin) != 13 && len(gtin) != 14 {
+		return false
+	}
+	sum := 0
+	for i := len(gtin) - 1; i >= 0; i-- {
+		if gtin[i] < '0' || gtin[i] > '9' {
+			return false
+		}
+		digit := int(gtin[i] - '0')
+		// the digits are weighted 3 and 1 alternately from the right, excluding the check digit
+		if (len(gtin)-1-i)%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return sum%10 == 0
 }
@@ -21,23 +21,63 @@
 package models
 
 import (
+	"strings"
 	"time"
 	"unicode"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// CustomerProfile is also used to update the profile, the email and phone number can only be changed through their
+// verification flow
 type CustomerProfile struct {
-	Email       string    `json:"email"`
-	PhoneNumber string    `json:"phone_number"`
-	FirstName   string    `json:"first_name"`
-	LastName    string    `json:"last_name"`
-	BirthDate   time.Time `json:"birth_date"`
-	Gender      string    `json:"gender"`
+	Email         string    `json:"email"`
+	PhoneNumber   string    `json:"phone_number"`
+	PhoneVerified bool      `json:"phone_verified"`
+	FirstName     string    `json:"first_name"`
+	LastName      string    `json:"last_name"`
+	BirthDate     time.Time `json:"birth_date"`
+	Gender        string    `json:"gender"`
+}
+
+type ReqEmailChange struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ReqPhoneVerification struct {
+	PhoneNumber string `json:"phone_number" binding:"required"`
+}
+
+type ReqVerificationCode struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// NormalizePhoneNumber strips the formatting of an Indonesian phone number and convert it into the international
+// format without "+" (e.g. 0812-3456-789 -> 628123456789)
+func NormalizePhoneNumber(phoneNumber string) (string, bool) {
+	var normalized strings.Builder
+	for _, char := range phoneNumber {
+		switch {
+		case unicode.IsDigit(char):
+			normalized.WriteRune(char)
+		case char == ' ' || char == '-' || char == '(' || char == ')' || char == '+' && normalized.Len() == 0:
+			continue
+		default:
+			return "", false
+		}
+	}
+	result := normalized.String()
+	if strings.HasPrefix(result, "0") {
+		result = "62" + result[1:]
+	}
+	if len(result) < 9 || len(result) > 15 {
+		return "", false
+	}
+	return result, true
 }
 
 type ChangePassword struct {
-	OldPassword string `json:"old_password" binding:"required"`
+	OldPassword string `json:"old_password"`
 	NewPassword string `json:"new_password" binding:"required"`
 }
 
@@ -85,3 +125,55 @@ func (s *ChangePassword) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(password), []byte(s.OldPassword))
 	return err == nil
 }
+
+type ReqDeleteAccount struct {
+	// Password is required when the customer has one, customer who only sign in through an oidc provider can leave it
+	// empty
+	Password string `json:"password"`
+}
+
+// CustomerDataExport is the personal data of a customer which is returned on the data export request
+type CustomerDataExport struct {
+	ExportedAt time.Time               `json:"exported_at"`
+	Profile    CustomerProfile         `json:"profile"`
+	Addresses  []AddressResponseDetail `json:"addresses"`
+	Orders     []OrderExport           `json:"orders"`
+	Reviews    []ReviewExport          `json:"reviews"`
+	Wishlist   []WishlistExport        `json:"wishlist"`
+}
+
+type OrderExport struct {
+	ID                uint64            `json:"id"`
+	AddressID         string            `json:"address_id"`
+	Courier           string            `json:"courier"`
+	TrackingCode      string            `json:"tracking_code"`
+	Status            string            `json:"status"`
+	StatusDescription string            `json:"status_description"`
+	PaymentType       string            `json:"payment_type"`
+	ItemCost          uint              `json:"item_cost"`
+	ShippingCost      uint              `json:"shipping_cost"`
+	TotalCost         uint              `json:"total_cost"`
+	CreatedAt         time.Time         `json:"created_at"`
+	Items             []OrderItemExport `json:"items"`
+}
+
+type OrderItemExport struct {
+	ProductID   string `json:"product_id"`
+	ProductName string `json:"product_name"`
+	Variant     string `json:"variant"`
+	Price       uint   `json:"price"`
+	Quantity    uint16 `json:"quantity"`
+}
+
+type ReviewExport struct {
+	OrderID     uint64 `json:"order_id"`
+	ProductID   string `json:"product_id"`
+	ProductName string `json:"product_name"`
+	Rating      uint8  `json:"rating"`
+	Review      string `json:"review"`
+}
+
+type WishlistExport struct {
+	ProductID   string `json:"product_id"`
+	ProductName string `json:"product_name"`
+}
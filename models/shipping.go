@@ -20,8 +20,11 @@
 
 package models
 
+// GetRatesByProductRequest estimate the freight of a product, the weight and dimension of the sku are used when
+// SkuID is given
 type GetRatesByProductRequest struct {
 	ProductID string `form:"product_id" binding:"uuid"`
+	SkuID     string `form:"sku_id" binding:"omitempty,uuid"`
 	AreaID    uint32 `form:"area_id" binding:"required"`
 }
 
@@ -20,7 +20,13 @@
 
 package models
 
-import "mime/multipart"
+import (
+	"encoding/json"
+	"mime/multipart"
+	"strings"
+	"time"
+	"unicode"
+)
 
 type InsertBanner struct {
 	Picture *multipart.FileHeader `form:"picture" binding:"required"`
@@ -32,3 +38,60 @@ type GetBanner struct {
 	ImageUrl string `json:"image_url"`
 	Href     string `json:"href"`
 }
+
+type BlacklistDomain struct {
+	Domain string `json:"domain" binding:"required"`
+}
+
+type BlacklistDomainQuery struct {
+	Domain string `form:"domain" binding:"required"`
+}
+
+// BlacklistDomainImport is a plain text file which contains one domain per line
+type BlacklistDomainImport struct {
+	File *multipart.FileHeader `form:"file" binding:"required"`
+}
+
+// NormalizeDomain lower the domain, strips the leading "@" and validate that it looks like a domain name
+func NormalizeDomain(domain string) (string, bool) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "@")
+	if len(domain) < 3 || len(domain) > 255 || !strings.Contains(domain, ".") {
+		return "", false
+	}
+	for _, char := range domain {
+		if !unicode.IsLetter(char) && !unicode.IsNumber(char) && char != '.' && char != '-' {
+			return "", false
+		}
+	}
+	if strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") || strings.Contains(domain, "..") {
+		return "", false
+	}
+	return domain, true
+}
+
+// AuditQuery filters the audit trail, every filter is optional and the date is in YYYY-MM-DD (inclusive)
+type AuditQuery struct {
+	StaffID  uint      `form:"staff_id"`
+	Entity   string    `form:"entity"`
+	EntityID string    `form:"entity_id"`
+	Action   string    `form:"action"`
+	From     time.Time `form:"from" time_format:"2006-01-02"`
+	To       time.Time `form:"to" time_format:"2006-01-02"`
+	Limit    uint      `form:"limit"`
+	Offset   uint      `form:"offset"`
+}
+
+type AuditResponse struct {
+	ID            uint64          `json:"id"`
+	StaffID       uint            `json:"staff_id"`
+	StaffUsername string          `json:"staff_username"`
+	ApiKeyID      uint            `json:"api_key_id,omitempty"`
+	Action        string          `json:"action"`
+	Entity        string          `json:"entity"`
+	EntityID      string          `json:"entity_id"`
+	Before        json.RawMessage `json:"before"`
+	After         json.RawMessage `json:"after"`
+	IpAddress     string          `json:"ip_address"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
@@ -21,8 +21,11 @@
 CREATE TABLE customers(
     id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
     email VARCHAR(72) UNIQUE NOT NULL,
-    hashed_password BINARY(60) NOT NULL,
+    -- hashed_password is null for customer who signed up through an oidc provider
+    hashed_password BINARY(60),
     phone_number VARCHAR(15) UNIQUE,
+    # phone_verified_at is set once the phone number is confirmed by otp
+    phone_verified_at DATETIME,
     first_name VARCHAR(50) NOT NULL,
     last_name VARCHAR(50) NOT NULL,
     birth_date DATETIME,
@@ -45,6 +48,19 @@ CREATE TABLE auth_logs(
       FOREIGN KEY (customer_refer) REFERENCES customers(id)
 );
 
+CREATE TABLE customer_identities(
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    customer_refer BINARY(16) NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    -- subject is the "sub" claim of the provider id token
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(72),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, subject),
+    UNIQUE (customer_refer, provider),
+    FOREIGN KEY (customer_refer) REFERENCES customers(id)
+);
+
 CREATE TABLE areas (
     code varchar(13) PRIMARY KEY,
     name varchar(100) NOT NULL ,
@@ -71,65 +87,211 @@ CREATE TABLE customer_addresses(
     FOREIGN KEY (shipping_area_refer) REFERENCES shipping_areas(id)
 );
 
+# categories form a tree, parent_refer is NULL on the root categories and position order the siblings
 CREATE TABLE categories(
     id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    parent_refer INT UNSIGNED,
     name VARCHAR(50) UNIQUE NOT NULL,
+    # slug is generated from the name, the previous slugs are kept in category_slug_history
+    slug VARCHAR(80) UNIQUE NOT NULL,
     description VARCHAR(255) NOT NULL,
+    position INT UNSIGNED NOT NULL DEFAULT 0,
     homepage_visibility BOOLEAN DEFAULT FALSE,
+    homepage_position INT UNSIGNED NOT NULL DEFAULT 0,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME,
     deleted_at DATETIME,
     INDEX category_name_idx(name),
-    INDEX category_homepage_visibility_idx(homepage_visibility)
+    INDEX category_parent_idx(parent_refer, position),
+    INDEX category_homepage_visibility_idx(homepage_visibility, homepage_position),
+    FOREIGN KEY (parent_refer) REFERENCES categories(id)
+);
+
+# logo is the file name on the image server, slug is the url of the brand page
+CREATE TABLE brands(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    slug VARCHAR(60) UNIQUE NOT NULL,
+    description VARCHAR(1000) NOT NULL DEFAULT '',
+    logo VARCHAR(41),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME,
+    deleted_at DATETIME
 );
 
 CREATE TABLE products(
     id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
     name VARCHAR(85) UNIQUE NOT NULL,
+    # slug is generated from the name, the previous slugs are kept in product_slug_history
+    slug VARCHAR(80) UNIQUE NOT NULL,
     description VARCHAR(300) NOT NULL,
     price INT UNSIGNED NOT NULL,
     weight DECIMAL(10,2) NOT NULL,
     category_refer INT UNSIGNED NOT NULL,
+    brand_refer INT UNSIGNED,
     cumulative_review DECIMAL(2,1) DEFAULT 0,
     length SMALLINT UNSIGNED NOT NULL,
     width SMALLINT UNSIGNED NOT NULL,
     height SMALLINT UNSIGNED NOT NULL,
+    # only the published products are shown on the storefront, a new product starts as a draft
+    status ENUM('draft', 'published', 'archived') NOT NULL DEFAULT 'draft',
+    # publish_at and unpublish_at are run by the product schedule job, they are cleared once they are applied
+    publish_at datetime,
+    unpublish_at datetime,
     created_at datetime DEFAULT CURRENT_TIMESTAMP,
     updated_at datetime,
     deleted_at datetime,
     INDEX product_check_exist_idx(id, deleted_at),
+    INDEX product_status_idx(status, deleted_at),
+    INDEX product_publish_at_idx(publish_at),
+    INDEX product_unpublish_at_idx(unpublish_at),
     INDEX product_category_idx(category_refer, deleted_at),
+    INDEX product_price_idx(deleted_at, price),
+    INDEX product_created_at_idx(deleted_at, created_at),
+    INDEX product_rating_idx(deleted_at, cumulative_review),
+    INDEX product_brand_idx(brand_refer, deleted_at),
     FULLTEXT INDEX product_name_idx(name),
+    FOREIGN KEY (category_refer) REFERENCES categories(id),
+    FOREIGN KEY (brand_refer) REFERENCES brands(id)
+);
+
+# the slugs a product had before it was renamed, they redirect to the current slug
+CREATE TABLE product_slug_history(
+    slug VARCHAR(80) PRIMARY KEY,
+    product_refer BINARY(16) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX product_slug_history_product_idx(product_refer),
+    FOREIGN KEY (product_refer) REFERENCES products(id)
+);
+
+CREATE TABLE category_slug_history(
+    slug VARCHAR(80) PRIMARY KEY,
+    category_refer INT UNSIGNED NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX category_slug_history_category_idx(category_refer),
     FOREIGN KEY (category_refer) REFERENCES categories(id)
 );
 
+# category_attributes is the specification schema of the products in a category e.g. voltage for electronics
+CREATE TABLE category_attributes(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    category_refer INT UNSIGNED NOT NULL,
+    # code is the key of the attribute on the product request and the listing filter e.g. voltage
+    code VARCHAR(32) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    type ENUM('text', 'number', 'enum', 'boolean') NOT NULL,
+    unit VARCHAR(16) NOT NULL DEFAULT '',
+    # options is the JSON array of the allowed values of an enum attribute
+    options JSON NULL,
+    required BOOLEAN DEFAULT FALSE,
+    filterable BOOLEAN DEFAULT TRUE,
+    position TINYINT UNSIGNED NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME,
+    UNIQUE(category_refer, code),
+    FOREIGN KEY (category_refer) REFERENCES categories(id)
+);
+
+# product_attribute_values hold a value per attribute, number is stored in value_number and the others in value_text
+CREATE TABLE product_attribute_values(
+    product_refer BINARY(16) NOT NULL,
+    attribute_refer INT UNSIGNED NOT NULL,
+    value_text VARCHAR(255) NULL,
+    value_number DECIMAL(16,4) NULL,
+    PRIMARY KEY (product_refer, attribute_refer),
+    INDEX product_attribute_text_idx(attribute_refer, value_text),
+    INDEX product_attribute_number_idx(attribute_refer, value_number),
+    FOREIGN KEY (product_refer) REFERENCES products(id),
+    FOREIGN KEY (attribute_refer) REFERENCES category_attributes(id) ON DELETE CASCADE
+);
+
+# every product has at least one sku, a product without variant options has a single sku with no option values
+CREATE TABLE product_skus(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    product_refer BINARY(16) NOT NULL,
+    sku VARCHAR(64) UNIQUE NULL,
+    # gtin is the optional barcode (EAN, UPC or ISBN) which is given to the product feeds
+    gtin VARCHAR(14) NULL,
+    # price, weight and dimension are inherited from the product when they are null
+    price INT UNSIGNED NULL,
+    weight DECIMAL(10,2) NULL,
+    length SMALLINT UNSIGNED NULL,
+    width SMALLINT UNSIGNED NULL,
+    height SMALLINT UNSIGNED NULL,
+    created_at datetime DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime,
+    deleted_at datetime,
+    INDEX product_skus_product_refer_idx(product_refer, deleted_at),
+    FOREIGN KEY (product_refer) REFERENCES products(id)
+);
+
+# product_options hold the variant dimension of a product e.g. size, color
+CREATE TABLE product_options(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    product_refer BINARY(16) NOT NULL,
+    name VARCHAR(32) NOT NULL,
+    position TINYINT UNSIGNED NOT NULL DEFAULT 0,
+    UNIQUE(product_refer, name),
+    FOREIGN KEY (product_refer) REFERENCES products(id)
+);
+
+CREATE TABLE product_option_values(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    option_refer INT UNSIGNED NOT NULL,
+    value VARCHAR(32) NOT NULL,
+    UNIQUE(option_refer, value),
+    FOREIGN KEY (option_refer) REFERENCES product_options(id) ON DELETE CASCADE
+);
+
+CREATE TABLE product_sku_values(
+    sku_refer BINARY(16) NOT NULL,
+    option_value_refer INT UNSIGNED NOT NULL,
+    PRIMARY KEY (sku_refer, option_value_refer),
+    FOREIGN KEY (sku_refer) REFERENCES product_skus(id),
+    FOREIGN KEY (option_value_refer) REFERENCES product_option_values(id)
+);
+
+# every product with an image has exactly one primary image, it is the image shown on the listings. primary_product
+# is only set on the primary image so the unique key allows a single primary image per product
 CREATE TABLE product_images(
     id BINARY(16)  PRIMARY KEY,
     product_refer BINARY(16) NOT NULL,
+    # sku_refer is set when the image belongs to a specific variant
+    sku_refer BINARY(16) NULL,
+    position INT UNSIGNED NOT NULL DEFAULT 0,
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+    primary_product BINARY(16) AS (IF(is_primary, product_refer, NULL)) STORED UNIQUE,
+    alt_text VARCHAR(255) NOT NULL DEFAULT '',
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    INDEX product_images_product_refer_idx(product_refer),
+    INDEX product_images_product_refer_idx(product_refer, position),
+    INDEX product_images_sku_refer_idx(sku_refer),
     INDEX product_images_created_at_idx(created_at),
-    FOREIGN KEY (product_refer) REFERENCES products(id)
+    FOREIGN KEY (product_refer) REFERENCES products(id),
+    FOREIGN KEY (sku_refer) REFERENCES product_skus(id)
 );
 
 CREATE TABLE inventories(
     id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
     product_refer BINARY(16) NOT NULL,
+    sku_refer BINARY(16) UNIQUE NOT NULL,
     quantity INT UNSIGNED NOT NULL,
     updated_at DATETIME,
     INDEX inventories_product_refer_idx(product_refer),
-    FOREIGN KEY (product_refer) REFERENCES products(id)
+    FOREIGN KEY (product_refer) REFERENCES products(id),
+    FOREIGN KEY (sku_refer) REFERENCES product_skus(id)
 );
 
 CREATE TABLE cart_items(
     id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
     product_refer BINARY(16) NOT NULL,
+    sku_refer BINARY(16) NOT NULL,
     customer_refer BINARY(16) NOT NULL,
     quantity SMALLINT UNSIGNED NOT NULL,
     checked BOOLEAN DEFAULT FALSE,
     INDEX cart_items_customer_idx(customer_refer),
-    UNIQUE(product_refer, customer_refer),
+    UNIQUE(sku_refer, customer_refer),
     FOREIGN KEY (product_refer) REFERENCES products(id),
+    FOREIGN KEY (sku_refer) REFERENCES product_skus(id),
     FOREIGN KEY (customer_refer) REFERENCES customers(id)
 );
 
@@ -183,14 +345,18 @@ CREATE TABLE order_items(
     id                 BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
     order_refer        BIGINT UNSIGNED   NOT NULL,
     product_refer      BINARY(16)        NOT NULL,
+    sku_refer          BINARY(16)        NOT NULL,
     on_buy_name        VARCHAR(85)       NOT NULL,
+    # on_buy_variant is the option values of the sku at the time of buying e.g. "Size: M, Color: Red"
+    on_buy_variant     VARCHAR(255)      NOT NULL DEFAULT '',
     on_buy_description VARCHAR(300)      NOT NULL,
     on_buy_price       INT UNSIGNED      NOT NULL,
     on_buy_weight      DECIMAL(10,2) NOT NULL,
     quantity           SMALLINT UNSIGNED NOT NULL,
     INDEX order_details_order_refer (order_refer),
     FOREIGN KEY (order_refer) REFERENCES orders(id),
-    FOREIGN KEY (product_refer) REFERENCES products (id)
+    FOREIGN KEY (product_refer) REFERENCES products (id),
+    FOREIGN KEY (sku_refer) REFERENCES product_skus (id)
 );
 
 CREATE TABLE reviews(
@@ -210,14 +376,84 @@ CREATE TABLE staffs(
     username VARCHAR(32) UNIQUE NOT NULL,
     hashed_password BINARY(60) NOT NULL,
     name VARCHAR(100) NOT NULL,
-    fin_user BOOLEAN NOT NULL DEFAULT FALSE,
-    inv_user BOOLEAN NOT NULL DEFAULT FALSE,
-    sys_admin BOOLEAN NOT NULL DEFAULT FALSE,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     deleted_at DATETIME,
     updated_at DATETIME
 );
 
+CREATE TABLE roles(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(32) UNIQUE NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME
+);
+
+CREATE TABLE role_permissions(
+    role_refer INT UNSIGNED NOT NULL,
+    # permission is one of auth.Permissions (e.g. product.write, order.ship)
+    permission VARCHAR(32) NOT NULL,
+    PRIMARY KEY (role_refer, permission),
+    FOREIGN KEY (role_refer) REFERENCES roles(id)
+);
+
+CREATE TABLE staff_roles(
+    staff_refer INT UNSIGNED NOT NULL,
+    role_refer INT UNSIGNED NOT NULL,
+    PRIMARY KEY (staff_refer, role_refer),
+    INDEX staff_roles_role_refer_idx(role_refer),
+    FOREIGN KEY (staff_refer) REFERENCES staffs(id),
+    FOREIGN KEY (role_refer) REFERENCES roles(id)
+);
+
+CREATE TABLE api_keys(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(64) NOT NULL,
+    # lookup is the plain part of the key (om_<lookup>.<secret>) used to find the key
+    lookup CHAR(12) UNIQUE NOT NULL,
+    # hashed_key is the sha256 of the whole key
+    hashed_key BINARY(32) NOT NULL,
+    # rate_limit is the number of requests allowed per minute, 0 is unlimited
+    rate_limit INT UNSIGNED NOT NULL DEFAULT 0,
+    created_by INT UNSIGNED NOT NULL,
+    expires_at DATETIME NOT NULL,
+    last_used_at DATETIME,
+    last_used_ip VARCHAR(45),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    revoked_at DATETIME,
+    FOREIGN KEY (created_by) REFERENCES staffs(id)
+);
+
+CREATE TABLE api_key_permissions(
+    api_key_refer INT UNSIGNED NOT NULL,
+    # permission is one of auth.Permissions (e.g. order.read, product.write)
+    permission VARCHAR(32) NOT NULL,
+    PRIMARY KEY (api_key_refer, permission),
+    FOREIGN KEY (api_key_refer) REFERENCES api_keys(id)
+);
+
+CREATE TABLE audit_logs(
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    staff_refer INT UNSIGNED NOT NULL,
+    # api_key_refer is set when the mutation is made with an api key issued by staff_refer
+    api_key_refer INT UNSIGNED,
+    # action can be create, update, delete
+    action VARCHAR(16) NOT NULL,
+    # entity is the kind of the target (e.g. product, category, staff) and entity_id is its id
+    entity VARCHAR(32) NOT NULL,
+    entity_id VARCHAR(64) NOT NULL,
+    # before_value and after_value only contain the fields which are changed
+    before_value JSON,
+    after_value JSON,
+    ip_address VARCHAR(45),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX audit_logs_staff_idx(staff_refer, created_at),
+    INDEX audit_logs_entity_idx(entity, entity_id, created_at),
+    INDEX audit_logs_created_at_idx(created_at),
+    FOREIGN KEY (staff_refer) REFERENCES staffs(id),
+    FOREIGN KEY (api_key_refer) REFERENCES api_keys(id)
+);
+
 CREATE TABLE homepage_banner(
     id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
     file_name varchar(41) NOT NULL,
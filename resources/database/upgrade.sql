@@ -0,0 +1,361 @@
+--  Copyright (c) 2023. Tus1688
+--
+--  Permission is hereby granted, free of charge, to any person obtaining a copy
+--  of this software and associated documentation files (the "Software"), to deal
+--  in the Software without restriction, including without limitation the rights
+--  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+--  copies of the Software, and to permit persons to whom the Software is
+--  furnished to do so, subject to the following conditions:
+--
+--  The above copyright notice and this permission notice shall be included in all
+--  copies or substantial portions of the Software.
+--
+--  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+--  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+--  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+--  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+--  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+--  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+--  SOFTWARE.
+
+# upgrade.sql bring a database which was created from the previous sqldump.sql up to the current schema, it has to be
+# run once before the new version is started. The DDL statements commit by themselves so take a backup first.
+#
+#   mysql -u root -p openmerce < resources/database/upgrade.sql
+#
+# Every part below upgrades the schema of one feature, they are in the order the features were added and each part
+# only relies on the parts above it.
+
+# customers can sign up through an oidc provider, they don't have a password in this case
+ALTER TABLE customers MODIFY hashed_password BINARY(60);
+
+CREATE TABLE customer_identities(
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    customer_refer BINARY(16) NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    -- subject is the "sub" claim of the provider id token
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(72),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, subject),
+    UNIQUE (customer_refer, provider),
+    FOREIGN KEY (customer_refer) REFERENCES customers(id)
+);
+
+# the staff flags become roles with the permissions of the routes the flags used to open, the superadmin role is
+# created by the api on startup
+CREATE TABLE roles(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(32) UNIQUE NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME
+);
+
+CREATE TABLE role_permissions(
+    role_refer INT UNSIGNED NOT NULL,
+    permission VARCHAR(32) NOT NULL,
+    PRIMARY KEY (role_refer, permission),
+    FOREIGN KEY (role_refer) REFERENCES roles(id)
+);
+
+CREATE TABLE staff_roles(
+    staff_refer INT UNSIGNED NOT NULL,
+    role_refer INT UNSIGNED NOT NULL,
+    PRIMARY KEY (staff_refer, role_refer),
+    INDEX staff_roles_role_refer_idx(role_refer),
+    FOREIGN KEY (staff_refer) REFERENCES staffs(id),
+    FOREIGN KEY (role_refer) REFERENCES roles(id)
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('inventory', 'was inv_user: products, categories and order shipping'),
+    ('finance', 'was fin_user: orders and refunds'),
+    ('system admin', 'was sys_admin: staff, banners and blacklisted domains');
+
+INSERT INTO role_permissions (role_refer, permission)
+SELECT r.id, p.permission FROM roles r INNER JOIN (
+    SELECT 'inventory' AS role, 'product.read' AS permission
+    UNION ALL SELECT 'inventory', 'product.write'
+    UNION ALL SELECT 'inventory', 'order.read'
+    UNION ALL SELECT 'inventory', 'order.ship'
+    UNION ALL SELECT 'finance', 'order.read'
+    UNION ALL SELECT 'finance', 'refund.issue'
+    UNION ALL SELECT 'system admin', 'staff.manage'
+    UNION ALL SELECT 'system admin', 'banner.manage'
+    UNION ALL SELECT 'system admin', 'blacklist.manage'
+) p ON p.role = r.name;
+
+INSERT INTO staff_roles (staff_refer, role_refer)
+SELECT s.id, r.id FROM staffs s INNER JOIN roles r
+    ON (r.name = 'inventory' AND s.inv_user) OR (r.name = 'finance' AND s.fin_user)
+           OR (r.name = 'system admin' AND s.sys_admin);
+
+ALTER TABLE staffs DROP fin_user, DROP inv_user, DROP sys_admin;
+
+# the staff mutations are recorded in the audit trail, the system admin role can read it
+CREATE TABLE audit_logs(
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    staff_refer INT UNSIGNED NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    entity VARCHAR(32) NOT NULL,
+    entity_id VARCHAR(64) NOT NULL,
+    before_value JSON,
+    after_value JSON,
+    ip_address VARCHAR(45),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX audit_logs_staff_idx(staff_refer, created_at),
+    INDEX audit_logs_entity_idx(entity, entity_id, created_at),
+    INDEX audit_logs_created_at_idx(created_at),
+    FOREIGN KEY (staff_refer) REFERENCES staffs(id)
+);
+
+INSERT INTO role_permissions (role_refer, permission) SELECT id, 'audit.read' FROM roles WHERE name = 'system admin';
+UPDATE roles SET description = 'was sys_admin: staff, banners, blacklisted domains and audit trail'
+WHERE name = 'system admin';
+
+# customers can verify their phone number with an otp
+ALTER TABLE customers ADD phone_verified_at DATETIME AFTER phone_number;
+
+# server to server api keys, a mutation made with a key is recorded with the key on the audit trail
+CREATE TABLE api_keys(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(64) NOT NULL,
+    lookup CHAR(12) UNIQUE NOT NULL,
+    hashed_key BINARY(32) NOT NULL,
+    rate_limit INT UNSIGNED NOT NULL DEFAULT 0,
+    created_by INT UNSIGNED NOT NULL,
+    expires_at DATETIME NOT NULL,
+    last_used_at DATETIME,
+    last_used_ip VARCHAR(45),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    revoked_at DATETIME,
+    FOREIGN KEY (created_by) REFERENCES staffs(id)
+);
+
+CREATE TABLE api_key_permissions(
+    api_key_refer INT UNSIGNED NOT NULL,
+    permission VARCHAR(32) NOT NULL,
+    PRIMARY KEY (api_key_refer, permission),
+    FOREIGN KEY (api_key_refer) REFERENCES api_keys(id)
+);
+
+ALTER TABLE audit_logs
+    ADD api_key_refer INT UNSIGNED AFTER staff_refer,
+    ADD FOREIGN KEY (api_key_refer) REFERENCES api_keys(id);
+
+# every product, including the deleted ones which are still referenced by the orders, gets its default sku without
+# option. The sku inherits the price, weight and dimension of the product
+CREATE TABLE product_skus(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    product_refer BINARY(16) NOT NULL,
+    sku VARCHAR(64) UNIQUE NULL,
+    price INT UNSIGNED NULL,
+    weight DECIMAL(10,2) NULL,
+    length SMALLINT UNSIGNED NULL,
+    width SMALLINT UNSIGNED NULL,
+    height SMALLINT UNSIGNED NULL,
+    created_at datetime DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime,
+    deleted_at datetime,
+    INDEX product_skus_product_refer_idx(product_refer, deleted_at),
+    FOREIGN KEY (product_refer) REFERENCES products(id)
+);
+
+CREATE TABLE product_options(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    product_refer BINARY(16) NOT NULL,
+    name VARCHAR(32) NOT NULL,
+    position TINYINT UNSIGNED NOT NULL DEFAULT 0,
+    UNIQUE(product_refer, name),
+    FOREIGN KEY (product_refer) REFERENCES products(id)
+);
+
+CREATE TABLE product_option_values(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    option_refer INT UNSIGNED NOT NULL,
+    value VARCHAR(32) NOT NULL,
+    UNIQUE(option_refer, value),
+    FOREIGN KEY (option_refer) REFERENCES product_options(id) ON DELETE CASCADE
+);
+
+CREATE TABLE product_sku_values(
+    sku_refer BINARY(16) NOT NULL,
+    option_value_refer INT UNSIGNED NOT NULL,
+    PRIMARY KEY (sku_refer, option_value_refer),
+    FOREIGN KEY (sku_refer) REFERENCES product_skus(id),
+    FOREIGN KEY (option_value_refer) REFERENCES product_option_values(id)
+);
+
+INSERT INTO product_skus (id, product_refer, created_at, deleted_at)
+SELECT UUID_TO_BIN(UUID()), id, created_at, deleted_at FROM products;
+
+ALTER TABLE product_images
+    ADD sku_refer BINARY(16) NULL AFTER product_refer,
+    ADD INDEX product_images_sku_refer_idx(sku_refer),
+    ADD FOREIGN KEY (sku_refer) REFERENCES product_skus(id);
+
+# the stock, the carts and the ordered items move to the default sku of their product
+ALTER TABLE inventories ADD sku_refer BINARY(16) NULL AFTER product_refer;
+UPDATE inventories i INNER JOIN product_skus s ON s.product_refer = i.product_refer SET i.sku_refer = s.id;
+INSERT INTO inventories (product_refer, sku_refer, quantity, updated_at)
+SELECT s.product_refer, s.id, 0, CURRENT_TIMESTAMP FROM product_skus s
+WHERE NOT EXISTS(SELECT 1 FROM inventories i WHERE i.sku_refer = s.id);
+ALTER TABLE inventories
+    MODIFY sku_refer BINARY(16) NOT NULL,
+    ADD UNIQUE (sku_refer),
+    ADD FOREIGN KEY (sku_refer) REFERENCES product_skus(id);
+
+ALTER TABLE cart_items ADD sku_refer BINARY(16) NULL AFTER product_refer;
+UPDATE cart_items c INNER JOIN product_skus s ON s.product_refer = c.product_refer SET c.sku_refer = s.id;
+# the unique key of the product is replaced by the one of the sku, product_refer keeps an index for its foreign key
+ALTER TABLE cart_items
+    MODIFY sku_refer BINARY(16) NOT NULL,
+    ADD INDEX cart_items_product_refer_idx(product_refer),
+    ADD UNIQUE (sku_refer, customer_refer),
+    ADD FOREIGN KEY (sku_refer) REFERENCES product_skus(id);
+ALTER TABLE cart_items DROP INDEX product_refer;
+
+ALTER TABLE order_items
+    ADD sku_refer BINARY(16) NULL AFTER product_refer,
+    ADD on_buy_variant VARCHAR(255) NOT NULL DEFAULT '' AFTER on_buy_name;
+UPDATE order_items o INNER JOIN product_skus s ON s.product_refer = o.product_refer SET o.sku_refer = s.id;
+ALTER TABLE order_items
+    MODIFY sku_refer BINARY(16) NOT NULL,
+    ADD FOREIGN KEY (sku_refer) REFERENCES product_skus (id);
+
+# the catalog listings are sorted by price, creation and rating
+ALTER TABLE products
+    ADD INDEX product_price_idx(deleted_at, price),
+    ADD INDEX product_created_at_idx(deleted_at, created_at),
+    ADD INDEX product_rating_idx(deleted_at, cumulative_review);
+
+# the categories describe the specification of their products
+CREATE TABLE category_attributes(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    category_refer INT UNSIGNED NOT NULL,
+    code VARCHAR(32) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    type ENUM('text', 'number', 'enum', 'boolean') NOT NULL,
+    unit VARCHAR(16) NOT NULL DEFAULT '',
+    options JSON NULL,
+    required BOOLEAN DEFAULT FALSE,
+    filterable BOOLEAN DEFAULT TRUE,
+    position TINYINT UNSIGNED NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME,
+    UNIQUE(category_refer, code),
+    FOREIGN KEY (category_refer) REFERENCES categories(id)
+);
+
+CREATE TABLE product_attribute_values(
+    product_refer BINARY(16) NOT NULL,
+    attribute_refer INT UNSIGNED NOT NULL,
+    value_text VARCHAR(255) NULL,
+    value_number DECIMAL(16,4) NULL,
+    PRIMARY KEY (product_refer, attribute_refer),
+    INDEX product_attribute_text_idx(attribute_refer, value_text),
+    INDEX product_attribute_number_idx(attribute_refer, value_number),
+    FOREIGN KEY (product_refer) REFERENCES products(id),
+    FOREIGN KEY (attribute_refer) REFERENCES category_attributes(id) ON DELETE CASCADE
+);
+
+# the existing categories become root categories ordered by their creation
+ALTER TABLE categories
+    ADD parent_refer INT UNSIGNED AFTER id,
+    ADD position INT UNSIGNED NOT NULL DEFAULT 0 AFTER description,
+    ADD homepage_position INT UNSIGNED NOT NULL DEFAULT 0 AFTER homepage_visibility,
+    DROP INDEX category_homepage_visibility_idx,
+    ADD INDEX category_parent_idx(parent_refer, position),
+    ADD INDEX category_homepage_visibility_idx(homepage_visibility, homepage_position),
+    ADD FOREIGN KEY (parent_refer) REFERENCES categories(id);
+
+UPDATE categories c
+    INNER JOIN (SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) - 1 AS position FROM categories) o ON o.id = c.id
+SET c.position = o.position, c.homepage_position = o.position;
+
+# the existing products have no brand
+CREATE TABLE brands(
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    slug VARCHAR(60) UNIQUE NOT NULL,
+    description VARCHAR(1000) NOT NULL DEFAULT '',
+    logo VARCHAR(41),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME,
+    deleted_at DATETIME
+);
+
+ALTER TABLE products
+    ADD brand_refer INT UNSIGNED AFTER category_refer,
+    ADD INDEX product_brand_idx(brand_refer, deleted_at),
+    ADD FOREIGN KEY (brand_refer) REFERENCES brands(id);
+
+# the oldest image of a product becomes its primary image
+ALTER TABLE product_images
+    ADD position INT UNSIGNED NOT NULL DEFAULT 0 AFTER sku_refer,
+    ADD is_primary BOOLEAN NOT NULL DEFAULT FALSE AFTER position,
+    ADD primary_product BINARY(16) AS (IF(is_primary, product_refer, NULL)) STORED UNIQUE AFTER is_primary,
+    ADD alt_text VARCHAR(255) NOT NULL DEFAULT '' AFTER primary_product,
+    DROP INDEX product_images_product_refer_idx,
+    ADD INDEX product_images_product_refer_idx(product_refer, position);
+
+UPDATE product_images i
+    INNER JOIN (
+        SELECT id, ROW_NUMBER() OVER (PARTITION BY product_refer ORDER BY created_at, id) - 1 AS position
+        FROM product_images
+    ) o ON o.id = i.id
+SET i.position = o.position, i.is_primary = (o.position = 0);
+
+# the existing products were visible on the storefront so they stay published
+ALTER TABLE products
+    ADD status ENUM('draft', 'published', 'archived') NOT NULL DEFAULT 'draft' AFTER height,
+    ADD publish_at datetime AFTER status,
+    ADD unpublish_at datetime AFTER publish_at,
+    ADD INDEX product_status_idx(status, deleted_at),
+    ADD INDEX product_publish_at_idx(publish_at),
+    ADD INDEX product_unpublish_at_idx(unpublish_at);
+
+UPDATE products SET status = 'published';
+
+# the slugs follow slugify in controllers/staff/brand.go, a number is appended to the duplicates e.g. "red-shoe-2"
+ALTER TABLE categories ADD slug VARCHAR(80) AFTER name;
+UPDATE categories
+SET slug = TRIM(BOTH '-' FROM LEFT(TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(name), '[^a-z0-9]+', '-')), 60));
+UPDATE categories SET slug = 'category' WHERE slug = '';
+UPDATE categories c
+    INNER JOIN (SELECT id, ROW_NUMBER() OVER (PARTITION BY slug ORDER BY created_at, id) AS n FROM categories) d
+    ON d.id = c.id
+SET c.slug = CONCAT(c.slug, '-', d.n)
+WHERE d.n > 1;
+ALTER TABLE categories MODIFY slug VARCHAR(80) NOT NULL, ADD UNIQUE (slug);
+
+ALTER TABLE products ADD slug VARCHAR(80) AFTER name;
+UPDATE products
+SET slug = TRIM(BOTH '-' FROM LEFT(TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(name), '[^a-z0-9]+', '-')), 60));
+UPDATE products SET slug = 'product' WHERE slug = '';
+UPDATE products p
+    INNER JOIN (SELECT id, ROW_NUMBER() OVER (PARTITION BY slug ORDER BY created_at, id) AS n FROM products) d
+    ON d.id = p.id
+SET p.slug = CONCAT(p.slug, '-', d.n)
+WHERE d.n > 1;
+ALTER TABLE products MODIFY slug VARCHAR(80) NOT NULL, ADD UNIQUE (slug);
+
+CREATE TABLE product_slug_history(
+    slug VARCHAR(80) PRIMARY KEY,
+    product_refer BINARY(16) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX product_slug_history_product_idx(product_refer),
+    FOREIGN KEY (product_refer) REFERENCES products(id)
+);
+
+CREATE TABLE category_slug_history(
+    slug VARCHAR(80) PRIMARY KEY,
+    category_refer INT UNSIGNED NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX category_slug_history_category_idx(category_refer),
+    FOREIGN KEY (category_refer) REFERENCES categories(id)
+);
+
+# the variants can carry a barcode for the product feeds
+ALTER TABLE product_skus ADD gtin VARCHAR(14) NULL AFTER sku;
@@ -0,0 +1,65 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package captcha
+
+import (
+	"log"
+	"os"
+)
+
+// Verifier check the token which the challenge widget gave to the client, the implementation is chosen by
+// CAPTCHA_PROVIDER
+type Verifier interface {
+	// Provider is the name of the widget the frontend has to render
+	Provider() string
+	Verify(token string, remoteIp string) (bool, error)
+}
+
+// DefaultVerifier is nil when CAPTCHA_PROVIDER is not set, the challenge is never required in this case
+var DefaultVerifier Verifier
+
+// SiteKey is the public key of the widget which is given to the frontend
+var SiteKey string
+
+func ReadEnv() {
+	SiteKey = os.Getenv("CAPTCHA_SITE_KEY")
+	secret := os.Getenv("CAPTCHA_SECRET_KEY")
+	switch os.Getenv("CAPTCHA_PROVIDER") {
+	case "turnstile":
+		DefaultVerifier = &SiteVerify{
+			Name: "turnstile", Url: "https://challenges.cloudflare.com/turnstile/v0/siteverify", Secret: secret,
+		}
+	case "hcaptcha":
+		DefaultVerifier = &SiteVerify{Name: "hcaptcha", Url: "https://api.hcaptcha.com/siteverify", Secret: secret}
+	case "recaptcha":
+		DefaultVerifier = &SiteVerify{
+			Name: "recaptcha", Url: "https://www.google.com/recaptcha/api/siteverify", Secret: secret,
+			MinScore: 0.5,
+		}
+	case "pass":
+		DefaultVerifier = &Fake{Pass: true}
+	case "fail":
+		DefaultVerifier = &Fake{Pass: false}
+	default:
+		log.Print("CAPTCHA_PROVIDER is not set, the bot challenge is disabled")
+		DefaultVerifier = nil
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package captcha
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+func (s *SiteVerify) Provider() string {
+	return s.Name
+}
+
+func (s *SiteVerify) Verify(token string, remoteIp string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	data := url.Values{"secret": {s.Secret}, "response": {token}}
+	if remoteIp != "" {
+		data.Set("remoteip", remoteIp)
+	}
+	res, err := client.Post(s.Url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return false, fmt.Errorf("%s siteverify returned status code %d", s.Name, res.StatusCode)
+	}
+	var response siteVerifyResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return false, err
+	}
+	if !response.Success {
+		return false, nil
+	}
+	if response.Score != nil && *response.Score < s.MinScore {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *Fake) Provider() string {
+	return "fake"
+}
+
+func (s *Fake) Verify(_ string, _ string) (bool, error) {
+	return s.Pass, nil
+}
@@ -179,7 +179,10 @@ func stockHandler(orderID string) {
 	// get the items in order first
 	var items []productHelper
 	rows, err := database.MysqlInstance.
-		Query("SELECT BIN_TO_UUID(product_refer), quantity FROM order_items WHERE order_refer = ?", orderID)
+		Query(
+			"SELECT BIN_TO_UUID(product_refer), BIN_TO_UUID(sku_refer), quantity FROM order_items WHERE order_refer = ?",
+			orderID,
+		)
 	if err != nil {
 		go logging.InsertLog(logging.ERROR, "midtrans stock handler error: unable to get items in order :"+orderID)
 		return
@@ -187,7 +190,7 @@ func stockHandler(orderID string) {
 	defer rows.Close()
 	for rows.Next() {
 		var item productHelper
-		err := rows.Scan(&item.productID, &item.quantity)
+		err := rows.Scan(&item.productID, &item.skuID, &item.quantity)
 		if err != nil {
 			go logging.InsertLog(logging.ERROR, "midtrans stock handler error: unable to scan items in order :"+orderID)
 			return
@@ -206,7 +209,7 @@ func stockHandler(orderID string) {
 	defer tx.Rollback()
 	// lock the rows of inventories table
 	_, err = tx.Exec(
-		"SELECT * FROM inventories WHERE id IN (SELECT product_refer FROM order_items WHERE order_refer = ?) FOR UPDATE",
+		"SELECT * FROM inventories WHERE sku_refer IN (SELECT sku_refer FROM order_items WHERE order_refer = ?) FOR UPDATE",
 		orderID,
 	)
 	if err != nil {
@@ -217,11 +220,11 @@ func stockHandler(orderID string) {
 	}
 	// update and make sure the stock after decreased is not negative
 	for _, item := range items {
-		// set the current quantity in inventories into quantity - item.quantity where id = item.productID and quantity >= item.quantity
+		// set the current quantity in inventories into quantity - item.quantity where sku = item.skuID and quantity >= item.quantity
 		res, err := tx.
 			Exec(
-				"UPDATE inventories SET quantity = quantity - ? WHERE product_refer = UUID_TO_BIN(?) AND quantity >= ?",
-				item.quantity, item.productID, item.quantity,
+				"UPDATE inventories SET quantity = quantity - ? WHERE sku_refer = UUID_TO_BIN(?) AND quantity >= ?",
+				item.quantity, item.skuID, item.quantity,
 			)
 		if err != nil {
 			go logging.InsertLog(
@@ -276,5 +279,6 @@ func stockHandler(orderID string) {
 
 type productHelper struct {
 	productID string
+	skuID     string
 	quantity  int
 }
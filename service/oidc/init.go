@@ -0,0 +1,73 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package oidc
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Providers holds every configured OpenID Connect provider keyed by its name (e.g. "google")
+var Providers = map[string]*Provider{}
+
+// FrontendRedirectUrl is where the customer is sent back to after the provider callback is handled
+var FrontendRedirectUrl string
+
+// HttpClient is used for discovery, token and jwks requests, it can be replaced to mock the provider
+var HttpClient = &http.Client{Timeout: 10 * time.Second}
+
+func ReadEnv() {
+	FrontendRedirectUrl = os.Getenv("OIDC_FRONTEND_REDIRECT_URL")
+	if clientId := os.Getenv("GOOGLE_CLIENT_ID"); clientId != "" {
+		issuer := os.Getenv("GOOGLE_ISSUER")
+		if issuer == "" {
+			issuer = "https://accounts.google.com"
+		}
+		Providers["google"] = &Provider{
+			Name:         "google",
+			Issuer:       issuer,
+			ClientId:     clientId,
+			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+			RedirectUrl:  os.Getenv("GOOGLE_REDIRECT_URL"),
+			Scopes:       []string{"openid", "email", "profile"},
+			TrustEmail:   true,
+		}
+	}
+	// generic provider, e.g. OIDC_NAME=keycloak OIDC_ISSUER=https://sso.example.com/realms/openmerce
+	if name := strings.ToLower(os.Getenv("OIDC_NAME")); name != "" && os.Getenv("OIDC_ISSUER") != "" {
+		scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+		Providers[name] = &Provider{
+			Name:         name,
+			Issuer:       os.Getenv("OIDC_ISSUER"),
+			ClientId:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectUrl:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       scopes,
+			// only the operator of the provider knows whether it verifies the emails of its users
+			TrustEmail: os.Getenv("OIDC_TRUST_EMAIL") == "true",
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testIssuer serve a discovery document and a jwks which can be rotated during the test
+type testIssuer struct {
+	server      *httptest.Server
+	mu          sync.Mutex
+	keys        map[string]*rsa.PrivateKey
+	jwksFetches int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	issuer := &testIssuer{keys: map[string]*rsa.PrivateKey{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(
+				Discovery{
+					Issuer:                issuer.server.URL,
+					AuthorizationEndpoint: issuer.server.URL + "/authorize",
+					TokenEndpoint:         issuer.server.URL + "/token",
+					JwksUri:               issuer.server.URL + "/jwks",
+				},
+			)
+		},
+	)
+	mux.HandleFunc(
+		"/jwks", func(w http.ResponseWriter, r *http.Request) {
+			issuer.mu.Lock()
+			defer issuer.mu.Unlock()
+			issuer.jwksFetches++
+			var set jwks
+			for kid, key := range issuer.keys {
+				set.Keys = append(
+					set.Keys, jwk{
+						Kid: kid,
+						Kty: "RSA",
+						Use: "sig",
+						N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+						E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+					},
+				)
+			}
+			_ = json.NewEncoder(w).Encode(set)
+		},
+	)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// rotate replace the published keys with a freshly generated key under kid
+func (i *testIssuer) rotate(t *testing.T, kid string) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.keys = map[string]*rsa.PrivateKey{kid: key}
+	return key
+}
+
+func (i *testIssuer) fetches() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.jwksFetches
+}
+
+func (i *testIssuer) provider() *Provider {
+	return &Provider{Name: "test", Issuer: i.server.URL, ClientId: "client"}
+}
+
+// claims return valid claims which the tests then tamper with
+func (i *testIssuer) claims() IdTokenClaims {
+	return IdTokenClaims{
+		Email: "customer@example.com",
+		Nonce: "nonce",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.server.URL,
+			Subject:   "subject",
+			Audience:  jwt.ClaimStrings{"client"},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims IdTokenClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifyIdToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	key := issuer.rotate(t, "k1")
+
+	valid := issuer.claims()
+	wrongNonce := issuer.claims()
+	wrongNonce.Nonce = "other"
+	wrongAudience := issuer.claims()
+	wrongAudience.Audience = jwt.ClaimStrings{"someone-else"}
+	expired := issuer.claims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	wrongIssuer := issuer.claims()
+	wrongIssuer.Issuer = "https://evil.example.com"
+
+	tests := []struct {
+		name    string
+		claims  IdTokenClaims
+		wantErr bool
+	}{
+		{"valid", valid, false},
+		{"nonce mismatch", wrongNonce, true},
+		{"wrong audience", wrongAudience, true},
+		{"expired", expired, true},
+		{"wrong issuer", wrongIssuer, true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				claims, err := issuer.provider().VerifyIdToken(sign(t, key, "k1", tt.claims), "nonce")
+				if tt.wantErr {
+					if err == nil {
+						t.Fatal("expected an error")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if claims.Subject != "subject" || claims.Email != "customer@example.com" {
+					t.Fatalf("unexpected claims %+v", claims)
+				}
+			},
+		)
+	}
+}
+
+func TestVerifyIdTokenRejectsForeignSignature(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.rotate(t, "k1")
+	foreign, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.provider().VerifyIdToken(sign(t, foreign, "k1", issuer.claims()), "nonce"); err == nil {
+		t.Fatal("expected a signature error")
+	}
+}
+
+func TestVerifyIdTokenKeyRotation(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider()
+
+	oldKey := issuer.rotate(t, "k1")
+	if _, err := provider.VerifyIdToken(sign(t, oldKey, "k1", issuer.claims()), "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIdToken(sign(t, oldKey, "k1", issuer.claims()), "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	if fetches := issuer.fetches(); fetches != 1 {
+		t.Fatalf("expected the jwks to be cached, fetched %d times", fetches)
+	}
+
+	newKey := issuer.rotate(t, "k2")
+	if _, err := provider.VerifyIdToken(sign(t, newKey, "k2", issuer.claims()), "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	if fetches := issuer.fetches(); fetches != 2 {
+		t.Fatalf("expected an unknown kid to re-fetch the jwks, fetched %d times", fetches)
+	}
+
+	// k1 is no longer published so tokens signed with it must be refused after the refresh
+	if _, err := provider.VerifyIdToken(sign(t, oldKey, "k1", issuer.claims()), "nonce"); err == nil {
+		t.Fatal("expected the retired key to be rejected")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/auth"
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/gin-gonic/gin"
+)
+
+// StateCookie is the cookie which binds the authorization request to the browser that started it
+const StateCookie = "oidc_state"
+
+// stateTTL is how long the customer has to finish the authentication on the provider
+const stateTTL = 10 * time.Minute
+
+// State is stored on redisInstance[8] with the state parameter as the key
+type State struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	// CustomerId is only filled when an authenticated customer links a provider to their account
+	CustomerId string `json:"customer_id,omitempty"`
+	RememberMe bool   `json:"remember_me"`
+}
+
+// CreateState store a new state for the provider and return the state parameter and the authorization url
+func (p *Provider) CreateState(customerId string, rememberMe bool) (string, string, error) {
+	state := auth.GenerateRandomString(32)
+	value := State{
+		Provider:   p.Name,
+		Nonce:      auth.GenerateRandomString(16),
+		CustomerId: customerId,
+		RememberMe: rememberMe,
+	}
+	authUrl, err := p.AuthCodeUrl(state, value.Nonce)
+	if err != nil {
+		return "", "", err
+	}
+	jsonString, err := json.Marshal(value)
+	if err != nil {
+		return "", "", err
+	}
+	err = database.RedisInstance[8].Set(context.Background(), state, jsonString, stateTTL).Err()
+	if err != nil {
+		return "", "", err
+	}
+	return state, authUrl, nil
+}
+
+// ConsumeState get and delete the state so that it can only be used once
+func ConsumeState(state string) (State, error) {
+	res, err := database.RedisInstance[8].GetDel(context.Background(), state).Result()
+	if err != nil {
+		return State{}, err
+	}
+	var value State
+	if err := json.Unmarshal([]byte(res), &value); err != nil {
+		return State{}, err
+	}
+	return value, nil
+}
+
+// SetStateCookie set the state cookie, it has to be SameSite=Lax as the callback is a cross-site redirect from the
+// provider
+func SetStateCookie(c *gin.Context, state string) {
+	auth.SetCookieWithPath(c, StateCookie, state, "/api/v1/auth/oidc", int(stateTTL.Seconds()), stateSameSite())
+}
+
+func ClearStateCookie(c *gin.Context) {
+	auth.SetCookieWithPath(c, StateCookie, "", "/api/v1/auth/oidc", -1, stateSameSite())
+}
+
+// stateSameSite only loosen the configured mode, SameSite=None is kept as it is
+func stateSameSite() http.SameSite {
+	if auth.Cookie.SameSite == http.SameSiteNoneMode {
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package otp
+
+import (
+	"log"
+	"os"
+)
+
+// Sender deliver a one time password to a phone number, the implementation is chosen by OTP_SENDER
+type Sender interface {
+	SendOtp(phoneNumber string, code string) error
+}
+
+// DefaultSender is used by the phone verification flow, it is nil when OTP_SENDER is not set and the phone
+// verification is disabled in this case
+var DefaultSender Sender
+
+func ReadEnv() {
+	switch os.Getenv("OTP_SENDER") {
+	case "twilio":
+		DefaultSender = &Twilio{
+			AccountSid: os.Getenv("TWILIO_ACCOUNT_SID"),
+			AuthToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+			From:       os.Getenv("TWILIO_FROM"),
+		}
+	case "whatsapp":
+		DefaultSender = &WhatsApp{
+			PhoneNumberId: os.Getenv("WHATSAPP_PHONE_NUMBER_ID"),
+			AccessToken:   os.Getenv("WHATSAPP_ACCESS_TOKEN"),
+			TemplateName:  os.Getenv("WHATSAPP_TEMPLATE_NAME"),
+			LanguageCode:  os.Getenv("WHATSAPP_LANGUAGE_CODE"),
+		}
+	case "fake":
+		// local development only, the codes are never delivered
+		log.Print("OTP_SENDER is fake, one time passwords are not delivered")
+		DefaultSender = &Fake{}
+	case "":
+		// falling back to the fake sender would go unnoticed, the phone verification is refused instead
+		log.Print("OTP_SENDER is not set, the phone verification is disabled")
+		DefaultSender = nil
+	default:
+		log.Fatal("OTP_SENDER must be twilio, whatsapp or fake")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package otp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var fakeMu sync.Mutex
+
+func (s *Twilio) SendOtp(phoneNumber string, code string) error {
+	baseUrl := "https://api.twilio.com/2010-04-01/Accounts/" + s.AccountSid + "/Messages.json"
+	data := url.Values{
+		"To":   {"+" + phoneNumber},
+		"From": {s.From},
+		"Body": {"Your Openmerce verification code is: " + code},
+	}
+	req, err := http.NewRequest("POST", baseUrl, strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.AccountSid, s.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 201 {
+		return fmt.Errorf("twilio returned status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (s *WhatsApp) SendOtp(phoneNumber string, code string) error {
+	baseUrl := "https://graph.facebook.com/v17.0/" + s.PhoneNumberId + "/messages"
+	languageCode := s.LanguageCode
+	if languageCode == "" {
+		languageCode = "id"
+	}
+	parameters := []whatsAppParameter{{Type: "text", Text: code}}
+	body, err := json.Marshal(
+		whatsAppMessage{
+			MessagingProduct: "whatsapp",
+			To:               phoneNumber,
+			Type:             "template",
+			Template: whatsAppTemplate{
+				Name:     s.TemplateName,
+				Language: whatsAppLanguage{Code: languageCode},
+				Components: []whatsAppComponent{
+					{Type: "body", Parameters: parameters},
+					// authentication templates have a copy code button which also needs the code
+					{Type: "button", SubType: "url", Index: "0", Parameters: parameters},
+				},
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", baseUrl, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.AccessToken)
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("whatsapp returned status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (s *Fake) SendOtp(phoneNumber string, code string) error {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	if s.Sent == nil {
+		s.Sent = map[string]string{}
+	}
+	s.Sent[phoneNumber] = code
+	return nil
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package search
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// priceBuckets are the lower bounds of the price facet in rupiah
+var priceBuckets = []uint{0, 50000, 100000, 250000, 500000, 1000000}
+
+var (
+	synonymMu sync.RWMutex
+	// synonyms map a word into the other words of its group
+	synonyms = map[string][]string{}
+)
+
+func init() {
+	AddSynonyms("hp", "handphone", "ponsel", "smartphone")
+	AddSynonyms("laptop", "notebook")
+	AddSynonyms("kaos", "kaus", "tshirt")
+	AddSynonyms("celana", "pants")
+	AddSynonyms("sepatu", "shoes")
+	AddSynonyms("tas", "bag")
+	AddSynonyms("jam", "arloji")
+}
+
+// AddSynonyms register a group of words which are searched as each other
+func AddSynonyms(words ...string) {
+	synonymMu.Lock()
+	defer synonymMu.Unlock()
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		for _, other := range words {
+			other = strings.ToLower(strings.TrimSpace(other))
+			if other != word && other != "" {
+				synonyms[word] = append(synonyms[word], other)
+			}
+		}
+	}
+}
+
+func synonymsOf(word string) []string {
+	synonymMu.RLock()
+	defer synonymMu.RUnlock()
+	return synonyms[word]
+}
+
+// Tokenize lowercase the text and split it on anything which is not a letter or a digit, so the operators of the user
+// input are never interpreted
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Stem reduce an indonesian word into its root by removing the particle, possessive and derivational suffixes and
+// then the prefixes. It is a light version of the Nazief-Adriani algorithm without a dictionary, the same word
+// always gives the same root which is enough for matching
+func Stem(word string) string {
+	if len([]rune(word)) <= 4 || strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+		return word
+	}
+	w := trimSuffix(word, "lah", "kah", "tah", "pun")
+	w = trimSuffix(w, "nya", "ku", "mu")
+	w = trimSuffix(w, "kan", "an", "i")
+	for _, prefix := range []string{"di", "ke", "se", "ter", "ber", "be"} {
+		if root, ok := cutPrefix(w, prefix); ok {
+			return root
+		}
+	}
+	for _, nasal := range []string{"me", "pe"} {
+		rest, ok := strings.CutPrefix(w, nasal)
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(rest, "ng") && len(rest) > 4:
+			return rest[2:]
+		case strings.HasPrefix(rest, "ny") && len(rest) > 4:
+			return "s" + rest[2:]
+		case strings.HasPrefix(rest, "m") && len(rest) > 3:
+			if isVowel(rest[1]) {
+				return "p" + rest[1:]
+			}
+			return rest[1:]
+		case strings.HasPrefix(rest, "n") && len(rest) > 3:
+			if isVowel(rest[1]) {
+				return "t" + rest[1:]
+			}
+			return rest[1:]
+		case strings.HasPrefix(rest, "r") && len(rest) > 3:
+			return rest[1:]
+		case len(rest) > 3 && (rest[0] == 'l' || rest[0] == 'w' || rest[0] == 'y'):
+			return rest
+		}
+	}
+	return w
+}
+
+func trimSuffix(word string, suffixes ...string) string {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= 4 {
+			return word[:len(word)-len(suffix)]
+		}
+	}
+	return word
+}
+
+func cutPrefix(word string, prefix string) (string, bool) {
+	if strings.HasPrefix(word, prefix) && len(word)-len(prefix) >= 4 {
+		return word[len(prefix):], true
+	}
+	return word, false
+}
+
+func isVowel(b byte) bool {
+	return strings.IndexByte("aiueo", b) >= 0
+}
+
+// Terms return the indexed terms of a text, both the word and its root are kept so a prefix of the word still matches
+func Terms(text string) []string {
+	var terms []string
+	for _, token := range Tokenize(text) {
+		terms = append(terms, token)
+		if root := Stem(token); root != token {
+			terms = append(terms, root)
+		}
+	}
+	return terms
+}
+
+// priceBucket return the index of the bucket of the price
+func priceBucket(price uint) int {
+	bucket := 0
+	for i, from := range priceBuckets {
+		if price >= from {
+			bucket = i
+		}
+	}
+	return bucket
+}
+
+func priceFacet(bucket int, count int) PriceFacet {
+	facet := PriceFacet{From: priceBuckets[bucket], Count: count}
+	if bucket+1 < len(priceBuckets) {
+		facet.To = priceBuckets[bucket+1] - 1
+	}
+	return facet
+}
+
+// maxTypos is the edit distance which is tolerated for a word of the length
+func maxTypos(word string) int {
+	switch n := len([]rune(word)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// withinDistance check if the levenshtein distance of a and b is not more than max
+func withinDistance(a string, b string, max int) bool {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return false
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return false
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)] <= max
+}
+
+func min(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
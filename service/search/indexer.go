@@ -0,0 +1,155 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package search
+
+import (
+	"log"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+)
+
+// documentQuery select the searchable products at the price of their cheapest variant, the filter is appended by
+// the caller
+const documentQuery = `
+	SELECT BIN_TO_UUID(p.id), p.name, p.description, p.category_refer, c.name, COALESCE(b.id, 0), COALESCE(b.name, ''),
+	       COALESCE((SELECT MIN(COALESCE(s.price, p.price)) FROM product_skus s
+	                 WHERE s.product_refer = p.id AND s.deleted_at IS NULL), p.price)
+	FROM products p
+	         INNER JOIN categories c ON c.id = p.category_refer
+	         LEFT JOIN brands b ON b.id = p.brand_refer AND b.deleted_at IS NULL
+	WHERE p.deleted_at IS NULL AND p.status = 'published'`
+
+// Rebuild clear the index and index every product, it is run on start as the memory engine starts empty
+func Rebuild() error {
+	if DefaultEngine == nil {
+		return nil
+	}
+	docs, err := loadDocuments("")
+	if err != nil {
+		return err
+	}
+	if err := DefaultEngine.Clear(); err != nil {
+		return err
+	}
+	if err := DefaultEngine.Index(docs...); err != nil {
+		return err
+	}
+	log.Printf("Indexed %d products into the %s search engine", len(docs), DefaultEngine.Name())
+	ClearSuggestionCache()
+	return nil
+}
+
+// WatchIndex rebuild the memory index every interval so that each instance picks up the changes made on the other
+// instances, e.g. a scheduled publish applied by another instance. A shared engine like meilisearch doesn't need it
+func WatchIndex(interval time.Duration) {
+	memory, ok := DefaultEngine.(*Memory)
+	if !ok {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		docs, err := loadDocuments("")
+		if err == nil {
+			err = memory.Replace(docs...)
+		}
+		if err != nil {
+			logging.InsertLog(logging.ERROR, "search resync: "+err.Error())
+		}
+	}
+}
+
+// Sync index the product again or remove it from the index when it is deleted, it is supposed to run in another
+// goroutine after the product is changed
+func Sync(productId string) {
+	if DefaultEngine == nil {
+		ClearSuggestionCache()
+		return
+	}
+	docs, err := loadDocuments(" AND p.id = UUID_TO_BIN(?)", productId)
+	if err == nil {
+		if len(docs) == 0 {
+			err = DefaultEngine.Delete(productId)
+		} else {
+			err = DefaultEngine.Index(docs...)
+		}
+	}
+	if err != nil {
+		logging.InsertLog(logging.ERROR, "search sync product "+productId+": "+err.Error())
+		return
+	}
+	ClearSuggestionCache()
+}
+
+// SyncCategory index every product of the category again as the category name is searchable
+func SyncCategory(categoryId uint) {
+	if DefaultEngine == nil {
+		ClearSuggestionCache()
+		return
+	}
+	docs, err := loadDocuments(" AND p.category_refer = ?", categoryId)
+	if err == nil {
+		err = DefaultEngine.Index(docs...)
+	}
+	if err != nil {
+		logging.InsertLog(logging.ERROR, "search sync category: "+err.Error())
+		return
+	}
+	ClearSuggestionCache()
+}
+
+// SyncBrand index every product of the brand again as the brand name is searchable
+func SyncBrand(brandId uint) {
+	if DefaultEngine == nil {
+		ClearSuggestionCache()
+		return
+	}
+	docs, err := loadDocuments(" AND p.brand_refer = ?", brandId)
+	if err == nil {
+		err = DefaultEngine.Index(docs...)
+	}
+	if err != nil {
+		logging.InsertLog(logging.ERROR, "search sync brand: "+err.Error())
+		return
+	}
+	ClearSuggestionCache()
+}
+
+func loadDocuments(filter string, args ...interface{}) ([]Document, error) {
+	rows, err := database.MysqlInstance.Query(documentQuery+filter, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var docs []Document
+	for rows.Next() {
+		var doc Document
+		if err := rows.Scan(
+			&doc.ID, &doc.Name, &doc.Description, &doc.CategoryID, &doc.CategoryName, &doc.BrandID, &doc.BrandName,
+			&doc.Price,
+		); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
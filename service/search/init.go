@@ -0,0 +1,71 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package search
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// Engine keep the product search index and answer the full text part of the product listing, the implementation is
+// chosen by SEARCH_ENGINE
+type Engine interface {
+	Name() string
+	// Index add or replace the documents
+	Index(docs ...Document) error
+	Delete(ids ...string) error
+	// Clear remove every document, it is called before the index is rebuilt
+	Clear() error
+	Search(query Query) (Result, error)
+}
+
+// DefaultEngine is nil when SEARCH_ENGINE is "mysql", the listing falls back to the mysql fulltext index in this case
+var DefaultEngine Engine
+
+// MaxHits is the maximum number of ids a search returns, the pagination of the listing is done over these ids
+const MaxHits = 1000
+
+func ReadEnv() {
+	// comma separated groups of words which are separated by "|", e.g. hp|handphone|ponsel,kaos|baju
+	for _, group := range strings.Split(os.Getenv("SEARCH_SYNONYMS"), ",") {
+		if words := strings.Split(group, "|"); len(words) > 1 {
+			AddSynonyms(words...)
+		}
+	}
+	switch os.Getenv("SEARCH_ENGINE") {
+	case "mysql":
+		log.Print("SEARCH_ENGINE is mysql, the search falls back to the fulltext index on the product name")
+		DefaultEngine = nil
+	case "meilisearch":
+		index := os.Getenv("MEILISEARCH_INDEX")
+		if index == "" {
+			index = "products"
+		}
+		DefaultEngine = &Meilisearch{
+			Url:       strings.TrimSuffix(os.Getenv("MEILISEARCH_URL"), "/"),
+			ApiKey:    os.Getenv("MEILISEARCH_API_KEY"),
+			IndexName: index,
+		}
+	default:
+		DefaultEngine = NewMemory()
+	}
+}
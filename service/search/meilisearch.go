@@ -0,0 +1,186 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+func (m *Meilisearch) Name() string {
+	return "meilisearch"
+}
+
+func (m *Meilisearch) Index(docs ...Document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	body := make([]meiliDocument, 0, len(docs))
+	for _, doc := range docs {
+		document := meiliDocument{
+			Document:      doc,
+			Terms:         strings.Join(Terms(doc.Name+" "+doc.Description), " "),
+			CategoryFacet: fmt.Sprintf("%d|%s", doc.CategoryID, doc.CategoryName),
+			PriceBucket:   priceBucket(doc.Price),
+		}
+		if doc.BrandID != 0 {
+			document.BrandFacet = fmt.Sprintf("%d|%s", doc.BrandID, doc.BrandName)
+		}
+		body = append(body, document)
+	}
+	return m.do(http.MethodPost, "/documents", body, nil)
+}
+
+func (m *Meilisearch) Delete(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return m.do(http.MethodPost, "/documents/delete-batch", ids, nil)
+}
+
+// Clear remove every document and push the settings of the index, it is called before the index is rebuilt
+func (m *Meilisearch) Clear() error {
+	if err := m.do(http.MethodDelete, "/documents", nil, nil); err != nil {
+		return err
+	}
+	synonymMu.RLock()
+	settings := map[string]interface{}{
+		"searchableAttributes": []string{"name", "brand_name", "category_name", "terms", "description"},
+		"filterableAttributes": []string{
+			"category_id", "brand_id", "price", "category_facet", "brand_facet", "price_bucket",
+		},
+		"synonyms": synonyms,
+	}
+	err := m.do(http.MethodPatch, "/settings", settings, nil)
+	synonymMu.RUnlock()
+	return err
+}
+
+func (m *Meilisearch) Search(query Query) (Result, error) {
+	result := Result{Facets: Facets{Categories: []CategoryFacet{}, Brands: []BrandFacet{}, Prices: []PriceFacet{}}}
+	tokens := Tokenize(query.Text)
+	if len(tokens) == 0 {
+		return result, nil
+	}
+	request := meiliSearchRequest{
+		Q:                    strings.Join(tokens, " "),
+		Facets:               []string{"category_facet", "brand_facet", "price_bucket"},
+		Limit:                MaxHits,
+		AttributesToRetrieve: []string{"id"},
+	}
+	if len(query.Categories) > 0 {
+		ids := make([]string, len(query.Categories))
+		for i, id := range query.Categories {
+			ids[i] = strconv.FormatUint(uint64(id), 10)
+		}
+		request.Filter = append(request.Filter, "category_id IN ["+strings.Join(ids, ", ")+"]")
+	}
+	if query.Brand != 0 {
+		request.Filter = append(request.Filter, "brand_id = "+strconv.FormatUint(uint64(query.Brand), 10))
+	}
+	if query.PriceFrom != 0 {
+		request.Filter = append(request.Filter, "price >= "+strconv.FormatUint(uint64(query.PriceFrom), 10))
+	}
+	if query.PriceTo != 0 {
+		request.Filter = append(request.Filter, "price <= "+strconv.FormatUint(uint64(query.PriceTo), 10))
+	}
+	var response meiliSearchResponse
+	if err := m.do(http.MethodPost, "/search", request, &response); err != nil {
+		return result, err
+	}
+	for _, hit := range response.Hits {
+		result.IDs = append(result.IDs, hit.ID)
+	}
+	result.Total = response.EstimatedTotalHits
+	for value, count := range response.FacetDistribution["category_facet"] {
+		id, name, _ := strings.Cut(value, "|")
+		categoryId, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			continue
+		}
+		result.Facets.Categories = append(
+			result.Facets.Categories, CategoryFacet{ID: uint(categoryId), Name: name, Count: count},
+		)
+	}
+	sort.Slice(result.Facets.Categories, func(i, j int) bool {
+		return result.Facets.Categories[i].Count > result.Facets.Categories[j].Count
+	})
+	for value, count := range response.FacetDistribution["brand_facet"] {
+		id, name, _ := strings.Cut(value, "|")
+		brandId, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			continue
+		}
+		result.Facets.Brands = append(result.Facets.Brands, BrandFacet{ID: uint(brandId), Name: name, Count: count})
+	}
+	sort.Slice(result.Facets.Brands, func(i, j int) bool {
+		return result.Facets.Brands[i].Count > result.Facets.Brands[j].Count
+	})
+	for bucket := range priceBuckets {
+		if count := response.FacetDistribution["price_bucket"][strconv.Itoa(bucket)]; count > 0 {
+			result.Facets.Prices = append(result.Facets.Prices, priceFacet(bucket, count))
+		}
+	}
+	return result, nil
+}
+
+func (m *Meilisearch) do(method string, path string, body interface{}, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, m.Url+"/indexes/"+url.PathEscape(m.IndexName)+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.ApiKey)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// the document and settings endpoints are asynchronous and answer 202
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("meilisearch returned status code %d on %s %s", res.StatusCode, method, path)
+	}
+	if out != nil {
+		return json.NewDecoder(res.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field weights of the memory engine, a match on the name is worth more than a match on the description
+const (
+	weightName        = 3
+	weightBrand       = 2
+	weightCategory    = 2
+	weightDescription = 1
+)
+
+// Memory is the embedded engine, it keeps an inverted index of the products in memory and is rebuilt from mysql on
+// every start. The changes made on the same instance are synced right away, the ones made on another instance are
+// picked up by WatchIndex
+type Memory struct {
+	mu       sync.RWMutex
+	docs     map[string]Document
+	postings map[string]map[string]float64 // term -> document id -> weight
+	docTerms map[string][]string
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		docs:     map[string]Document{},
+		postings: map[string]map[string]float64{},
+		docTerms: map[string][]string{},
+	}
+}
+
+func (m *Memory) Name() string {
+	return "memory"
+}
+
+func (m *Memory) Index(docs ...Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, doc := range docs {
+		m.remove(doc.ID)
+		weights := map[string]float64{}
+		for _, term := range Terms(doc.Name) {
+			weights[term] += weightName
+		}
+		for _, term := range Terms(doc.BrandName) {
+			weights[term] += weightBrand
+		}
+		for _, term := range Terms(doc.CategoryName) {
+			weights[term] += weightCategory
+		}
+		for _, term := range Terms(doc.Description) {
+			weights[term] += weightDescription
+		}
+		terms := make([]string, 0, len(weights))
+		for term, weight := range weights {
+			if m.postings[term] == nil {
+				m.postings[term] = map[string]float64{}
+			}
+			m.postings[term][doc.ID] = weight
+			terms = append(terms, term)
+		}
+		m.docs[doc.ID] = doc
+		m.docTerms[doc.ID] = terms
+	}
+	return nil
+}
+
+func (m *Memory) Delete(ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		m.remove(id)
+	}
+	return nil
+}
+
+func (m *Memory) Clear() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs = map[string]Document{}
+	m.postings = map[string]map[string]float64{}
+	m.docTerms = map[string][]string{}
+	return nil
+}
+
+// Replace swap the whole index at once so that the searches never see a partly built index
+func (m *Memory) Replace(docs ...Document) error {
+	fresh := NewMemory()
+	if err := fresh.Index(docs...); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs, m.postings, m.docTerms = fresh.docs, fresh.postings, fresh.docTerms
+	return nil
+}
+
+// remove must be called with the lock held
+func (m *Memory) remove(id string) {
+	for _, term := range m.docTerms[id] {
+		delete(m.postings[term], id)
+		if len(m.postings[term]) == 0 {
+			delete(m.postings, term)
+		}
+	}
+	delete(m.docTerms, id)
+	delete(m.docs, id)
+}
+
+// Search match every token of the query, the last token is also matched as a prefix as the user may still be typing
+func (m *Memory) Search(query Query) (Result, error) {
+	tokens := Tokenize(query.Text)
+	if len(tokens) == 0 {
+		return Result{Facets: Facets{Categories: []CategoryFacet{}, Brands: []BrandFacet{}, Prices: []PriceFacet{}}}, nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var scores map[string]float64
+	for i, token := range tokens {
+		matched := m.match(token, i == len(tokens)-1)
+		if scores == nil {
+			scores = matched
+			continue
+		}
+		for id, score := range scores {
+			if add, ok := matched[id]; ok {
+				scores[id] = score + add
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	// each facet is counted without its own filter so the other choices of the facet are still shown
+	categories := map[uint]bool{}
+	for _, id := range query.Categories {
+		categories[id] = true
+	}
+	categoryCount := map[uint]int{}
+	brandCount := map[uint]int{}
+	priceCount := map[int]int{}
+	var ids []string
+	for id := range scores {
+		doc := m.docs[id]
+		inCategory := len(categories) == 0 || categories[doc.CategoryID]
+		inPrice := (query.PriceFrom == 0 || doc.Price >= query.PriceFrom) && (query.PriceTo == 0 || doc.Price <= query.PriceTo)
+		inBrand := query.Brand == 0 || doc.BrandID == query.Brand
+		if inPrice && inBrand {
+			categoryCount[doc.CategoryID]++
+		}
+		if inCategory && inPrice && doc.BrandID != 0 {
+			brandCount[doc.BrandID]++
+		}
+		if inCategory && inBrand {
+			priceCount[priceBucket(doc.Price)]++
+		}
+		if inCategory && inPrice && inBrand {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	result := Result{Total: len(ids), IDs: ids}
+	if len(ids) > MaxHits {
+		result.IDs = ids[:MaxHits]
+	}
+	categoryNames := map[uint]string{}
+	brandNames := map[uint]string{}
+	for id := range scores {
+		categoryNames[m.docs[id].CategoryID] = m.docs[id].CategoryName
+		brandNames[m.docs[id].BrandID] = m.docs[id].BrandName
+	}
+	result.Facets.Categories = []CategoryFacet{}
+	for id, count := range categoryCount {
+		result.Facets.Categories = append(
+			result.Facets.Categories, CategoryFacet{ID: id, Name: categoryNames[id], Count: count},
+		)
+	}
+	sort.Slice(result.Facets.Categories, func(i, j int) bool {
+		if result.Facets.Categories[i].Count != result.Facets.Categories[j].Count {
+			return result.Facets.Categories[i].Count > result.Facets.Categories[j].Count
+		}
+		return result.Facets.Categories[i].ID < result.Facets.Categories[j].ID
+	})
+	result.Facets.Brands = []BrandFacet{}
+	for id, count := range brandCount {
+		result.Facets.Brands = append(result.Facets.Brands, BrandFacet{ID: id, Name: brandNames[id], Count: count})
+	}
+	sort.Slice(result.Facets.Brands, func(i, j int) bool {
+		if result.Facets.Brands[i].Count != result.Facets.Brands[j].Count {
+			return result.Facets.Brands[i].Count > result.Facets.Brands[j].Count
+		}
+		return result.Facets.Brands[i].ID < result.Facets.Brands[j].ID
+	})
+	result.Facets.Prices = []PriceFacet{}
+	for bucket := range priceBuckets {
+		if count := priceCount[bucket]; count > 0 {
+			result.Facets.Prices = append(result.Facets.Prices, priceFacet(bucket, count))
+		}
+	}
+	return result, nil
+}
+
+// match return the score of every document which has a term matching the token, the exact word or its root and
+// synonyms score the most followed by the prefix and the typo tolerant match
+func (m *Memory) match(token string, prefix bool) map[string]float64 {
+	exact := map[string]bool{token: true, Stem(token): true}
+	for _, synonym := range synonymsOf(token) {
+		exact[synonym] = true
+		exact[Stem(synonym)] = true
+	}
+	typos := maxTypos(token)
+	matched := map[string]float64{}
+	for term, posting := range m.postings {
+		var factor float64
+		switch {
+		case exact[term]:
+			factor = 1
+		case prefix && len(token) >= 2 && strings.HasPrefix(term, token):
+			factor = 0.8
+		case typos > 0 && withinDistance(token, term, typos):
+			factor = 0.5
+		default:
+			continue
+		}
+		for id, weight := range posting {
+			if score := weight * factor; score > matched[id] {
+				matched[id] = score
+			}
+		}
+	}
+	return matched
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package search
+
+// Document is the searchable representation of a product
+type Document struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	CategoryID   uint   `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	// BrandID is 0 when the product has no brand
+	BrandID   uint   `json:"brand_id"`
+	BrandName string `json:"brand_name"`
+	Price     uint   `json:"price"`
+}
+
+// Query is the full text query with the filters which affect the facets, the other filters are applied by mysql
+type Query struct {
+	Text string
+	// Categories is the filtered category along with its descendants
+	Categories []uint
+	Brand      uint
+	PriceFrom  uint
+	PriceTo    uint
+}
+
+// Result hold the matching product ids ordered by relevance
+type Result struct {
+	IDs    []string
+	Total  int
+	Facets Facets
+}
+
+type Facets struct {
+	Categories []CategoryFacet `json:"categories"`
+	Brands     []BrandFacet    `json:"brands"`
+	Prices     []PriceFacet    `json:"prices"`
+}
+
+type CategoryFacet struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type BrandFacet struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// PriceFacet count the products in a price bucket, To is 0 on the last bucket which has no upper bound
+type PriceFacet struct {
+	From  uint `json:"from"`
+	To    uint `json:"to"`
+	Count int  `json:"count"`
+}
+
+// Meilisearch is the optional external engine, the documents are stored in a single index
+type Meilisearch struct {
+	Url       string
+	ApiKey    string
+	IndexName string
+}
+
+// meiliDocument carry the stemmed terms and the facet values along the document as meilisearch has no indonesian
+// stemmer and can't bucket the price by itself
+type meiliDocument struct {
+	Document
+	Terms         string `json:"terms"`
+	CategoryFacet string `json:"category_facet"`
+	BrandFacet    string `json:"brand_facet,omitempty"`
+	PriceBucket   int    `json:"price_bucket"`
+}
+
+type meiliSearchRequest struct {
+	Q                    string   `json:"q"`
+	Filter               []string `json:"filter,omitempty"`
+	Facets               []string `json:"facets"`
+	Limit                int      `json:"limit"`
+	AttributesToRetrieve []string `json:"attributesToRetrieve"`
+}
+
+type meiliSearchResponse struct {
+	Hits []struct {
+		ID string `json:"id"`
+	} `json:"hits"`
+	EstimatedTotalHits int                       `json:"estimatedTotalHits"`
+	FacetDistribution  map[string]map[string]int `json:"facetDistribution"`
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package search
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/openmerce-backend/database"
+	"github.com/Tus1688/openmerce-backend/logging"
+)
+
+const (
+	suggestionPrefix    = "suggest:"
+	SuggestionTTL       = time.Hour
+	popularQueriesKey   = "popular_queries"
+	maxPopularQueries   = 1000
+	minSuggestionLength = 2
+)
+
+// NormalizeQuery lowercase the query and strip everything which is not a word, so the same search is counted and
+// cached once. An empty string is returned when the query is too short to be suggested
+func NormalizeQuery(query string) string {
+	normalized := strings.Join(Tokenize(query), " ")
+	if len([]rune(normalized)) < minSuggestionLength {
+		return ""
+	}
+	return normalized
+}
+
+// SuggestionCacheKey is the redis key of the cached suggestion of a normalized query
+func SuggestionCacheKey(query string) string {
+	return suggestionPrefix + query
+}
+
+// RecordQuery count a search which has results, only the most searched queries are kept. It is supposed to run in
+// another goroutine
+func RecordQuery(query string) {
+	if query = NormalizeQuery(query); query == "" {
+		return
+	}
+	ctx := context.Background()
+	pipe := database.RedisInstance[11].TxPipeline()
+	pipe.ZIncrBy(ctx, popularQueriesKey, 1, query)
+	pipe.ZRemRangeByRank(ctx, popularQueriesKey, 0, -maxPopularQueries-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logging.InsertLog(logging.ERROR, "search record query: "+err.Error())
+	}
+}
+
+// PopularQueries return the most searched queries which start with the normalized query
+func PopularQueries(query string, limit int) ([]string, error) {
+	queries, err := database.RedisInstance[11].
+		ZRevRange(context.Background(), popularQueriesKey, 0, maxPopularQueries-1).
+		Result()
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, popular := range queries {
+		if popular != query && strings.HasPrefix(popular, query) {
+			result = append(result, popular)
+			if len(result) == limit {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// ClearSuggestionCache remove every cached suggestion, it is called whenever a product is indexed again
+func ClearSuggestionCache() {
+	ctx := context.Background()
+	iter := database.RedisInstance[11].Scan(ctx, 0, suggestionPrefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		if err := database.RedisInstance[11].Del(ctx, iter.Val()).Err(); err != nil {
+			logging.InsertLog(logging.ERROR, "search clear suggestion cache: "+err.Error())
+		}
+	}
+	if err := iter.Err(); err != nil {
+		logging.InsertLog(logging.ERROR, "search clear suggestion cache: "+err.Error())
+	}
+}
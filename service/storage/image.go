@@ -0,0 +1,130 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var converter = Converter{Binary: "cwebp", Quality: 80, ThumbnailWidth: 400}
+
+// ThumbnailName is the file name of the thumbnail of an uploaded image, only the local and s3 stores make thumbnails
+func ThumbnailName(fileName string) string {
+	return strings.TrimSuffix(fileName, ".webp") + "_thumb.webp"
+}
+
+// uploadConverted encode the image and its thumbnail into webp and store both under a new uuid, it is shared by the
+// stores which don't convert the image by themselves
+func uploadConverted(data []byte, put func(name string, data []byte) error) (string, error) {
+	width, _, err := Dimension(data)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := converter.Convert(data, 0)
+	if err != nil {
+		return "", err
+	}
+	// a small image is not upscaled, the thumbnail is the image itself
+	thumbnail := encoded
+	if width > converter.ThumbnailWidth {
+		thumbnail, err = converter.Convert(data, converter.ThumbnailWidth)
+		if err != nil {
+			return "", err
+		}
+	}
+	name := uuid.New().String() + ".webp"
+	if err := put(name, encoded); err != nil {
+		return "", err
+	}
+	if err := put(ThumbnailName(name), thumbnail); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Convert encode the jpeg, png or webp image into webp, the image is resized to the width when it is not 0
+func (c Converter) Convert(data []byte, width int) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "webp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input")
+	output := filepath.Join(dir, "output.webp")
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		return nil, err
+	}
+	args := []string{"-quiet", "-metadata", "none", "-q", strconv.Itoa(c.Quality)}
+	if width > 0 {
+		args = append(args, "-resize", strconv.Itoa(width), "0")
+	}
+	args = append(args, input, "-o", output)
+	if out, err := exec.Command(c.Binary, args...).CombinedOutput(); err != nil {
+		return nil, errors.New(c.Binary + ": " + err.Error() + ": " + strings.TrimSpace(string(out)))
+	}
+	return os.ReadFile(output)
+}
+
+// Dimension return the size of a jpeg, png or webp image without decoding the pixels. The webp header is read by
+// hand as the standard library has no webp decoder
+func Dimension(data []byte) (int, int, error) {
+	if len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP" {
+		return webpDimension(data)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	return config.Width, config.Height, err
+}
+
+func webpDimension(data []byte) (int, int, error) {
+	if len(data) < 30 {
+		return 0, 0, errors.New("webp header is too short")
+	}
+	switch string(data[12:16]) {
+	case "VP8 ":
+		// the 3 bytes frame tag and the 3 bytes start code precede the 14 bits dimensions
+		width := int(binary.LittleEndian.Uint16(data[26:28]) & 0x3fff)
+		height := int(binary.LittleEndian.Uint16(data[28:30]) & 0x3fff)
+		return width, height, nil
+	case "VP8L":
+		if data[20] != 0x2f {
+			return 0, 0, errors.New("invalid lossless webp signature")
+		}
+		bits := binary.LittleEndian.Uint32(data[21:25])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	case "VP8X":
+		width := int(data[24]) | int(data[25])<<8 | int(data[26])<<16
+		height := int(data[27]) | int(data[28])<<8 | int(data[29])<<16
+		return width + 1, height + 1, nil
+	}
+	return 0, 0, errors.New("unknown webp chunk")
+}
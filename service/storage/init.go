@@ -0,0 +1,115 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package storage
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// BlobStore keep the uploaded images, the implementation is chosen by STORAGE_BACKEND
+type BlobStore interface {
+	Name() string
+	// Upload store the image and return the file name it is served by. The image is converted into webp along with
+	// its thumbnail when the store doesn't do it by itself
+	Upload(filename string, data []byte) (string, error)
+	// Delete remove the file along with its thumbnail, a file which doesn't exist is not an error
+	Delete(fileName string) error
+}
+
+var DefaultStore BlobStore
+
+// MediaUrl is the public base url of the stored files, it is set by MEDIA_BASE_URL or derived from the store
+var MediaUrl string
+
+// Url return the public url of a stored file
+func Url(fileName string) string {
+	return MediaUrl + "/" + fileName
+}
+
+// Thumbnails report whether the store keeps a thumbnail of every image, see ThumbnailName
+func Thumbnails() bool {
+	_, ok := DefaultStore.(*NginxFS)
+	return !ok
+}
+
+func ReadEnv() {
+	quality, err := strconv.Atoi(os.Getenv("WEBP_QUALITY"))
+	if err == nil && quality > 0 && quality <= 100 {
+		converter.Quality = quality
+	}
+	width, err := strconv.Atoi(os.Getenv("THUMBNAIL_WIDTH"))
+	if err == nil && width > 0 {
+		converter.ThumbnailWidth = width
+	}
+	if binary := os.Getenv("WEBP_ENCODER"); binary != "" {
+		converter.Binary = binary
+	}
+
+	switch os.Getenv("STORAGE_BACKEND") {
+	case "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "media"
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatal("unable to create STORAGE_LOCAL_DIR: ", err)
+		}
+		DefaultStore = &Local{Dir: dir}
+	case "s3":
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		DefaultStore = &S3{
+			Endpoint:  strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+			Region:    region,
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		}
+	default:
+		DefaultStore = &NginxFS{
+			BaseUrl:       strings.TrimSuffix(os.Getenv("NGINX_FS_BASE_URL"), "/"),
+			Authorization: os.Getenv("NGINX_FS_AUTHORIZATION"),
+		}
+	}
+	MediaUrl = strings.TrimSuffix(os.Getenv("MEDIA_BASE_URL"), "/")
+	if MediaUrl == "" {
+		switch store := DefaultStore.(type) {
+		case *Local:
+			MediaUrl = "/media"
+		case *S3:
+			MediaUrl = store.Endpoint + "/" + store.Bucket
+		case *NginxFS:
+			MediaUrl = store.BaseUrl
+		}
+	}
+	// go-nginx-fs converts the image by itself, the other stores need the encoder
+	if _, ok := DefaultStore.(*NginxFS); !ok {
+		if _, err := exec.LookPath(converter.Binary); err != nil {
+			log.Fatalf("STORAGE_BACKEND %s needs the webp encoder %s: %v", DefaultStore.Name(), converter.Binary, err)
+		}
+	}
+}
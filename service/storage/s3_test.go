@@ -0,0 +1,181 @@
+// Copyright (c) 2023. Tus1688
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// s3Stub record the requests and check their signature the way s3 would
+type s3Stub struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string][]byte
+	deletes []string
+	status  int
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("x-amz-content-sha256") != sha256Hex(body) {
+		s.t.Errorf("%s %s: payload hash does not match the body", r.Method, r.URL.Path)
+	}
+	if r.Header.Get("Authorization") != expectedAuthorization(r) {
+		s.t.Errorf("%s %s: unexpected authorization %s", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if r.Header.Get("Content-Type") != "image/webp" {
+			s.t.Errorf("PUT %s: unexpected content type %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		s.objects[r.URL.Path] = body
+	case http.MethodDelete:
+		s.deletes = append(s.deletes, r.URL.Path)
+		if _, ok := s.objects[r.URL.Path]; !ok {
+			w.WriteHeader(404)
+			return
+		}
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(204)
+	}
+}
+
+// expectedAuthorization rebuild the signature version 4 from what the server received
+func expectedAuthorization(r *http.Request) string {
+	amzDate := r.Header.Get("x-amz-date")
+	if len(amzDate) < 8 {
+		return ""
+	}
+	date := amzDate[:8]
+	canonicalRequest := strings.Join(
+		[]string{
+			r.Method, r.URL.EscapedPath(), "",
+			"host:" + r.Host, "x-amz-content-sha256:" + r.Header.Get("x-amz-content-sha256"), "x-amz-date:" + amzDate, "",
+			"host;x-amz-content-sha256;x-amz-date", r.Header.Get("x-amz-content-sha256"),
+		}, "\n",
+	)
+	scope := date + "/test-region/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSha256([]byte("AWS4secret"), date)
+	key = hmacSha256(key, "test-region")
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+	return "AWS4-HMAC-SHA256 Credential=access/" + scope +
+		", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=" + signature
+}
+
+func newS3Stub(t *testing.T) (*S3, *s3Stub) {
+	t.Helper()
+	stub := &s3Stub{t: t, objects: map[string][]byte{}}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	store := &S3{Endpoint: server.URL, Region: "test-region", Bucket: "bucket", AccessKey: "access", SecretKey: "secret"}
+	return store, stub
+}
+
+// fakeEncoder replace cwebp with a script copying the input to the output so the test doesn't need the encoder
+func fakeEncoder(t *testing.T) {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "cwebp")
+	// the arguments end with "<input> -o <output>"
+	content := "#!/bin/sh\neval in=\\${$(($# - 2))}\neval out=\\${$#}\ncp \"$in\" \"$out\"\n"
+	if err := os.WriteFile(script, []byte(content), 0700); err != nil {
+		t.Fatal(err)
+	}
+	previous := converter
+	converter.Binary = script
+	t.Cleanup(func() { converter = previous })
+}
+
+func testPng(t *testing.T, width int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, 10))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestS3UploadAndDelete(t *testing.T) {
+	fakeEncoder(t)
+	store, stub := newS3Stub(t)
+	data := testPng(t, converter.ThumbnailWidth+100)
+
+	name, err := store.Upload("ignored.png", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(name, ".webp") {
+		t.Fatalf("unexpected file name %s", name)
+	}
+	for _, key := range []string{"/bucket/" + name, "/bucket/" + ThumbnailName(name)} {
+		if !bytes.Equal(stub.objects[key], data) {
+			t.Fatalf("expected %s to be uploaded", key)
+		}
+	}
+
+	if err := store.Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.objects) != 0 {
+		t.Fatalf("expected every object to be deleted, left %d", len(stub.objects))
+	}
+	if len(stub.deletes) != 2 || stub.deletes[1] != "/bucket/"+ThumbnailName(name) {
+		t.Fatalf("expected the image and its thumbnail to be deleted, got %v", stub.deletes)
+	}
+}
+
+func TestS3DeleteMissing(t *testing.T) {
+	store, _ := newS3Stub(t)
+	if err := store.Delete("missing.webp"); err != nil {
+		t.Fatalf("expected a missing object not to be an error, got %v", err)
+	}
+}
+
+func TestS3Error(t *testing.T) {
+	fakeEncoder(t)
+	store, stub := newS3Stub(t)
+	stub.status = 403
+	if _, err := store.Upload("ignored.png", testPng(t, 10)); err == nil {
+		t.Fatal("expected a rejected upload to fail")
+	}
+	if err := store.Delete("image.webp"); err == nil {
+		t.Fatal("expected a rejected delete to fail")
+	}
+}